@@ -2,52 +2,241 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/Mark-Life/smart-webp-resize/internal/api"
+	"github.com/Mark-Life/smart-webp-resize/internal/archive"
+	"github.com/Mark-Life/smart-webp-resize/internal/auth"
+	"github.com/Mark-Life/smart-webp-resize/internal/cache"
+	"github.com/Mark-Life/smart-webp-resize/internal/fetch"
 	"github.com/Mark-Life/smart-webp-resize/internal/handler"
+	"github.com/Mark-Life/smart-webp-resize/internal/jobs"
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+	"github.com/Mark-Life/smart-webp-resize/internal/metrics"
+	"github.com/Mark-Life/smart-webp-resize/internal/middleware"
 	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+	"github.com/Mark-Life/smart-webp-resize/internal/ratelimit"
+	"github.com/Mark-Life/smart-webp-resize/internal/storage"
+	"github.com/Mark-Life/smart-webp-resize/internal/tracing"
+	"github.com/Mark-Life/smart-webp-resize/internal/webhook"
 	"github.com/Mark-Life/smart-webp-resize/pkg/config"
+	"github.com/Mark-Life/smart-webp-resize/pkg/signing"
 )
 
 func main() {
-	log.Println("Starting Smart WebP Resizer service...")
-	
 	// Load configuration
 	cfg := config.New()
-	
+
+	// Set up structured logging; the standard logger is routed through it too
+	logger := logging.New(os.Stdout, cfg.LogLevel, cfg.LogFormat)
+	slog.SetDefault(logger)
+
+	log.Println("Starting Smart WebP Resizer service...")
+
+	// Set up tracing; spans are only exported if an exporter is configured
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.TracingExporter,
+		FilePath:    cfg.TracingFile,
+		ServiceName: "smart-webp-resize",
+		SampleRatio: cfg.TracingSampleRatio,
+	})
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	// Metrics are always collected; the endpoint is only exposed if enabled
+	appMetrics := metrics.New()
+
 	// Create dependencies
-	imageHandler := handler.NewImageHandler()
-	imageProcessor := processor.New()
-	
+	// URLs may only be fetched from public addresses and permitted hosts,
+	// within the configured size and time limits
+	fetchPolicy, err := newFetchPolicy(cfg)
+	if err != nil {
+		log.Fatalf("Invalid fetch configuration: %v", err)
+	}
+	fetcher, err := newFetcher(cfg, fetchPolicy)
+	if err != nil {
+		log.Fatalf("Invalid fetch configuration: %v", err)
+	}
+	appMetrics.RegisterFetcher(fetcher)
+	handlerOptions := []handler.Option{handler.WithFetcher(fetcher)}
+	if cfg.SourceCacheMaxSize > 0 {
+		// Remote sources are revalidated with their origin instead of downloaded again
+		handlerOptions = append(handlerOptions, handler.WithSourceCache(cache.NewSourceCache(cfg.SourceCacheMaxSize)))
+	}
+	// Besides HTTP and data URLs, images may come from configured buckets
+	// and directories
+	if len(cfg.SourceS3Buckets) > 0 {
+		source, err := handler.NewS3Source(s3Config(cfg), cfg.SourceS3Buckets, cfg.FetchMaxSize)
+		if err != nil {
+			log.Fatalf("Failed to set up S3 sources: %v", err)
+		}
+		handlerOptions = append(handlerOptions, handler.WithSource("s3", source))
+	}
+	if len(cfg.SourceFileRoots) > 0 {
+		source, err := handler.NewFileSource(cfg.SourceFileRoots, cfg.FetchMaxSize)
+		if err != nil {
+			log.Fatalf("Failed to set up file sources: %v", err)
+		}
+		handlerOptions = append(handlerOptions, handler.WithSource("file", source))
+	}
+	imageHandler := handler.NewImageHandler(handlerOptions...)
+	imageProcessor := processor.New(
+		processor.WithLogger(logger),
+		processor.WithMetrics(appMetrics),
+	)
+
+	// Asynchronous jobs are kept until they expire
+	jobStore, err := newJobStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to create job store: %v", err)
+	}
+	janitorCtx, stopJanitor := context.WithCancel(context.Background())
+	defer stopJanitor()
+	go jobs.RunJanitor(janitorCtx, jobStore, time.Minute, logger)
+
+	// Results are cached by input hash and options
+	var resultCache *cache.Cache
+	if cfg.CacheMaxSize > 0 || cfg.CacheDir != "" {
+		resultCache, err = cache.New(cache.Config{
+			MemoryMaxBytes: cfg.CacheMaxSize,
+			Dir:            cfg.CacheDir,
+			DiskMaxBytes:   cfg.CacheDiskMaxSize,
+		})
+		if err != nil {
+			log.Fatalf("Failed to create result cache: %v", err)
+		}
+		appMetrics.RegisterCache(resultCache)
+	}
+
+	// Outputs can be stored and returned as URLs with output=store
+	outputStorage, err := newStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up output storage: %v", err)
+	}
+
+	apiOptions := []api.Option{
+		api.WithLogger(logger),
+		api.WithMetrics(appMetrics),
+		api.WithMaxWorkers(cfg.MaxWorkers),
+		api.WithMaxBatchItems(cfg.MaxBatchItems),
+		api.WithJobStore(jobStore),
+		api.WithArchiveLimits(archive.Limits{
+			MaxEntries:   cfg.ArchiveMaxEntries,
+			MaxTotalSize: cfg.ArchiveMaxSize,
+		}),
+		api.WithPublicURL(cfg.PublicURL),
+		api.WithCache(resultCache),
+		api.WithHTTPCaching(cfg.CacheControl, cfg.VaryAccept),
+		api.WithBackgroundQueue(cfg.BackgroundQueueSize),
+	}
+	if outputStorage != nil {
+		apiOptions = append(apiOptions, api.WithStorage(outputStorage))
+	}
+
+	// Callbacks are only accepted if they can be signed, and are delivered
+	// to the same destinations images may be fetched from
+	if cfg.WebhookSecret != "" {
+		sender := webhook.New(cfg.WebhookSecret,
+			webhook.WithLogger(logger),
+			webhook.WithClient(fetchPolicy.NewClient(cfg.WebhookTimeout, cfg.FetchMaxRedirects)),
+			webhook.WithRetries(cfg.WebhookMaxAttempts, time.Second),
+		)
+		apiOptions = append(apiOptions, api.WithWebhooks(sender, cfg.CallbackInlineMaxSize))
+	}
+
+	// URL processing endpoints only serve signed URLs once keys are configured
+	if cfg.URLSigningKeys != "" {
+		keys, err := signing.ParseKeys(cfg.URLSigningKeys)
+		if err != nil {
+			log.Fatalf("Invalid URL_SIGNING_KEYS: %v", err)
+		}
+		apiOptions = append(apiOptions, api.WithURLSigning(signing.NewVerifier(keys)))
+	}
+
+	// API endpoints require a key once keys are configured. Usage kept on
+	// disk is saved periodically and on shutdown.
+	usageStore, err := newUsageStore(cfg)
+	if err != nil {
+		log.Fatalf("Invalid API key configuration: %v", err)
+	}
+	fileUsage, _ := usageStore.(*auth.FileUsageStore)
+	if fileUsage != nil {
+		go fileUsage.Run(janitorCtx, 10*time.Second, logger)
+	}
+	authenticator, err := newAuthenticator(cfg, logger, usageStore)
+	if err != nil {
+		log.Fatalf("Invalid API key configuration: %v", err)
+	}
+
+	// Clients making too many requests or sending too much data are slowed
+	// down
+	limiter, err := newRateLimiter(cfg)
+	if err != nil {
+		log.Fatalf("Invalid rate limit configuration: %v", err)
+	}
+
 	// Create API
-	imageAPI := api.NewImageAPI(imageHandler, imageProcessor)
-	
+	imageAPI := api.NewImageAPI(imageHandler, imageProcessor, apiOptions...)
+
 	// Set up HTTP routes
 	mux := http.NewServeMux()
-	
+	route := func(pattern, endpoint string, h http.HandlerFunc) {
+		mux.Handle(pattern, appMetrics.Instrument(endpoint, limiter.Limit(authenticator.Require(endpoint, h))))
+	}
+
 	// API routes
-	mux.HandleFunc("/health", imageAPI.Health)
-	mux.HandleFunc("/process/url", imageAPI.ProcessFromURL)
-	mux.HandleFunc("/process/upload", imageAPI.ProcessFromUpload)
-	
+	mux.Handle("/health", appMetrics.Instrument("health", http.HandlerFunc(imageAPI.Health)))
+	route("/process/url", "process_url", imageAPI.ProcessFromURL)
+	route("/img/{options}/{source}", "img", imageAPI.ProcessPath)
+	route("/process/upload", "process_upload", imageAPI.ProcessFromUpload)
+	route("/process/batch", "process_batch", imageAPI.ProcessBatch)
+	route("/process/stream", "process_stream", imageAPI.ProcessStream)
+	route("/process/zip", "process_zip", imageAPI.ProcessArchive)
+	route("/jobs", "jobs_create", imageAPI.CreateJob)
+	route("/jobs/{id}", "jobs_get", imageAPI.GetJob)
+	route("/cache/stats", "cache_stats", imageAPI.CacheStats)
+	route("/probe/url", "probe_url", imageAPI.ProbeFromURL)
+	route("/probe/upload", "probe_upload", imageAPI.ProbeFromUpload)
+
+	// Job results are linked from callbacks and streams, so the unguessable
+	// job ID is all that is needed to download one
+	mux.Handle("/jobs/{id}/result", appMetrics.Instrument("jobs_result", limiter.Limit(http.HandlerFunc(imageAPI.GetJobResult))))
+
+	// Files stored by the local backend are served directly
+	if local, ok := outputStorage.(*storage.Local); ok {
+		mux.Handle("/files/", appMetrics.Instrument("files", limiter.Limit(http.StripPrefix("/files/", local.Handler()))))
+	}
+
+	if authenticator != nil {
+		mux.Handle("/usage", appMetrics.Instrument("usage", limiter.Limit(http.HandlerFunc(authenticator.Usage))))
+	}
+
+	if cfg.MetricsEnabled {
+		mux.Handle("/metrics", appMetrics.Handler())
+	}
+
 	// Set up static file server for test pages
 	testDir := getTestDataDir()
 	log.Printf("Serving test files from %s", testDir)
 	testFileServer := http.FileServer(http.Dir(testDir))
 	mux.Handle("/test/", http.StripPrefix("/test/", testFileServer))
-	
+
 	// Set up static file server for the React frontend
 	staticDir := getStaticFilesDir()
 	log.Printf("Serving frontend from %s", staticDir)
 	staticFileServer := http.FileServer(http.Dir(staticDir))
-	
+
 	// Serve React frontend at root
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// API and test routes take precedence
@@ -59,16 +248,26 @@ func main() {
 			staticFileServer.ServeHTTP(w, r)
 		}
 	})
-	
+
+	// Browsers on other origins may call the API once their origins are
+	// configured
+	cors := middleware.CORS(middleware.CORSConfig{
+		AllowedOrigins: cfg.CORSAllowedOrigins,
+		AllowedMethods: cfg.CORSAllowedMethods,
+		AllowedHeaders: cfg.CORSAllowedHeaders,
+		ExposedHeaders: cfg.CORSExposedHeaders,
+		MaxAge:         cfg.CORSMaxAge,
+	})
+
 	// Create server
 	server := &http.Server{
 		Addr:         ":" + cfg.Port,
-		Handler:      mux,
+		Handler:      middleware.Chain(mux, middleware.RequestID, middleware.Tracing, middleware.AccessLog(logger), cors),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 90 * time.Second, // Longer timeout for image processing
 		IdleTimeout:  120 * time.Second,
 	}
-	
+
 	// Start server in a goroutine
 	go func() {
 		log.Printf("Server starting on port %s...\n", cfg.Port)
@@ -78,23 +277,197 @@ func main() {
 			log.Fatalf("Server failed to start: %v", err)
 		}
 	}()
-	
+
 	// Set up graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-	
+
 	log.Println("Shutting down server...")
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	
+
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
-	
+
+	// Callbacks and jobs already accepted are still run to completion
+	if err := imageAPI.Shutdown(ctx); err != nil {
+		log.Printf("Gave up waiting for callbacks and jobs: %v", err)
+	}
+
+	if fileUsage != nil {
+		if err := fileUsage.Flush(); err != nil {
+			log.Printf("Failed to save API key usage: %v", err)
+		}
+	}
+
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+
 	log.Println("Server gracefully stopped")
 }
 
+// newJobStore creates the configured job store
+func newJobStore(cfg *config.Config) (jobs.Store, error) {
+	if cfg.JobStore == "file" {
+		return jobs.NewFileStore(cfg.JobStoreDir, cfg.JobTTL)
+	}
+	return jobs.NewMemoryStore(cfg.JobTTL), nil
+}
+
+// newUsageStore creates the configured store of API key usage
+func newUsageStore(cfg *config.Config) (auth.UsageStore, error) {
+	switch cfg.UsageStore {
+	case "file":
+		return auth.NewFileUsageStore(cfg.UsageStoreDir)
+	case "", "memory":
+		return auth.NewMemoryUsageStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown usage store %q", cfg.UsageStore)
+	}
+}
+
+// newAuthenticator creates the authenticator of API requests from the keys
+// in API_KEYS and API_KEYS_FILE, or returns nil if there are none
+func newAuthenticator(cfg *config.Config, logger *slog.Logger, usage auth.UsageStore) (*auth.Authenticator, error) {
+	keys, err := auth.ParseKeys(cfg.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.APIKeysFile != "" {
+		data, err := os.ReadFile(cfg.APIKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read API key file: %w", err)
+		}
+		fileKeys, err := auth.ParseKeys(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.APIKeysFile, err)
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	return auth.New(keys, auth.WithUsageStore(usage), auth.WithLogger(logger))
+}
+
+// newRateLimiter creates the per-client rate limiter from the configured
+// rates and trusted proxies. The limiter is nil when no rate is set, as
+// ratelimit.New returns nil then.
+func newRateLimiter(cfg *config.Config) (*ratelimit.Limiter, error) {
+	proxies, err := parseNetworks(cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return ratelimit.New(ratelimit.Config{
+		RequestsPerSecond: cfg.RateLimitRPS,
+		RequestBurst:      cfg.RateLimitBurst,
+		BytesPerSecond:    cfg.RateLimitMBPerSec * (1 << 20),
+		ByteBurst:         int64(cfg.RateLimitBurstMB) << 20,
+		Clients: ratelimit.ClientIdentifier{
+			Header:         cfg.RateLimitClientHeader,
+			ProxyHeaders:   cfg.TrustedProxyHeaders,
+			TrustedProxies: proxies,
+		},
+	}), nil
+}
+
+// parseNetworks parses networks given as CIDRs or single addresses
+func parseNetworks(networks []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(networks))
+	for _, network := range networks {
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(network)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid network %q: %w", network, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes, nil
+}
+
+// newFetchPolicy creates the policy restricting where remote images are
+// fetched from and callbacks are sent to
+func newFetchPolicy(cfg *config.Config) (fetch.Policy, error) {
+	allowNets, err := parseNetworks(cfg.FetchAllowNets)
+	if err != nil {
+		return fetch.Policy{}, err
+	}
+	return fetch.Policy{
+		AllowHosts: cfg.FetchAllowHosts,
+		DenyHosts:  cfg.FetchDenyHosts,
+		AllowNets:  allowNets,
+	}, nil
+}
+
+// newFetcher creates the fetcher used for remote images from the
+// configuration
+func newFetcher(cfg *config.Config, policy fetch.Policy) (*fetch.Fetcher, error) {
+	rules, err := config.ParseOriginRules(cfg.FetchOrigins)
+	if err != nil {
+		return nil, err
+	}
+	origins := make([]fetch.Origin, 0, len(rules))
+	for _, rule := range rules {
+		origins = append(origins, fetch.Origin{Host: rule.Host, Header: rule.Header()})
+	}
+	return fetch.New(fetch.Config{
+		MaxBytes:       cfg.FetchMaxSize,
+		ConnectTimeout: cfg.FetchConnectTimeout,
+		ReadTimeout:    cfg.FetchReadTimeout,
+		Timeout:        cfg.FetchTimeout,
+		MaxRedirects:   cfg.FetchMaxRedirects,
+		AcceptedTypes:  cfg.FetchAcceptedTypes,
+		UserAgent:      cfg.FetchUserAgent,
+		Policy:         policy,
+		Origins:        origins,
+
+		Retries:          cfg.FetchRetries,
+		RetryBaseDelay:   cfg.FetchRetryBaseDelay,
+		RetryMaxDelay:    cfg.FetchRetryMaxDelay,
+		BreakerThreshold: cfg.FetchBreakerThreshold,
+		BreakerCooldown:  cfg.FetchBreakerCooldown,
+	}), nil
+}
+
+// newStorage creates the configured output storage backend, or nil if
+// output storage is disabled
+func newStorage(cfg *config.Config) (storage.Backend, error) {
+	switch cfg.StorageBackend {
+	case "local":
+		return storage.NewLocal(cfg.StorageDir, strings.TrimSuffix(cfg.PublicURL, "/")+"/files")
+	case "s3":
+		return storage.NewS3(s3Config(cfg))
+	case "", "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+}
+
+// s3Config returns the configured S3-compatible service settings
+func s3Config(cfg *config.Config) storage.S3Config {
+	return storage.S3Config{
+		Endpoint: cfg.S3Endpoint,
+		Region:   cfg.S3Region,
+		Bucket:   cfg.S3Bucket,
+		Credentials: storage.Credentials{
+			AccessKeyID:     cfg.S3AccessKeyID,
+			SecretAccessKey: cfg.S3SecretAccessKey,
+			SessionToken:    cfg.S3SessionToken,
+		},
+		PathStyle: cfg.S3PathStyle,
+		PublicURL: cfg.S3PublicURL,
+		URLExpiry: cfg.S3URLExpiry,
+	}
+}
+
 // getTestDataDir returns the path to the test data directory
 func getTestDataDir() string {
 	// Get the executable directory
@@ -103,23 +476,23 @@ func getTestDataDir() string {
 		log.Printf("Warning: Could not determine executable path: %v, using current directory", err)
 		return filepath.Join(".", "test", "testdata")
 	}
-	
+
 	exeDir := filepath.Dir(exePath)
-	
+
 	// Look for test data in different possible locations
 	possiblePaths := []string{
-		filepath.Join(exeDir, "test", "testdata"),                    // Same directory as executable
-		filepath.Join(exeDir, "..", "test", "testdata"),              // One level up
-		filepath.Join(exeDir, "..", "..", "test", "testdata"),        // Two levels up (for dev environment)
-		filepath.Join(exeDir, "..", "..", "..", "test", "testdata"),  // Three levels up
+		filepath.Join(exeDir, "test", "testdata"),                   // Same directory as executable
+		filepath.Join(exeDir, "..", "test", "testdata"),             // One level up
+		filepath.Join(exeDir, "..", "..", "test", "testdata"),       // Two levels up (for dev environment)
+		filepath.Join(exeDir, "..", "..", "..", "test", "testdata"), // Three levels up
 	}
-	
+
 	for _, path := range possiblePaths {
 		if _, err := os.Stat(path); err == nil {
 			return path
 		}
 	}
-	
+
 	// Fallback to relative path from current working directory
 	log.Println("Warning: Could not find test data directory, falling back to current directory")
 	return filepath.Join(".", "test", "testdata")
@@ -133,9 +506,9 @@ func getStaticFilesDir() string {
 		log.Printf("Warning: Could not determine executable path: %v, using current directory", err)
 		return filepath.Join(".", "static")
 	}
-	
+
 	exeDir := filepath.Dir(exePath)
-	
+
 	// Look for static files in different possible locations
 	possiblePaths := []string{
 		filepath.Join(exeDir, "static"),                   // Same directory as executable
@@ -143,13 +516,13 @@ func getStaticFilesDir() string {
 		filepath.Join(exeDir, "..", "..", "static"),       // Two levels up (for dev environment)
 		filepath.Join(exeDir, "..", "..", "..", "static"), // Three levels up
 	}
-	
+
 	for _, path := range possiblePaths {
 		if _, err := os.Stat(path); err == nil {
 			return path
 		}
 	}
-	
+
 	// Fallback to relative path from current working directory
 	log.Println("Warning: Could not find static directory, falling back to current directory")
 	return filepath.Join(".", "static")
@@ -159,4 +532,4 @@ func getStaticFilesDir() string {
 func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
-} 
\ No newline at end of file
+}
@@ -30,6 +30,22 @@ export type ProcessedImage = {
     new_format: string;
     new_size: number;
     size_reduction_percent: number;
+    timings?: {
+      fetch_ms: number;
+      decode_ms: number;
+      resize_ms: number;
+      encode_ms: number;
+      total_ms: number;
+    };
+    encoder?: {
+      quality: number;
+      lossless: boolean;
+      filter: string;
+      has_alpha: boolean;
+      color_model: string;
+      bit_depth: number;
+    };
+    cache?: "HIT" | "MISS";
   };
   downloadUrl: string;
   formData?: FormData;
@@ -67,9 +83,12 @@ export default function Home() {
     if (images.length === 0) return;
 
     setIsProcessing(true);
+    setProcessedImages([]);
     try {
-      const results = await processImages(images, settings);
-      setProcessedImages(results);
+      // Results are shown as each image finishes
+      await processImages(images, settings, (result) =>
+        setProcessedImages((prev) => [...prev, result])
+      );
     } catch (error) {
       console.error("Error processing images:", error);
     } finally {
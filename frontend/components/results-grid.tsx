@@ -10,7 +10,7 @@ import {
   CollapsibleContent,
   CollapsibleTrigger,
 } from "@/components/ui/collapsible";
-import { formatFileSize } from "@/lib/utils";
+import { formatFileSize, formatMs } from "@/lib/utils";
 
 interface ResultsGridProps {
   processedImages: ProcessedImage[];
@@ -250,6 +250,41 @@ export function ResultsGrid({ processedImages }: ResultsGridProps) {
 
                     <div className="text-muted-foreground">Size Reduction:</div>
                     <div>{image.metadata.size_reduction_percent}%</div>
+
+                    {image.metadata.encoder && (
+                      <>
+                        <div className="text-muted-foreground">Encoder:</div>
+                        <div>
+                          q{image.metadata.encoder.quality}
+                          {image.metadata.encoder.lossless ? " lossless" : ""},{" "}
+                          {image.metadata.encoder.color_model}{" "}
+                          {image.metadata.encoder.bit_depth}-bit
+                          {image.metadata.encoder.has_alpha ? ", alpha" : ""}
+                        </div>
+                      </>
+                    )}
+
+                    {image.metadata.timings && (
+                      <>
+                        {image.metadata.timings.fetch_ms > 0 && (
+                          <>
+                            <div className="text-muted-foreground">Fetch:</div>
+                            <div>{formatMs(image.metadata.timings.fetch_ms)}</div>
+                          </>
+                        )}
+                        <div className="text-muted-foreground">Decode:</div>
+                        <div>{formatMs(image.metadata.timings.decode_ms)}</div>
+
+                        <div className="text-muted-foreground">Resize:</div>
+                        <div>{formatMs(image.metadata.timings.resize_ms)}</div>
+
+                        <div className="text-muted-foreground">Encode:</div>
+                        <div>{formatMs(image.metadata.timings.encode_ms)}</div>
+
+                        <div className="text-muted-foreground">Total Time:</div>
+                        <div>{formatMs(image.metadata.timings.total_ms)}</div>
+                      </>
+                    )}
                   </div>
                 </CollapsibleContent>
               </Collapsible>
@@ -1,96 +1,101 @@
 import type { ImageFile, ImageSettings, ProcessedImage } from "@/app/page";
 
-// This is a mock function that simulates image processing
-// In a real application, this would call a backend API
+type StreamItemEvent = {
+  index: number;
+  name: string;
+  output?: string;
+  metadata?: ProcessedImage["metadata"];
+  error?: string;
+  download_url?: string;
+  data?: string;
+};
+
+// Processes all images in one /process/stream request. onProcessed is called
+// as each image finishes so results can be shown before the whole batch is
+// done; the returned promise resolves with every successful result.
 export async function processImages(
   images: ImageFile[],
-  settings: ImageSettings
+  settings: ImageSettings,
+  onProcessed?: (image: ProcessedImage) => void
 ): Promise<ProcessedImage[]> {
-  return Promise.all(
-    images.map(async (image) => {
-      let response;
-      let formData;
+  // The server lists uploads first, then URLs, in the order they were sent
+  const uploads = images.filter((image) => image.file);
+  const urls = images.filter((image) => !image.file && image.url);
+  const ordered = [...uploads, ...urls];
 
-      // Process based on whether we have a file or URL
-      if (image.file) {
-        // Handle file upload
-        formData = new FormData();
-        formData.append("image", image.file);
-        formData.append("max_width", settings.maxWidth.toString());
-        formData.append("max_height", settings.maxHeight.toString());
-        formData.append("quality", settings.quality.toString());
-        formData.append("preserve_ratio", "true");
-        formData.append("metadata", "true");
+  const formData = new FormData();
+  for (const image of uploads) {
+    formData.append("images", image.file as File);
+  }
+  formData.append(
+    "request",
+    JSON.stringify({
+      options: {
+        max_width: settings.maxWidth,
+        max_height: settings.maxHeight,
+        quality: settings.quality,
+        preserve_ratio: true,
+      },
+      items: urls.map((image) => ({ url: image.url })),
+    })
+  );
 
-        // Call the backend API
-        response = await fetch("/process/upload?metadata=true", {
-          method: "POST",
-          body: formData,
-        });
-      } else if (image.url) {
-        // Handle URL-based image
-        const params = new URLSearchParams({
-          url: image.url,
-          max_width: settings.maxWidth.toString(),
-          max_height: settings.maxHeight.toString(),
-          quality: settings.quality.toString(),
-          preserve_ratio: "true",
-          metadata: "true",
-        });
+  const response = await fetch("/process/stream", {
+    method: "POST",
+    body: formData,
+  });
 
-        // Call the backend API
-        response = await fetch(`/process/url?${params.toString()}`, {
-          method: "GET",
-        });
-      } else {
-        throw new Error("Invalid image: neither file nor URL provided");
-      }
+  if (!response.ok || !response.body) {
+    throw new Error(`API request failed with status ${response.status}`);
+  }
 
-      if (!response.ok) {
-        throw new Error(`API request failed with status ${response.status}`);
-      }
+  const results: ProcessedImage[] = [];
+  const handleEvent = (event: string, data: string) => {
+    if (event === "failed") {
+      const item: StreamItemEvent = JSON.parse(data);
+      console.error(`Failed to process ${item.name}: ${item.error}`);
+      return;
+    }
+    if (event !== "completed") return;
 
-      // Parse metadata from the response
-      const metadata = await response.json();
+    const item: StreamItemEvent = JSON.parse(data);
+    const image = ordered[item.index];
+    if (!image || !item.metadata) return;
 
-      // Create and store the download form data for uploads
-      let downloadFormData;
+    const result: ProcessedImage = {
+      id: image.id,
+      preview: image.preview,
+      name: image.name,
+      metadata: item.metadata,
+      downloadUrl:
+        item.download_url ?? `data:image/webp;base64,${item.data ?? ""}`,
+    };
+    results.push(result);
+    onProcessed?.(result);
+  };
 
-      // Generate URL for the processed image (without metadata parameter but with download and format parameters)
-      let downloadUrl;
-      if (image.file) {
-        downloadFormData = new FormData();
-        downloadFormData.append("image", image.file);
-        downloadFormData.append("max_width", settings.maxWidth.toString());
-        downloadFormData.append("max_height", settings.maxHeight.toString());
-        downloadFormData.append("quality", settings.quality.toString());
-        downloadFormData.append("preserve_ratio", "true");
-        downloadFormData.append("format", "webp");
-        downloadFormData.append("download", "true");
+  // Parse the event stream: events are separated by blank lines
+  const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  for (;;) {
+    const { value, done } = await reader.read();
+    if (done) break;
+    buffer += value;
 
-        downloadUrl = "/process/upload?format=webp&download=true";
-      } else if (image.url) {
-        const params = new URLSearchParams({
-          url: image.url,
-          max_width: settings.maxWidth.toString(),
-          max_height: settings.maxHeight.toString(),
-          quality: settings.quality.toString(),
-          preserve_ratio: "true",
-          format: "webp",
-          download: "true",
-        });
+    let end;
+    while ((end = buffer.indexOf("\n\n")) !== -1) {
+      const block = buffer.slice(0, end);
+      buffer = buffer.slice(end + 2);
 
-        downloadUrl = `/process/url?${params.toString()}`;
+      let event = "message";
+      let data = "";
+      for (const line of block.split("\n")) {
+        if (line.startsWith("event: ")) event = line.slice(7);
+        else if (line.startsWith("data: ")) data += line.slice(6);
       }
+      handleEvent(event, data);
+    }
+  }
 
-      return {
-        id: image.id,
-        preview: image.preview,
-        name: image.name,
-        metadata: metadata,
-        downloadUrl: downloadUrl || "",
-        formData: downloadFormData,
-      };
-    })
-  );
+  return results;
 }
@@ -14,3 +14,9 @@ export function formatFileSize(bytes: number): string {
 
   return Number.parseFloat((bytes / Math.pow(k, i)).toFixed(2)) + " " + sizes[i]
 }
+
+export function formatMs(ms: number): string {
+  if (ms >= 1000) return (ms / 1000).toFixed(2) + " s"
+
+  return ms.toFixed(1) + " ms"
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/archive"
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+)
+
+// sourceArchive marks batch jobs that came from an uploaded archive
+const sourceArchive = "archive"
+
+// endpointArchive labels metrics for images processed through /process/zip
+const endpointArchive = "zip"
+
+// WithArchiveLimits sets the extraction limits applied to uploaded archives
+func WithArchiveLimits(limits archive.Limits) Option {
+	return func(api *ImageAPI) {
+		api.archiveLimits = limits
+	}
+}
+
+// ProcessArchive converts every supported image in an uploaded ZIP archive to
+// WebP and returns a ZIP with the same folder structure. Other files, and
+// images that fail to convert, are passed through untouched.
+func (api *ImageAPI) ProcessArchive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	options := getProcessOptionsFromRequest(r)
+	logOptions(r, options)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBatchUploadSize)
+	archiveData, err := api.imageHandler.GetArchiveFromUpload(r, "archive")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to upload archive: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := archive.Read(archiveData, api.archiveLimits)
+	if err != nil {
+		api.logger.WarnContext(ctx, "rejected archive", slog.Any("error", err))
+		http.Error(w, fmt.Sprintf("Failed to read archive: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Queue supported images for conversion; everything else passes through
+	var (
+		jobs    []batchJob
+		entryOf []int
+	)
+	for i, entry := range entries {
+		if api.imageHandler.ValidateFileType(entry.Name) != nil {
+			continue
+		}
+		jobs = append(jobs, batchJob{
+			name:    entry.Name,
+			source:  sourceArchive,
+			data:    entry.Data,
+			options: options,
+		})
+		entryOf = append(entryOf, i)
+	}
+
+	outputs := make([]batchOutput, len(entries))
+	for i, out := range api.runBatch(ctx, endpointArchive, jobs, batchEvents{}) {
+		if out.result.Error != "" {
+			api.logger.WarnContext(ctx, "passing through image that failed to convert",
+				slog.String("entry", out.result.Name), slog.String("error", out.result.Error))
+		}
+		outputs[entryOf[i]] = out
+	}
+
+	converted, failed := 0, 0
+	for _, out := range outputs {
+		if out.data != nil {
+			converted++
+		} else if out.result.Error != "" {
+			failed++
+		}
+	}
+	logging.AddAccessAttrs(ctx,
+		slog.Int("input_bytes", len(archiveData)),
+		slog.Int("archive_entries", len(entries)),
+		slog.Int("archive_converted", converted),
+		slog.Int("archive_failed", failed),
+	)
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=images-webp.zip")
+	w.Header().Set("X-Archive-Converted", strconv.Itoa(converted))
+	w.Header().Set("X-Archive-Failed", strconv.Itoa(failed))
+
+	if err := writeConvertedArchive(w, entries, outputs); err != nil {
+		api.logger.ErrorContext(ctx, "failed to write archive", slog.Any("error", err))
+	}
+}
+
+// writeConvertedArchive writes converted images under their .webp name and
+// all other entries unchanged
+func writeConvertedArchive(w io.Writer, entries []archive.Entry, outputs []batchOutput) error {
+	// Reserve the names of files passed through so converted names avoid them
+	used := map[string]int{}
+	for i, entry := range entries {
+		if outputs[i].data == nil {
+			used[entry.Name]++
+		}
+	}
+
+	zw := zip.NewWriter(w)
+	for i, entry := range entries {
+		header := &zip.FileHeader{Name: entry.Name, Method: zip.Deflate, Modified: entry.Modified}
+		data := entry.Data
+
+		if outputs[i].data != nil {
+			dir, file := path.Split(entry.Name)
+			header.Name = uniqueName(used, dir+webpFilename(file))
+			// WebP data is already compressed
+			header.Method = zip.Store
+			data = outputs[i].data
+		} else if isCompressed(entry.Name) {
+			header.Method = zip.Store
+		}
+
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// isCompressed reports whether a file is likely already compressed, so
+// deflating it again would waste CPU
+func isCompressed(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp", ".zip", ".gz", ".mp4", ".mp3":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/handler"
+	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+func TestProcessArchive(t *testing.T) {
+	var upload bytes.Buffer
+	zw := zip.NewWriter(&upload)
+	for name, content := range map[string]string{
+		"site/img/hero.jpg":  "hero",
+		"site/img/hero.png":  "hero png",
+		"site/img/bad.png":   "corrupt",
+		"site/index.html":    "<html></html>",
+		"site/img/hero.webp": "existing webp",
+	} {
+		fw, _ := zw.Create(name)
+		fw.Write([]byte(content))
+	}
+	zw.Close()
+
+	mockHandler := &MockImageHandler{
+		GetArchiveFunc: func(r *http.Request, fieldName string) ([]byte, error) {
+			if fieldName != "archive" {
+				return nil, handler.ErrNoFile
+			}
+			return upload.Bytes(), nil
+		},
+		ValidateTypeFunc: func(fileName string) error {
+			if strings.HasSuffix(fileName, ".jpg") || strings.HasSuffix(fileName, ".png") {
+				return nil
+			}
+			return handler.ErrInvalidFileType
+		},
+	}
+	mockProcessor := &MockImageProcessor{
+		ProcessBytesFunc: func(imageData []byte, options *processor.ProcessOptions) ([]byte, *models.ImageMetadata, error) {
+			if string(imageData) == "corrupt" {
+				return nil, nil, processor.ErrInvalidImage
+			}
+			return []byte("webp:" + string(imageData)), &models.ImageMetadata{}, nil
+		},
+	}
+	api := NewImageAPI(mockHandler, mockProcessor)
+
+	req := httptest.NewRequest("POST", "/process/zip", nil)
+	w := httptest.NewRecorder()
+
+	api.ProcessArchive(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Archive-Converted") != "2" || w.Header().Get("X-Archive-Failed") != "1" {
+		t.Errorf("Expected 2 converted and 1 failed, got %s/%s",
+			w.Header().Get("X-Archive-Converted"), w.Header().Get("X-Archive-Failed"))
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read response ZIP: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	expected := map[string]string{
+		"site/index.html":    "<html></html>",
+		"site/img/bad.png":   "corrupt",
+		"site/img/hero.webp": "existing webp",
+	}
+	for name, content := range expected {
+		if files[name] != content {
+			t.Errorf("Expected %s to pass through unchanged, got %q", name, files[name])
+		}
+	}
+
+	// Both heroes convert to hero.webp, which is taken by the passed-through file
+	converted := []string{files["site/img/hero-1.webp"], files["site/img/hero-2.webp"]}
+	if !(converted[0] == "webp:hero" || converted[1] == "webp:hero") ||
+		!(converted[0] == "webp:hero png" || converted[1] == "webp:hero png") {
+		t.Errorf("Expected converted images under unique names, got %v", files)
+	}
+	if len(files) != 5 {
+		t.Errorf("Expected 5 files in output, got %d: %v", len(files), files)
+	}
+}
+
+func TestProcessArchiveRejectsTraversal(t *testing.T) {
+	var upload bytes.Buffer
+	zw := zip.NewWriter(&upload)
+	fw, _ := zw.Create("../escape.jpg")
+	fw.Write([]byte("x"))
+	zw.Close()
+
+	mockHandler := &MockImageHandler{
+		GetArchiveFunc: func(r *http.Request, fieldName string) ([]byte, error) {
+			return upload.Bytes(), nil
+		},
+	}
+	api := NewImageAPI(mockHandler, &MockImageProcessor{})
+
+	w := httptest.NewRecorder()
+	api.ProcessArchive(w, httptest.NewRequest("POST", "/process/zip", nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request, got %v", w.Code)
+	}
+}
+
+func TestProcessArchiveCapsUploadSize(t *testing.T) {
+	var readErr error
+	mockHandler := &MockImageHandler{
+		GetArchiveFunc: func(r *http.Request, fieldName string) ([]byte, error) {
+			_, readErr = io.Copy(io.Discard, r.Body)
+			return nil, readErr
+		},
+	}
+	api := NewImageAPI(mockHandler, &MockImageProcessor{})
+
+	body := io.LimitReader(zeroReader{}, maxBatchUploadSize+1)
+	w := httptest.NewRecorder()
+	api.ProcessArchive(w, httptest.NewRequest("POST", "/process/zip", body))
+
+	var maxErr *http.MaxBytesError
+	if !errors.As(readErr, &maxErr) {
+		t.Errorf("Expected the upload to be cut off at %d bytes, got %v", maxBatchUploadSize, readErr)
+	}
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request, got %v", w.Code)
+	}
+}
+
+// zeroReader reads endless zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"context"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/auth"
+	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+)
+
+// keyDefaults returns the options used when a request sets none: the
+// service defaults, overridden by those of the request's API key
+func keyDefaults(ctx context.Context) processor.ProcessOptions {
+	options := defaultProcessOptions()
+	if key := auth.KeyFromContext(ctx); key != nil {
+		defaults := OptionOverrides(key.Defaults)
+		options = defaults.apply(options)
+	}
+	return options
+}
+
+// limitOptions caps the output dimensions of options to those allowed for
+// the request's API key
+func limitOptions(ctx context.Context, options processor.ProcessOptions) processor.ProcessOptions {
+	key := auth.KeyFromContext(ctx)
+	if key == nil {
+		return options
+	}
+	if key.MaxWidth > 0 {
+		options.MaxWidth = min(options.MaxWidth, key.MaxWidth)
+	}
+	if key.MaxHeight > 0 {
+		options.MaxHeight = min(options.MaxHeight, key.MaxHeight)
+	}
+	return options
+}
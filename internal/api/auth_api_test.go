@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/auth"
+	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+)
+
+func TestProcessOptionsForAPIKey(t *testing.T) {
+	quality := 60
+	fit := processor.FitCover
+	authenticator, err := auth.New([]auth.Key{
+		{ID: "plain", Secret: "plain"},
+		{ID: "limited", Secret: "limited", MaxWidth: 800, MaxHeight: 600, Defaults: auth.Defaults{Quality: &quality, Fit: &fit}},
+	})
+	if err != nil {
+		t.Fatalf("auth.New() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		query    string
+		expected processor.ProcessOptions
+	}{
+		{
+			name:     "service defaults",
+			key:      "plain",
+			expected: defaultProcessOptions(),
+		},
+		{
+			name:     "key defaults and limits",
+			key:      "limited",
+			expected: processor.ProcessOptions{MaxWidth: 800, MaxHeight: 600, Quality: 60, PreserveRatio: true, Fit: processor.FitCover},
+		},
+		{
+			name:     "request overrides defaults",
+			key:      "limited",
+			query:    "?quality=90&fit=fill&max_width=400",
+			expected: processor.ProcessOptions{MaxWidth: 400, MaxHeight: 600, Quality: 90, PreserveRatio: true, Fit: processor.FitFill},
+		},
+		{
+			name:     "request may not exceed limits",
+			key:      "limited",
+			query:    "?max_width=4000&max_height=3000",
+			expected: processor.ProcessOptions{MaxWidth: 800, MaxHeight: 600, Quality: 60, PreserveRatio: true, Fit: processor.FitCover},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var options processor.ProcessOptions
+			h := authenticator.Require("process_url", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				options = getProcessOptionsFromRequest(r)
+			}))
+			req := httptest.NewRequest("GET", "/process/url"+tt.query, nil)
+			req.Header.Set(auth.HeaderAPIKey, tt.key)
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if options != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, options)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"sync"
+)
+
+// backgroundWorkers is how many callbacks and jobs run at once. Their
+// processing is still bounded by the worker limit, so most of the time a
+// background worker is waiting on a delivery or a queued image.
+const backgroundWorkers = 16
+
+// defaultBackgroundQueueSize is how many callbacks and jobs may wait for a
+// background worker when no size is configured
+const defaultBackgroundQueueSize = 100
+
+// WithBackgroundQueue sets how many accepted callbacks and jobs may wait for
+// a background worker. Requests beyond that are refused with 503 Service
+// Unavailable.
+func WithBackgroundQueue(size int) Option {
+	return func(api *ImageAPI) {
+		api.backgroundQueueSize = size
+	}
+}
+
+// backgroundQueue runs work that outlives its request on a fixed number of
+// workers. Work that finds the queue full is refused, so bursts of requests
+// cannot pile up goroutines and images in memory.
+type backgroundQueue struct {
+	mu     sync.RWMutex
+	tasks  chan func()
+	closed bool
+	done   chan struct{} // Closed once every worker has exited
+}
+
+// newBackgroundQueue starts workers that run tasks, of which up to size may
+// wait for a worker
+func newBackgroundQueue(workers, size int) *backgroundQueue {
+	q := &backgroundQueue{
+		tasks: make(chan func(), size),
+		done:  make(chan struct{}),
+	}
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range q.tasks {
+				task()
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(q.done)
+	}()
+	return q
+}
+
+// submit queues task, reporting false if the queue is full or draining
+func (q *backgroundQueue) submit(task func()) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	select {
+	case q.tasks <- task:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain stops accepting tasks and waits until the queued ones have run or
+// ctx is done
+func (q *backgroundQueue) drain(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.tasks)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// background returns the queue of work run after responses are sent,
+// starting its workers on first use
+func (api *ImageAPI) background() *backgroundQueue {
+	api.backgroundOnce.Do(func() {
+		size := api.backgroundQueueSize
+		if size <= 0 {
+			size = defaultBackgroundQueueSize
+		}
+		api.backgroundTasks = newBackgroundQueue(backgroundWorkers, size)
+	})
+	return api.backgroundTasks
+}
+
+// Shutdown stops accepting callbacks and jobs and waits until those already
+// accepted have finished, or ctx is done. It is meant to be called once the
+// HTTP server has shut down.
+func (api *ImageAPI) Shutdown(ctx context.Context) error {
+	return api.background().drain(ctx)
+}
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackgroundQueue(t *testing.T) {
+	q := newBackgroundQueue(1, 1)
+
+	var ran atomic.Int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if !q.submit(func() { close(started); <-release; ran.Add(1) }) {
+		t.Fatal("Expected the first task to be accepted")
+	}
+	<-started
+	if !q.submit(func() { ran.Add(1) }) {
+		t.Fatal("Expected the second task to be queued")
+	}
+	if q.submit(func() { ran.Add(1) }) {
+		t.Error("Expected a task to be refused while the queue is full")
+	}
+
+	drained := make(chan error, 1)
+	go func() { drained <- q.drain(context.Background()) }()
+	select {
+	case err := <-drained:
+		t.Fatalf("Expected drain to wait for running tasks, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-drained; err != nil {
+		t.Fatalf("Expected drain to succeed, got %v", err)
+	}
+	if n := ran.Load(); n != 2 {
+		t.Errorf("Expected both accepted tasks to run, got %d", n)
+	}
+	if q.submit(func() {}) {
+		t.Error("Expected tasks to be refused after draining")
+	}
+}
+
+func TestBackgroundQueueDrainTimeout(t *testing.T) {
+	q := newBackgroundQueue(1, 0)
+	release := make(chan struct{})
+	defer close(release)
+	for !q.submit(func() { <-release }) {
+		time.Sleep(time.Millisecond) // Wait for the worker to be ready
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
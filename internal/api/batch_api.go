@@ -0,0 +1,477 @@
+package api
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/handler"
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+	"github.com/Mark-Life/smart-webp-resize/internal/ratelimit"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// Batch item sources
+const (
+	sourceUpload = "upload"
+	sourceURL    = "url"
+)
+
+// endpointBatch labels metrics for images processed through /process/batch
+const endpointBatch = "batch"
+
+// defaultMaxBatchItems caps the number of images in one batch
+const defaultMaxBatchItems = 50
+
+// maxBatchJSONSize caps the size of a JSON batch request body
+const maxBatchJSONSize = 1 << 20
+
+// maxBatchUploadSize caps the size of a multipart batch or archive request
+// body, since every uploaded image is held in memory while the batch runs
+const maxBatchUploadSize = 256 << 20
+
+// batchFormMemory is how much of a multipart batch is parsed in memory
+// before files are spooled to disk
+const batchFormMemory = 32 << 20
+
+// batchConcurrency caps how many items of one batch are fetched or
+// processed at once; processing is further bounded by the worker limit
+const batchConcurrency = 8
+
+// Batch errors
+var (
+	ErrEmptyBatch    = errors.New("batch contains no images")
+	ErrBatchTooLarge = errors.New("batch contains too many images")
+
+	// ErrBatchURLUnsigned is returned for URL items while URL signing is
+	// enabled, unless the batch document is signed along with the URL
+	ErrBatchURLUnsigned = errors.New("URL items require a signed batch")
+)
+
+// BatchRequest is the JSON document describing a batch. It is either the
+// whole request body or, for multipart uploads, the "request" form field.
+type BatchRequest struct {
+	// Options apply to every item unless overridden per item
+	Options *OptionOverrides `json:"options,omitempty"`
+	Items   []BatchItem      `json:"items"`
+}
+
+// BatchItem is a single image in a batch request. URL items are fetched;
+// File refers to an uploaded file by name to give it per-item options.
+type BatchItem struct {
+	URL     string           `json:"url,omitempty"`
+	File    string           `json:"file,omitempty"`
+	Options *OptionOverrides `json:"options,omitempty"`
+}
+
+// OptionOverrides are processing options supplied in a JSON document. Unset
+// or invalid fields leave the underlying option unchanged.
+type OptionOverrides struct {
+	MaxWidth      *int    `json:"max_width,omitempty"`
+	MaxHeight     *int    `json:"max_height,omitempty"`
+	Quality       *int    `json:"quality,omitempty"`
+	PreserveRatio *bool   `json:"preserve_ratio,omitempty"`
+	Fit           *string `json:"fit,omitempty"`
+}
+
+// apply returns options with the overrides applied, using the same
+// validation rules as query parameters
+func (o *OptionOverrides) apply(options processor.ProcessOptions) processor.ProcessOptions {
+	if o == nil {
+		return options
+	}
+	if o.MaxWidth != nil && *o.MaxWidth > 0 {
+		options.MaxWidth = *o.MaxWidth
+	}
+	if o.MaxHeight != nil && *o.MaxHeight > 0 {
+		options.MaxHeight = *o.MaxHeight
+	}
+	if o.Quality != nil && *o.Quality > 0 && *o.Quality <= 100 {
+		options.Quality = *o.Quality
+	}
+	if o.PreserveRatio != nil {
+		options.PreserveRatio = *o.PreserveRatio
+	}
+	if o.Fit != nil && *o.Fit != "" && processor.ValidFit(*o.Fit) {
+		options.Fit = *o.Fit
+	}
+	return options
+}
+
+// batchJob is one image queued for batch processing
+type batchJob struct {
+	name    string
+	source  string
+	url     string
+	data    []byte
+	err     error
+	options processor.ProcessOptions
+}
+
+// batchOutput is the result of a batch job, including the encoded image
+type batchOutput struct {
+	result models.BatchItemResult
+	data   []byte
+}
+
+// WithMaxBatchItems caps how many images a single batch may contain
+func WithMaxBatchItems(n int) Option {
+	return func(api *ImageAPI) {
+		api.maxBatchItems = n
+	}
+}
+
+// ProcessBatch processes several images in one request. Images are uploaded
+// as multipart files under the "images" field and/or listed by URL in a JSON
+// BatchRequest. With output=zip the response is a ZIP of WebP files plus a
+// manifest.json; otherwise it is a JSON BatchResult. Failed items are
+// reported individually and do not fail the batch.
+func (api *ImageAPI) ProcessBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobs, err := api.parseBatch(w, r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid batch request: %v", err), batchErrorStatus(err))
+		return
+	}
+
+	outputs := api.runBatch(r.Context(), endpointBatch, jobs, batchEvents{})
+	result := batchResult(outputs)
+	logging.AddAccessAttrs(r.Context(),
+		slog.Int("batch_items", len(jobs)),
+		slog.Int("batch_failed", result.Failed),
+	)
+
+	if r.URL.Query().Get("output") == "zip" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", "attachment; filename=images.zip")
+		if err := writeBatchZip(w, outputs, result); err != nil {
+			api.logger.ErrorContext(r.Context(), "failed to write batch archive", slog.Any("error", err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode batch result: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// parseBatch reads the uploaded files and JSON item list of a batch request.
+// Oversized batches are rejected before any image is read.
+func (api *ImageAPI) parseBatch(w http.ResponseWriter, r *http.Request) ([]batchJob, error) {
+	shared := getProcessOptionsFromRequest(r)
+	maxItems := api.maxBatchItems
+	if maxItems <= 0 {
+		maxItems = defaultMaxBatchItems
+	}
+
+	var (
+		req     BatchRequest
+		doc     []byte // The JSON document, which a signature covers
+		uploads []batchJob
+	)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "multipart/form-data":
+		r.Body = http.MaxBytesReader(w, r.Body, maxBatchUploadSize)
+		if err := r.ParseMultipartForm(batchFormMemory); err != nil {
+			return nil, fmt.Errorf("failed to parse multipart form: %w", err)
+		}
+		if n := len(r.MultipartForm.File["images"]); n > maxItems {
+			return nil, batchTooLarge(n, maxItems)
+		}
+		files, err := api.imageHandler.GetImagesFromUpload(r, "images")
+		if err != nil {
+			return nil, err
+		}
+		if field := r.FormValue("request"); field != "" {
+			doc = []byte(field)
+			if err := json.Unmarshal(doc, &req); err != nil {
+				return nil, fmt.Errorf("invalid request field: %w", err)
+			}
+		}
+		for _, file := range files {
+			uploads = append(uploads, batchJob{
+				name:   path.Base(strings.ReplaceAll(file.Filename, "\\", "/")),
+				source: sourceUpload,
+				data:   file.Data,
+				err:    api.imageHandler.ValidateFileType(file.Filename),
+			})
+		}
+	case "application/json":
+		var err error
+		if doc, err = io.ReadAll(io.LimitReader(r.Body, maxBatchJSONSize)); err != nil {
+			return nil, fmt.Errorf("failed to read JSON body: %w", err)
+		}
+		if err := json.Unmarshal(doc, &req); err != nil {
+			return nil, fmt.Errorf("invalid JSON body: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+
+	if len(req.Items) > maxItems {
+		return nil, batchTooLarge(len(req.Items), maxItems)
+	}
+	// URL items are only fetched if the whole document is signed, as the
+	// query of a URL processing request would be
+	if api.signatures != nil && slices.ContainsFunc(req.Items, func(item BatchItem) bool { return item.URL != "" }) {
+		if err := api.signatures.VerifyBody(r.URL.EscapedPath(), r.URL.Query(), doc, time.Now()); err != nil {
+			logging.AddAccessAttrs(r.Context(), slog.String("signature_error", err.Error()))
+			return nil, fmt.Errorf("%w: %w", ErrBatchURLUnsigned, err)
+		}
+	}
+	shared = req.Options.apply(shared)
+
+	// Per-file options are matched to uploads by filename
+	fileOptions := map[string]*OptionOverrides{}
+	jobs := make([]batchJob, 0, len(uploads)+len(req.Items))
+	for _, item := range req.Items {
+		if item.URL == "" {
+			if item.File != "" {
+				fileOptions[item.File] = item.Options
+			}
+			continue
+		}
+		jobs = append(jobs, batchJob{
+			name:    filenameFromURL(item.URL),
+			source:  sourceURL,
+			url:     item.URL,
+			options: limitOptions(r.Context(), item.Options.apply(shared)),
+		})
+	}
+	for i := range uploads {
+		uploads[i].options = limitOptions(r.Context(), fileOptions[uploads[i].name].apply(shared))
+	}
+	jobs = append(uploads, jobs...)
+
+	switch {
+	case len(jobs) == 0:
+		return nil, ErrEmptyBatch
+	case len(jobs) > maxItems:
+		return nil, batchTooLarge(len(jobs), maxItems)
+	}
+
+	return jobs, nil
+}
+
+// batchErrorStatus returns the status code for an error from parseBatch
+func batchErrorStatus(err error) int {
+	if errors.Is(err, ErrBatchURLUnsigned) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+// batchTooLarge reports a batch of n items over the limit
+func batchTooLarge(n, limit int) error {
+	return fmt.Errorf("%w: %d exceeds the limit of %d", ErrBatchTooLarge, n, limit)
+}
+
+// batchEvents receives progress notifications from runBatch. Either func
+// may be nil.
+type batchEvents struct {
+	// started is called when a job begins fetching or processing
+	started func(index int, job batchJob)
+
+	// finished is called with the output of each job as it completes
+	finished func(out batchOutput)
+}
+
+// runBatch processes jobs on up to batchConcurrency goroutines; the worker
+// limit bounds how many are decoded and encoded at once. Events are
+// delivered one call at a time. Outputs are returned in job order.
+func (api *ImageAPI) runBatch(ctx context.Context, endpoint string, jobs []batchJob, events batchEvents) []batchOutput {
+	outputs := make([]batchOutput, len(jobs))
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	notify := func(fn func()) {
+		mu.Lock()
+		defer mu.Unlock()
+		fn()
+	}
+	queue := make(chan int)
+	for range min(batchConcurrency, len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				job := jobs[i]
+				if events.started != nil {
+					notify(func() { events.started(i, job) })
+				}
+				outputs[i] = api.runBatchJob(ctx, endpoint, i, job)
+				if events.finished != nil {
+					notify(func() { events.finished(outputs[i]) })
+				}
+			}
+		}()
+	}
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	return outputs
+}
+
+// runBatchJob fetches (for URL items) and processes a single batch job
+func (api *ImageAPI) runBatchJob(ctx context.Context, endpoint string, index int, job batchJob) batchOutput {
+	out := batchOutput{result: models.BatchItemResult{
+		Index:  index,
+		Name:   job.name,
+		Source: job.source,
+		URL:    job.url,
+	}}
+
+	imageData, err := job.data, job.err
+	var remote *handler.RemoteImage
+	if err == nil && job.source == sourceURL {
+		remote, err = api.imageHandler.FetchImage(ctx, job.url)
+		if err == nil {
+			imageData = remote.Data
+			ratelimit.Charge(ctx, int64(len(imageData)))
+		}
+	}
+	if err != nil {
+		out.result.Error = err.Error()
+		return out
+	}
+
+	processedData, metadata, err := api.process(ctx, endpoint, imageData, &job.options)
+	if err != nil {
+		api.logger.WarnContext(ctx, "batch item failed", slog.Int("index", index), slog.Any("error", err))
+		out.result.Error = err.Error()
+		return out
+	}
+
+	if remote != nil {
+		metadata.SourceURL = remote.URL
+		metadata.SourceContentType = remote.ContentType
+	}
+	out.result.Metadata = metadata
+	out.data = processedData
+	return out
+}
+
+// batchResult summarizes batch outputs, assigning unique output file names
+// to the items that succeeded
+func batchResult(outputs []batchOutput) models.BatchResult {
+	result := models.BatchResult{Items: make([]models.BatchItemResult, len(outputs))}
+	used := map[string]int{}
+
+	for i := range outputs {
+		item := &outputs[i].result
+		if item.Error != "" {
+			result.Failed++
+		} else {
+			result.Succeeded++
+			item.Output = uniqueName(used, webpFilename(item.Name))
+		}
+		result.Items[i] = *item
+	}
+
+	return result
+}
+
+// writeBatchZip writes the successful outputs and a manifest.json to w
+func writeBatchZip(w io.Writer, outputs []batchOutput, result models.BatchResult) error {
+	zw := zip.NewWriter(w)
+
+	for _, out := range outputs {
+		if out.data == nil {
+			continue
+		}
+		// WebP data is already compressed
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: out.result.Output, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(out.data); err != nil {
+			return err
+		}
+	}
+
+	fw, err := zw.Create("manifest.json")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fw)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// filenameFromURL returns the last path segment of a URL, or "image"
+func filenameFromURL(rawURL string) string {
+	// Data URIs have no file name, and their data may contain slashes
+	if scheme, _, _ := strings.Cut(rawURL, ":"); strings.EqualFold(scheme, "data") {
+		return "image"
+	}
+	name := rawURL
+	if i := strings.IndexAny(name, "?#"); i != -1 {
+		name = name[:i]
+	}
+	if i := strings.LastIndex(name, "/"); i != -1 {
+		name = name[i+1:]
+	}
+	if unescaped, err := url.PathUnescape(name); err == nil {
+		name = unescaped
+	}
+	// Escaped and back slashes are separators too, as in uploaded file names
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == ".." || name == "/" {
+		return "image"
+	}
+	return name
+}
+
+// webpFilename replaces the extension of name with .webp
+func webpFilename(name string) string {
+	if ext := path.Ext(name); ext != "" {
+		name = strings.TrimSuffix(name, ext)
+	}
+	if name == "" {
+		name = "image"
+	}
+	return name + ".webp"
+}
+
+// uniqueName returns name, or name with a numeric suffix if it was already used
+func uniqueName(used map[string]int, name string) string {
+	count := used[name]
+	used[name] = count + 1
+	if count == 0 {
+		return name
+	}
+
+	ext := path.Ext(name)
+	candidate := strings.TrimSuffix(name, ext) + "-" + strconv.Itoa(count) + ext
+	return uniqueName(used, candidate)
+}
@@ -0,0 +1,276 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/handler"
+	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// newBatchTestAPI returns an API whose processor echoes the requested
+// quality in the output and fails on the input "corrupt"
+func newBatchTestAPI(uploads []handler.UploadedImage) *ImageAPI {
+	mockHandler := &MockImageHandler{
+		GetURLFunc: func(url string) ([]byte, error) {
+			if strings.Contains(url, "missing") {
+				return nil, handler.ErrHTTPRequestFailed
+			}
+			return []byte("remote " + url), nil
+		},
+		GetUploadsFunc: func(r *http.Request, fieldName string) ([]handler.UploadedImage, error) {
+			if err := r.ParseMultipartForm(1 << 20); err != nil {
+				return nil, err
+			}
+			return uploads, nil
+		},
+		ValidateTypeFunc: func(fileName string) error {
+			if strings.HasSuffix(fileName, ".txt") {
+				return handler.ErrInvalidFileType
+			}
+			return nil
+		},
+	}
+
+	mockProcessor := &MockImageProcessor{
+		ProcessBytesFunc: func(imageData []byte, options *processor.ProcessOptions) ([]byte, *models.ImageMetadata, error) {
+			if string(imageData) == "corrupt" {
+				return nil, nil, processor.ErrInvalidImage
+			}
+			out := []byte("webp q" + string(rune('0'+options.Quality/10)))
+			return out, &models.ImageMetadata{
+				OriginalSize: int64(len(imageData)),
+				NewSize:      int64(len(out)),
+				NewWidth:     options.MaxWidth,
+				NewFormat:    "webp",
+			}, nil
+		},
+	}
+
+	return NewImageAPI(mockHandler, mockProcessor, WithMaxWorkers(2), WithMaxBatchItems(4))
+}
+
+func TestProcessBatchJSON(t *testing.T) {
+	api := newBatchTestAPI(nil)
+
+	body := `{
+		"options": {"max_width": 640},
+		"items": [
+			{"url": "http://example.com/a.jpg"},
+			{"url": "http://example.com/missing.png"},
+			{"url": "http://example.com/b.jpg", "options": {"max_width": 320}}
+		]
+	}`
+	req := httptest.NewRequest("POST", "/process/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	api.ProcessBatch(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v: %s", w.Code, w.Body.String())
+	}
+
+	var result models.BatchResult
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode batch result: %v", err)
+	}
+
+	if result.Succeeded != 2 || result.Failed != 1 {
+		t.Errorf("Expected 2 succeeded and 1 failed, got %d/%d", result.Succeeded, result.Failed)
+	}
+	if len(result.Items) != 3 {
+		t.Fatalf("Expected 3 items, got %d", len(result.Items))
+	}
+	if result.Items[0].Metadata == nil || result.Items[0].Metadata.NewWidth != 640 {
+		t.Errorf("Expected shared max_width 640 for first item, got %+v", result.Items[0].Metadata)
+	}
+	if result.Items[1].Error == "" || result.Items[1].Metadata != nil {
+		t.Errorf("Expected second item to fail, got %+v", result.Items[1])
+	}
+	if result.Items[2].Metadata == nil || result.Items[2].Metadata.NewWidth != 320 {
+		t.Errorf("Expected per-item max_width 320 for third item, got %+v", result.Items[2].Metadata)
+	}
+	if result.Items[0].Output != "a.webp" {
+		t.Errorf("Expected output name a.webp, got %q", result.Items[0].Output)
+	}
+}
+
+func TestProcessBatchMultipartZip(t *testing.T) {
+	uploads := []handler.UploadedImage{
+		{Filename: "photo.jpg", Data: []byte("jpeg data")},
+		{Filename: "photo.png", Data: []byte("png data")},
+		{Filename: "notes.txt", Data: []byte("hello")},
+	}
+	api := newBatchTestAPI(uploads)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("request", `{"items": [{"file": "photo.png", "options": {"quality": 50}}]}`)
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/process/batch?output=zip&quality=90", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	api.ProcessBatch(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "application/zip" {
+		t.Errorf("Expected Content-Type application/zip, got %v", w.Header().Get("Content-Type"))
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read ZIP: %v", err)
+	}
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	if files["photo.webp"] != "webp q9" {
+		t.Errorf("Expected photo.webp encoded at shared quality, got %q", files["photo.webp"])
+	}
+	if files["photo-1.webp"] != "webp q5" {
+		t.Errorf("Expected photo-1.webp encoded at per-file quality, got %q", files["photo-1.webp"])
+	}
+
+	var manifest models.BatchResult
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("Failed to decode manifest.json: %v", err)
+	}
+	if manifest.Succeeded != 2 || manifest.Failed != 1 {
+		t.Errorf("Expected 2 succeeded and 1 failed in manifest, got %d/%d", manifest.Succeeded, manifest.Failed)
+	}
+	if manifest.Items[2].Name != "notes.txt" || manifest.Items[2].Error == "" {
+		t.Errorf("Expected notes.txt to be rejected, got %+v", manifest.Items[2])
+	}
+}
+
+func TestProcessBatchLimits(t *testing.T) {
+	api := newBatchTestAPI(nil)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "empty batch", body: `{"items": []}`},
+		{name: "too many items", body: `{"items": [{"url":"http://a/1"},{"url":"http://a/2"},{"url":"http://a/3"},{"url":"http://a/4"},{"url":"http://a/5"}]}`},
+		{name: "malformed json", body: `{"items": [`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/process/batch", strings.NewReader(tt.body)).WithContext(context.Background())
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			api.ProcessBatch(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status Bad Request, got %v", w.Code)
+			}
+		})
+	}
+}
+
+func TestProcessBatchTooManyUploads(t *testing.T) {
+	api := newBatchTestAPI(nil)
+	api.imageHandler.(*MockImageHandler).GetUploadsFunc = func(r *http.Request, fieldName string) ([]handler.UploadedImage, error) {
+		t.Error("Expected uploads not to be read")
+		return nil, nil
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i := 0; i < 5; i++ {
+		fw, _ := mw.CreateFormFile("images", fmt.Sprintf("photo%d.jpg", i))
+		fw.Write([]byte("jpeg data"))
+	}
+	mw.Close()
+
+	req := httptest.NewRequest("POST", "/process/batch", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	api.ProcessBatch(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request, got %v", w.Code)
+	}
+}
+
+func TestRunBatchBoundsConcurrency(t *testing.T) {
+	var running, peak atomic.Int32
+	api := newBatchTestAPI(nil)
+	api.imageHandler.(*MockImageHandler).GetURLFunc = func(url string) ([]byte, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		return []byte("remote " + url), nil
+	}
+
+	jobs := make([]batchJob, 3*batchConcurrency)
+	for i := range jobs {
+		jobs[i] = batchJob{name: "a.jpg", source: sourceURL, url: fmt.Sprintf("http://example.com/%d.jpg", i)}
+	}
+	outputs := api.runBatch(context.Background(), endpointBatch, jobs, batchEvents{})
+
+	if len(outputs) != len(jobs) {
+		t.Fatalf("Expected %d outputs, got %d", len(jobs), len(outputs))
+	}
+	for i, out := range outputs {
+		if out.result.Index != i || out.result.Error != "" {
+			t.Errorf("Expected item %d to succeed in order, got %+v", i, out.result)
+		}
+	}
+	if got := peak.Load(); got > batchConcurrency {
+		t.Errorf("Expected at most %d concurrent items, got %d", batchConcurrency, got)
+	}
+}
+
+func TestFilenameFromURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"http://example.com/photos/a.jpg?w=1#top", "a.jpg"},
+		{"http://example.com/my%20photo.jpg", "my photo.jpg"},
+		{"http://example.com/..%5C..%5Cevil.png", "evil.png"},
+		{`http://example.com/..\..\evil.png`, "evil.png"},
+		{"http://example.com/..", "image"},
+		{"http://example.com/", "image"},
+		{"data:image/png;base64,aGVsbG8=", "image"},
+	}
+
+	for _, tt := range tests {
+		if got := filenameFromURL(tt.url); got != tt.expected {
+			t.Errorf("Expected %q for %s, got %q", tt.expected, tt.url, got)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// CacheStats reports result cache hits, misses and usage
+func (api *ImageAPI) CacheStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if api.cache == nil {
+		http.Error(w, "Cache is not enabled", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(api.cache.Stats()); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode cache stats: %v", err), http.StatusInternalServerError)
+	}
+}
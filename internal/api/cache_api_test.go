@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/cache"
+	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// newCacheTestAPI returns a batch test API with a result cache, counting
+// how often the processor runs
+func newCacheTestAPI(t *testing.T) (*ImageAPI, *int) {
+	t.Helper()
+
+	c, err := cache.New(cache.Config{MemoryMaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("cache.New() error = %v", err)
+	}
+	api := newBatchTestAPI(nil)
+	WithCache(c)(api)
+
+	calls := 0
+	mockProcessor := api.processor.(*MockImageProcessor)
+	process := mockProcessor.ProcessBytesFunc
+	mockProcessor.ProcessBytesFunc = func(imageData []byte, options *processor.ProcessOptions) ([]byte, *models.ImageMetadata, error) {
+		calls++
+		return process(imageData, options)
+	}
+	return api, &calls
+}
+
+func TestProcessFromURLCache(t *testing.T) {
+	api, calls := newCacheTestAPI(t)
+
+	requests := []struct {
+		query         string
+		expectedCache string
+		expectedCalls int
+	}{
+		{"url=http://example.com/a.jpg&max_width=800", "MISS", 1},
+		{"url=http://example.com/a.jpg&max_width=800", "HIT", 1},
+		{"url=http://example.com/a.jpg&max_width=800&quality=85", "HIT", 1}, // 85 is the default
+		{"url=http://example.com/a.jpg&max_width=640", "MISS", 2},
+		{"url=http://example.com/b.jpg&max_width=800", "MISS", 3},
+	}
+
+	for _, tt := range requests {
+		req := httptest.NewRequest("GET", "/process/url?"+tt.query+"&metadata=true", nil)
+		w := httptest.NewRecorder()
+
+		api.ProcessFromURL(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expected status OK, got %v", tt.query, w.Code)
+		}
+		if got := w.Header().Get("X-Cache"); got != tt.expectedCache {
+			t.Errorf("%s: expected X-Cache %s, got %s", tt.query, tt.expectedCache, got)
+		}
+		if *calls != tt.expectedCalls {
+			t.Errorf("%s: expected %d processor calls, got %d", tt.query, tt.expectedCalls, *calls)
+		}
+
+		var metadata models.ImageMetadata
+		json.Unmarshal(w.Body.Bytes(), &metadata)
+		if metadata.Cache != tt.expectedCache {
+			t.Errorf("%s: expected cache %s in metadata, got %s", tt.query, tt.expectedCache, metadata.Cache)
+		}
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	api, _ := newCacheTestAPI(t)
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/process/url?url=http://example.com/a.jpg", nil)
+		api.ProcessFromURL(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	api.CacheStats(w, httptest.NewRequest("GET", "/cache/stats", nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", w.Code)
+	}
+	var stats cache.Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	if stats.Hits != 1 || stats.Misses != 1 || stats.MemoryEntries != 1 {
+		t.Errorf("Expected 1 hit, 1 miss and 1 entry, got %+v", stats)
+	}
+}
+
+func TestCacheStatsDisabled(t *testing.T) {
+	api := newBatchTestAPI(nil)
+
+	w := httptest.NewRecorder()
+	api.CacheStats(w, httptest.NewRequest("GET", "/cache/stats", nil))
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound, got %v", w.Code)
+	}
+}
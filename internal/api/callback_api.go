@@ -0,0 +1,240 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/jobs"
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+	"github.com/Mark-Life/smart-webp-resize/internal/webhook"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// Values of the callback_output parameter
+const (
+	callbackOutputInline = "inline"
+	callbackOutputLink   = "link"
+)
+
+// defaultCallbackInlineLimit is the largest output embedded in a callback
+// when no limit is configured
+const defaultCallbackInlineLimit = 1 << 20
+
+// WithWebhooks enables callback_url on the processing endpoints. Outputs up
+// to inlineLimit bytes are embedded in the callback; larger ones are kept in
+// the job store and sent as a download link.
+func WithWebhooks(sender *webhook.Sender, inlineLimit int64) Option {
+	return func(api *ImageAPI) {
+		api.webhooks = sender
+		api.callbackInlineLimit = inlineLimit
+	}
+}
+
+// WithPublicURL sets the base URL of download links sent in callbacks, e.g.
+// https://images.example.com. By default links use the host of the request.
+func WithPublicURL(base string) Option {
+	return func(api *ImageAPI) {
+		api.publicURL = strings.TrimSuffix(base, "/")
+	}
+}
+
+// callbackRequest is an image accepted for background processing
+type callbackRequest struct {
+	job         *jobs.Job
+	endpoint    string
+	callbackURL string
+	output      string
+	baseURL     string
+	image       batchJob
+}
+
+// acceptCallback answers 202 Accepted for a request with a callback_url and
+// processes the image in the background. The response is the job tracking
+// the conversion, which can also be polled if a job store is configured.
+func (api *ImageAPI) acceptCallback(w http.ResponseWriter, r *http.Request, endpoint, callbackURL string, image batchJob) {
+	ctx := r.Context()
+	if api.webhooks == nil {
+		http.Error(w, "Callbacks are not enabled", http.StatusBadRequest)
+		return
+	}
+	// Callbacks are delivered over HTTP whichever schemes images may come from
+	if u, err := url.Parse(callbackURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		http.Error(w, "Invalid callback_url: scheme must be http or https", http.StatusBadRequest)
+		return
+	}
+	if err := api.imageHandler.ValidateURL(callbackURL); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid callback_url: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	output := r.URL.Query().Get("callback_output")
+	switch output {
+	case "", callbackOutputInline:
+	case callbackOutputLink:
+		if api.jobs == nil {
+			http.Error(w, "Download links are not available without a job store", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Invalid callback_output %q: expected inline or link", output), http.StatusBadRequest)
+		return
+	}
+
+	job := &jobs.Job{Total: 1}
+	if api.jobs != nil {
+		if err := api.jobs.Create(ctx, job); err != nil {
+			api.logger.ErrorContext(ctx, "failed to create job", slog.Any("error", err))
+			http.Error(w, fmt.Sprintf("Failed to create job: %v", err), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		job.ID = jobs.NewID()
+		job.Status = jobs.StatusQueued
+		job.CreatedAt = time.Now().UTC()
+		job.UpdatedAt = job.CreatedAt
+	}
+	logging.AddAccessAttrs(ctx, slog.String("job_id", job.ID), slog.String("callback_url", callbackURL))
+
+	// Processing outlives the request but keeps its request ID and trace
+	req := callbackRequest{
+		job:         job,
+		endpoint:    endpoint,
+		callbackURL: callbackURL,
+		output:      output,
+		baseURL:     api.baseURL(r),
+		image:       image,
+	}
+	accepted := *job // The worker may update the job while it is encoded
+	backgroundCtx := context.WithoutCancel(ctx)
+	if !api.background().submit(func() { api.runCallback(backgroundCtx, req) }) {
+		job.Status = jobs.StatusFailed
+		job.Error = "too many callbacks queued"
+		api.updateJob(ctx, api.logger, job)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many callbacks queued, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	if api.jobs != nil {
+		w.Header().Set("Location", "/jobs/"+job.ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(accepted)
+}
+
+// runCallback processes an accepted image and delivers the outcome to its
+// callback URL
+func (api *ImageAPI) runCallback(ctx context.Context, req callbackRequest) {
+	job := req.job
+	logger := api.logger.With(slog.String("job_id", job.ID))
+
+	job.Status = jobs.StatusRunning
+	api.updateJob(ctx, logger, job)
+
+	outputs := []batchOutput{api.runBatchJob(ctx, req.endpoint, 0, req.image)}
+	result := batchResult(outputs)
+	item := result.Items[0]
+
+	payload := models.CallbackPayload{
+		ID:     job.ID,
+		Source: item.Source,
+		URL:    item.URL,
+	}
+	job.Completed = 1
+	job.Result = &result
+
+	if item.Error != "" {
+		job.Status = jobs.StatusFailed
+		job.Error = item.Error
+	} else {
+		job.Status = jobs.StatusSucceeded
+		payload.Metadata = item.Metadata
+		payload.Filename = item.Output
+		payload.ContentType = "image/webp"
+		api.attachCallbackOutput(ctx, logger, req, &payload, outputs[0].data)
+	}
+	payload.Status = string(job.Status)
+	payload.Error = job.Error
+	api.updateJob(ctx, logger, job)
+
+	if err := api.webhooks.Deliver(ctx, req.callbackURL, job.ID, payload); err != nil {
+		logger.ErrorContext(ctx, "failed to deliver callback",
+			slog.String("callback_url", req.callbackURL),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// attachCallbackOutput stores the output in the job store, if there is one,
+// and either embeds it in the payload or links to it. Outputs that cannot be
+// stored are sent inline.
+func (api *ImageAPI) attachCallbackOutput(ctx context.Context, logger *slog.Logger, req callbackRequest, payload *models.CallbackPayload, data []byte) {
+	link := req.output == callbackOutputLink ||
+		(req.output == "" && int64(len(data)) > api.inlineLimit())
+
+	if api.jobs != nil {
+		err := api.jobs.PutOutput(ctx, req.job.ID, &jobs.Output{
+			ContentType: payload.ContentType,
+			Filename:    payload.Filename,
+			Data:        data,
+		})
+		if err == nil && link {
+			payload.DownloadURL = req.baseURL + "/jobs/" + req.job.ID + "/result"
+			payload.ExpiresAt = &req.job.ExpiresAt
+			return
+		}
+		if err != nil {
+			logger.WarnContext(ctx, "failed to store callback output", slog.Any("error", err))
+		}
+	}
+	payload.Data = data
+}
+
+// updateJob records the state of a callback job if a job store is configured
+func (api *ImageAPI) updateJob(ctx context.Context, logger *slog.Logger, job *jobs.Job) {
+	if api.jobs == nil {
+		return
+	}
+	if err := api.jobs.Update(ctx, job); err != nil {
+		logger.WarnContext(ctx, "failed to update job", slog.Any("error", err))
+	}
+}
+
+// inlineLimit returns the largest output embedded in a callback
+func (api *ImageAPI) inlineLimit() int64 {
+	if api.callbackInlineLimit > 0 {
+		return api.callbackInlineLimit
+	}
+	return defaultCallbackInlineLimit
+}
+
+// baseURL returns the URL prefix for links back to this service
+func (api *ImageAPI) baseURL(r *http.Request) string {
+	if api.publicURL != "" {
+		return api.publicURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// uploadFilename returns the client-side name of the file uploaded under
+// fieldName, or "image" if it is unknown
+func uploadFilename(r *http.Request, fieldName string) string {
+	if r.MultipartForm != nil {
+		if files := r.MultipartForm.File[fieldName]; len(files) > 0 && files[0].Filename != "" {
+			return path.Base(strings.ReplaceAll(files[0].Filename, "\\", "/"))
+		}
+	}
+	return "image"
+}
@@ -0,0 +1,290 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/handler"
+	"github.com/Mark-Life/smart-webp-resize/internal/jobs"
+	"github.com/Mark-Life/smart-webp-resize/internal/webhook"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+const testWebhookSecret = "webhook-secret"
+
+// newCallbackTestAPI returns a batch test API that can send callbacks
+func newCallbackTestAPI(opts ...Option) *ImageAPI {
+	api := newBatchTestAPI(nil)
+	mockHandler := api.imageHandler.(*MockImageHandler)
+	mockHandler.ValidateURLFunc = func(u string) error {
+		if !strings.HasPrefix(u, "http") {
+			return handler.ErrInvalidURL
+		}
+		return nil
+	}
+	mockHandler.GetUploadFunc = func(r *http.Request, fieldName string) ([]byte, error) {
+		file, _, err := r.FormFile(fieldName)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return io.ReadAll(file)
+	}
+
+	sender := webhook.New(testWebhookSecret, webhook.WithRetries(1, time.Millisecond))
+	WithWebhooks(sender, 0)(api)
+	for _, opt := range opts {
+		opt(api)
+	}
+	return api
+}
+
+// callbackReceiver starts a server that verifies and records callbacks
+func callbackReceiver(t *testing.T) (*httptest.Server, <-chan models.CallbackPayload) {
+	t.Helper()
+
+	payloads := make(chan models.CallbackPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify([]byte(testWebhookSecret), r.Header, body, time.Minute); err != nil {
+			t.Errorf("Expected a signed callback, got %v", err)
+		}
+		var payload models.CallbackPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("Failed to decode callback: %v", err)
+		}
+		payloads <- payload
+	}))
+	t.Cleanup(server.Close)
+	return server, payloads
+}
+
+// waitForCallback returns the next callback or fails the test
+func waitForCallback(t *testing.T, payloads <-chan models.CallbackPayload) models.CallbackPayload {
+	t.Helper()
+
+	select {
+	case payload := <-payloads:
+		return payload
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for callback")
+		return models.CallbackPayload{}
+	}
+}
+
+func TestProcessFromURLWithCallback(t *testing.T) {
+	receiver, payloads := callbackReceiver(t)
+	api := newCallbackTestAPI()
+
+	query := url.Values{
+		"url":          {"http://example.com/photo.jpg"},
+		"callback_url": {receiver.URL},
+	}
+	req := httptest.NewRequest("GET", "/process/url?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+
+	api.ProcessFromURL(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status Accepted, got %v: %s", w.Code, w.Body.String())
+	}
+	var job jobs.Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatalf("Failed to decode job: %v", err)
+	}
+
+	payload := waitForCallback(t, payloads)
+	if payload.ID != job.ID {
+		t.Errorf("Expected callback ID %s, got %s", job.ID, payload.ID)
+	}
+	if payload.Status != string(jobs.StatusSucceeded) {
+		t.Fatalf("Expected status succeeded, got %s (%s)", payload.Status, payload.Error)
+	}
+	if payload.Filename != "photo.webp" || payload.URL != "http://example.com/photo.jpg" {
+		t.Errorf("Expected photo.webp from the source URL, got %s from %s", payload.Filename, payload.URL)
+	}
+	if string(payload.Data) != "webp q8" {
+		t.Errorf("Expected the output inline, got %q", payload.Data)
+	}
+	if payload.Metadata == nil || payload.Metadata.NewFormat != "webp" {
+		t.Errorf("Expected metadata in the callback, got %+v", payload.Metadata)
+	}
+}
+
+func TestShutdownDeliversAcceptedCallbacks(t *testing.T) {
+	receiver, payloads := callbackReceiver(t)
+	api := newCallbackTestAPI()
+
+	query := url.Values{
+		"url":          {"http://example.com/photo.jpg"},
+		"callback_url": {receiver.URL},
+	}
+	w := httptest.NewRecorder()
+	api.ProcessFromURL(w, httptest.NewRequest("GET", "/process/url?"+query.Encode(), nil))
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status Accepted, got %v: %s", w.Code, w.Body.String())
+	}
+
+	if err := api.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected shutdown to succeed, got %v", err)
+	}
+	select {
+	case <-payloads:
+	default:
+		t.Error("Expected the callback to be delivered before shutdown returned")
+	}
+
+	w = httptest.NewRecorder()
+	api.ProcessFromURL(w, httptest.NewRequest("GET", "/process/url?"+query.Encode(), nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status Service Unavailable after shutdown, got %v", w.Code)
+	}
+}
+
+func TestProcessFromUploadWithCallbackLink(t *testing.T) {
+	receiver, payloads := callbackReceiver(t)
+	api := newCallbackTestAPI(WithJobStore(jobs.NewMemoryStore(time.Hour)), WithPublicURL("https://images.example.com/"))
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("image", "cat.png")
+	part.Write([]byte("cat"))
+	writer.Close()
+
+	query := url.Values{
+		"callback_url":    {receiver.URL},
+		"callback_output": {"link"},
+	}
+	req := httptest.NewRequest("POST", "/process/upload?"+query.Encode(), body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+
+	api.ProcessFromUpload(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status Accepted, got %v: %s", w.Code, w.Body.String())
+	}
+
+	payload := waitForCallback(t, payloads)
+	if payload.Status != string(jobs.StatusSucceeded) {
+		t.Fatalf("Expected status succeeded, got %s (%s)", payload.Status, payload.Error)
+	}
+	if len(payload.Data) != 0 {
+		t.Errorf("Expected no inline data, got %d bytes", len(payload.Data))
+	}
+	expectedURL := "https://images.example.com/jobs/" + payload.ID + "/result"
+	if payload.DownloadURL != expectedURL {
+		t.Errorf("Expected download URL %s, got %s", expectedURL, payload.DownloadURL)
+	}
+	if payload.ExpiresAt == nil {
+		t.Error("Expected the link expiry in the callback")
+	}
+
+	result := getJobResult(api, payload.ID)
+	if result.Code != http.StatusOK {
+		t.Fatalf("Expected the linked output to be downloadable, got %v", result.Code)
+	}
+	if disposition := result.Header().Get("Content-Disposition"); !strings.Contains(disposition, "cat.webp") {
+		t.Errorf("Expected cat.webp in Content-Disposition, got %s", disposition)
+	}
+}
+
+func TestCallbackReportsFailure(t *testing.T) {
+	receiver, payloads := callbackReceiver(t)
+	api := newCallbackTestAPI()
+
+	query := url.Values{
+		"url":          {"http://example.com/missing.jpg"},
+		"callback_url": {receiver.URL},
+	}
+	req := httptest.NewRequest("GET", "/process/url?"+query.Encode(), nil)
+	w := httptest.NewRecorder()
+
+	api.ProcessFromURL(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status Accepted, got %v", w.Code)
+	}
+	payload := waitForCallback(t, payloads)
+	if payload.Status != string(jobs.StatusFailed) || payload.Error == "" {
+		t.Errorf("Expected a failed callback with an error, got %+v", payload)
+	}
+}
+
+func TestCallbackValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		api   *ImageAPI
+		query url.Values
+	}{
+		{
+			name: "callbacks disabled",
+			api:  newBatchTestAPI(nil),
+			query: url.Values{
+				"url":          {"http://example.com/a.jpg"},
+				"callback_url": {"http://example.com/hook"},
+			},
+		},
+		{
+			name: "invalid callback url",
+			api:  newCallbackTestAPI(),
+			query: url.Values{
+				"url":          {"http://example.com/a.jpg"},
+				"callback_url": {"ftp://example.com/hook"},
+			},
+		},
+		{
+			// The handler accepts other sources, but callbacks need HTTP
+			name: "non-http callback url",
+			api: func() *ImageAPI {
+				api := newCallbackTestAPI()
+				api.imageHandler.(*MockImageHandler).ValidateURLFunc = func(string) error { return nil }
+				return api
+			}(),
+			query: url.Values{
+				"url":          {"http://example.com/a.jpg"},
+				"callback_url": {"file:///var/hooks/a"},
+			},
+		},
+		{
+			name: "link without job store",
+			api:  newCallbackTestAPI(),
+			query: url.Values{
+				"url":             {"http://example.com/a.jpg"},
+				"callback_url":    {"http://example.com/hook"},
+				"callback_output": {"link"},
+			},
+		},
+		{
+			name: "unknown output mode",
+			api:  newCallbackTestAPI(),
+			query: url.Values{
+				"url":             {"http://example.com/a.jpg"},
+				"callback_url":    {"http://example.com/hook"},
+				"callback_output": {"email"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/process/url?"+tt.query.Encode(), nil)
+			w := httptest.NewRecorder()
+
+			tt.api.ProcessFromURL(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status BadRequest, got %v: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/auth"
+	"github.com/Mark-Life/smart-webp-resize/internal/cache"
+	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+)
+
+// WithHTTPCaching sets the Cache-Control header sent with converted images
+// and whether responses carry Vary: Accept. An empty cacheControl sends no
+// Cache-Control header.
+func WithHTTPCaching(cacheControl string, varyAccept bool) Option {
+	return func(api *ImageAPI) {
+		api.cacheControl = cacheControl
+		api.varyAccept = varyAccept
+	}
+}
+
+// imageETag returns a strong ETag for the output of processing imageData
+// with options. It is the content address used by the result cache, so it
+// changes whenever the source, the effective options or the encoder do.
+func imageETag(imageData []byte, options processor.ProcessOptions) string {
+	return `"` + cache.Key(imageData, options.CacheKey()) + `"`
+}
+
+// setCacheHeaders sets the validator and caching headers of an image
+// response. Responses to requests made with an API key are private, so a
+// shared cache cannot serve them to clients without one.
+func (api *ImageAPI) setCacheHeaders(w http.ResponseWriter, r *http.Request, etag string) {
+	w.Header().Set("ETag", etag)
+	cacheControl := api.cacheControl
+	if auth.KeyFromContext(r.Context()) != nil {
+		cacheControl = privateCacheControl(cacheControl)
+	}
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	if api.varyAccept {
+		w.Header().Add("Vary", "Accept")
+	}
+}
+
+// privateCacheControl turns a Cache-Control header into one that only lets
+// the client cache the response, dropping the directives for shared caches
+func privateCacheControl(cacheControl string) string {
+	directives := []string{"private"}
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		name, _, _ := strings.Cut(strings.ToLower(directive), "=")
+		switch name {
+		case "", "public", "private", "s-maxage", "proxy-revalidate":
+			continue
+		}
+		directives = append(directives, directive)
+	}
+	return strings.Join(directives, ", ")
+}
+
+// etagMatches reports whether an If-None-Match header matches etag. As RFC
+// 9110 requires for If-None-Match, the comparison is weak: W/"x" matches "x".
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
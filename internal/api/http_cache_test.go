@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/auth"
+	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+func TestProcessFromURLCachingHeaders(t *testing.T) {
+	api := newBatchTestAPI(nil)
+	WithHTTPCaching("public, max-age=3600", true)(api)
+
+	calls := 0
+	mockProcessor := api.processor.(*MockImageProcessor)
+	process := mockProcessor.ProcessBytesFunc
+	mockProcessor.ProcessBytesFunc = func(imageData []byte, options *processor.ProcessOptions) ([]byte, *models.ImageMetadata, error) {
+		calls++
+		return process(imageData, options)
+	}
+
+	req := httptest.NewRequest("GET", "/process/url?url=http://example.com/a.jpg", nil)
+	w := httptest.NewRecorder()
+	api.ProcessFromURL(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", w.Code)
+	}
+	etag := w.Header().Get("ETag")
+	if len(etag) != 66 || etag[0] != '"' {
+		t.Errorf("Expected a strong quoted ETag, got %s", etag)
+	}
+	if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "public, max-age=3600" {
+		t.Errorf("Expected the configured Cache-Control, got %s", cacheControl)
+	}
+	if vary := w.Header().Get("Vary"); vary != "Accept" {
+		t.Errorf("Expected Vary: Accept, got %s", vary)
+	}
+
+	tests := []struct {
+		name         string
+		query        string
+		ifNoneMatch  string
+		expectedCode int
+	}{
+		{"matching etag", "url=http://example.com/a.jpg", etag, http.StatusNotModified},
+		{"weak matching etag", "url=http://example.com/a.jpg", "W/" + etag, http.StatusNotModified},
+		{"etag in list", "url=http://example.com/a.jpg", `"other", ` + etag, http.StatusNotModified},
+		{"different options", "url=http://example.com/a.jpg&quality=50", etag, http.StatusOK},
+		{"different source", "url=http://example.com/b.jpg", etag, http.StatusOK},
+		{"metadata response", "url=http://example.com/a.jpg&metadata=true", etag, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := calls
+			req := httptest.NewRequest("GET", "/process/url?"+tt.query, nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			w := httptest.NewRecorder()
+
+			api.ProcessFromURL(w, req)
+
+			if w.Code != tt.expectedCode {
+				t.Fatalf("Expected status %v, got %v", tt.expectedCode, w.Code)
+			}
+			if tt.expectedCode == http.StatusNotModified {
+				if calls != before {
+					t.Error("Expected a 304 without processing the image")
+				}
+				if w.Body.Len() != 0 || w.Header().Get("ETag") != etag {
+					t.Errorf("Expected an empty 304 with ETag %s, got %q and %s", etag, w.Body.String(), w.Header().Get("ETag"))
+				}
+			}
+		})
+	}
+}
+
+func TestProcessFromURLCachingHeadersWithAPIKey(t *testing.T) {
+	api := newBatchTestAPI(nil)
+	WithHTTPCaching("public, max-age=3600, s-maxage=600", true)(api)
+	authenticator, err := auth.New([]auth.Key{{ID: "client", Secret: "secret"}})
+	if err != nil {
+		t.Fatalf("auth.New() error = %v", err)
+	}
+	h := authenticator.Require("process_url", http.HandlerFunc(api.ProcessFromURL))
+
+	for _, ifNoneMatch := range []string{"", "*"} {
+		req := httptest.NewRequest("GET", "/process/url?url=http://example.com/a.jpg", nil)
+		req.Header.Set(auth.HeaderAPIKey, "secret")
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK && w.Code != http.StatusNotModified {
+			t.Fatalf("Expected status OK or Not Modified, got %v", w.Code)
+		}
+		if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "private, max-age=3600" {
+			t.Errorf("Expected a private Cache-Control for status %d, got %s", w.Code, cacheControl)
+		}
+	}
+}
+
+func TestPrivateCacheControl(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", "private"},
+		{"public, max-age=86400", "private, max-age=86400"},
+		{"max-age=60, S-MAXAGE=600, proxy-revalidate", "private, max-age=60"},
+		{"private, no-cache", "private, no-cache"},
+		{"no-store", "private, no-store"},
+	}
+
+	for _, tt := range tests {
+		if got := privateCacheControl(tt.header); got != tt.expected {
+			t.Errorf("privateCacheControl(%q): expected %q, got %q", tt.header, tt.expected, got)
+		}
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected bool
+	}{
+		{"", false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"x", "abc"`, true},
+		{`*`, true},
+		{`"abcd"`, false},
+		{`abc`, false},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, `"abc"`); got != tt.expected {
+			t.Errorf("etagMatches(%q): expected %v, got %v", tt.header, tt.expected, got)
+		}
+	}
+}
@@ -1,28 +1,177 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Mark-Life/smart-webp-resize/internal/archive"
+	"github.com/Mark-Life/smart-webp-resize/internal/cache"
+	"github.com/Mark-Life/smart-webp-resize/internal/flight"
 	"github.com/Mark-Life/smart-webp-resize/internal/handler"
+	"github.com/Mark-Life/smart-webp-resize/internal/jobs"
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+	"github.com/Mark-Life/smart-webp-resize/internal/metrics"
 	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+	"github.com/Mark-Life/smart-webp-resize/internal/ratelimit"
+	"github.com/Mark-Life/smart-webp-resize/internal/storage"
+	"github.com/Mark-Life/smart-webp-resize/internal/webhook"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+	"github.com/Mark-Life/smart-webp-resize/pkg/signing"
 )
 
 // ImageAPI handles HTTP requests for image processing
 type ImageAPI struct {
-	imageHandler handler.ImageHandler
-	processor    processor.ImageProcessor
+	imageHandler        handler.ImageHandler
+	processor           processor.ImageProcessor
+	logger              *slog.Logger
+	metrics             *metrics.Metrics
+	maxWorkers          int
+	workers             *workerLimiter
+	maxBatchItems       int
+	archiveLimits       archive.Limits
+	jobs                jobs.Store
+	webhooks            *webhook.Sender
+	callbackInlineLimit int64
+	publicURL           string
+	storage             storage.Backend
+	cache               *cache.Cache
+	cacheControl        string
+	varyAccept          bool
+	processing          flight.Group[processed]
+	signatures          *signing.Verifier
+	backgroundQueueSize int
+	backgroundOnce      sync.Once
+	backgroundTasks     *backgroundQueue
+}
+
+// Endpoint names used to label metrics
+const (
+	endpointURL    = "url"
+	endpointUpload = "upload"
+)
+
+// Option configures optional ImageAPI dependencies
+type Option func(*ImageAPI)
+
+// WithLogger sets the logger used for request diagnostics
+func WithLogger(logger *slog.Logger) Option {
+	return func(api *ImageAPI) {
+		api.logger = logger
+	}
+}
+
+// WithMetrics sets where request and processing metrics are recorded
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(api *ImageAPI) {
+		api.metrics = m
+	}
+}
+
+// WithMaxWorkers limits how many images are processed concurrently. Requests
+// beyond the limit queue until a worker is free. Zero means unlimited.
+func WithMaxWorkers(n int) Option {
+	return func(api *ImageAPI) {
+		api.maxWorkers = n
+	}
+}
+
+// WithCache sets the cache of processing results. Identical inputs processed
+// with equivalent options are served from it without decoding.
+func WithCache(c *cache.Cache) Option {
+	return func(api *ImageAPI) {
+		api.cache = c
+	}
 }
 
 // NewImageAPI creates a new ImageAPI with the provided dependencies
-func NewImageAPI(imageHandler handler.ImageHandler, processor processor.ImageProcessor) *ImageAPI {
-	return &ImageAPI{
+func NewImageAPI(imageHandler handler.ImageHandler, processor processor.ImageProcessor, opts ...Option) *ImageAPI {
+	api := &ImageAPI{
 		imageHandler: imageHandler,
 		processor:    processor,
+		logger:       logging.Discard(),
+	}
+	for _, opt := range opts {
+		opt(api)
+	}
+	api.workers = newWorkerLimiter(api.maxWorkers, api.metrics)
+	return api
+}
+
+// Values of ImageMetadata.Cache and the X-Cache header
+const (
+	cacheHit  = "HIT"
+	cacheMiss = "MISS"
+)
+
+// process returns the cached result for the image and options if there is
+// one, and otherwise runs the processor under the worker limit and caches the
+// result. Concurrent requests for the same result share one run. Metrics are
+// recorded either way.
+func (api *ImageAPI) process(ctx context.Context, endpoint string, imageData []byte, options *processor.ProcessOptions) ([]byte, *models.ImageMetadata, error) {
+	key := cache.Key(imageData, options.CacheKey())
+	if entry, ok := api.cache.Get(key); ok {
+		metadata := entry.Metadata
+		metadata.Timings = models.Timings{}
+		metadata.Cache = cacheHit
+		api.metrics.ObserveImage(endpoint, metadata.OriginalFormat, int64(len(imageData)), int64(len(entry.Data)))
+		return entry.Data, &metadata, nil
+	}
+
+	result, shared, err := api.processing.Do(ctx, key, func(ctx context.Context) (processed, error) {
+		return api.runProcessor(ctx, key, imageData, options)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if shared {
+		logging.AddAccessAttrs(ctx, slog.Bool("coalesced", true))
+	}
+
+	// Each caller gets its own metadata; the data is shared
+	var metadata *models.ImageMetadata
+	format := ""
+	if result.metadata != nil {
+		copied := *result.metadata
+		metadata = &copied
+		format = metadata.OriginalFormat
 	}
+	api.metrics.ObserveImage(endpoint, format, int64(len(imageData)), int64(len(result.data)))
+
+	return result.data, metadata, nil
+}
+
+// processed is the outcome of a processor run shared between coalesced
+// requests
+type processed struct {
+	data     []byte
+	metadata *models.ImageMetadata
+}
+
+// runProcessor processes the image under the worker limit and caches the
+// result under key
+func (api *ImageAPI) runProcessor(ctx context.Context, key string, imageData []byte, options *processor.ProcessOptions) (processed, error) {
+	if err := api.workers.acquire(ctx); err != nil {
+		return processed{}, err
+	}
+	defer api.workers.release()
+
+	processedData, metadata, err := api.processor.ProcessFromBytes(ctx, imageData, options)
+	if err != nil {
+		return processed{}, err
+	}
+
+	if metadata != nil && api.cache != nil {
+		metadata.Cache = cacheMiss
+		api.cache.Put(key, &cache.Entry{Data: processedData, Metadata: *metadata})
+	}
+	return processed{data: processedData, metadata: metadata}, nil
 }
 
 // ProcessFromURL handles image processing requests where the image is specified by URL
@@ -31,6 +180,9 @@ func (api *ImageAPI) ProcessFromURL(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	if !api.verifySignature(w, r) {
+		return
+	}
 
 	// Get URL parameter
 	url := r.URL.Query().Get("url")
@@ -41,18 +193,37 @@ func (api *ImageAPI) ProcessFromURL(w http.ResponseWriter, r *http.Request) {
 
 	// Get processing options from query parameters
 	options := getProcessOptionsFromRequest(r)
+	storeOutput, ok := api.storeRequested(w, r)
+	if !ok {
+		return
+	}
 
-	// Fetch the image
-	imageData, err := api.imageHandler.GetImageFromURL(url)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to fetch image: %v", err), http.StatusBadRequest)
+	start := time.Now()
+	logOptions(r, options)
+
+	// With a callback the image is fetched and processed in the background
+	if callbackURL := r.URL.Query().Get("callback_url"); callbackURL != "" {
+		api.acceptCallback(w, r, endpointURL, callbackURL, batchJob{
+			name:    filenameFromURL(url),
+			source:  sourceURL,
+			url:     url,
+			options: options,
+		})
 		return
 	}
 
-	// Process the image
-	processedData, metadata, err := api.processor.ProcessFromBytes(imageData, &options)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to process image: %v", err), http.StatusInternalServerError)
+	// Fetch and process the image. The ETag is known before processing, so
+	// revalidation skips encoding.
+	returnsImage := !storeOutput && r.URL.Query().Get("metadata") != "true"
+	result, ok := api.processURL(w, r, endpointURL, url, options, returnsImage, start)
+	if !ok {
+		return
+	}
+	processedData, metadata := result.data, result.metadata
+
+	// Return where the image was stored instead of the image itself
+	if storeOutput {
+		api.writeStored(w, r, webpFilename(filenameFromURL(url)), processedData, metadata)
 		return
 	}
 
@@ -82,15 +253,80 @@ func (api *ImageAPI) ProcessFromURL(w http.ResponseWriter, r *http.Request) {
 				filename = origFilename + ".webp"
 			}
 		}
-		
+
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
 	}
 
 	// Return the processed image
+	api.writeImage(w, r, result.etag, processedData)
+}
+
+// urlResult is an image fetched from a URL and processed
+type urlResult struct {
+	data     []byte
+	metadata *models.ImageMetadata
+	etag     string
+}
+
+// processURL fetches the image at url and processes it with options, setting
+// the Server-Timing and X-Cache headers of the response. If conditional, a
+// client already holding the result is answered with 304 Not Modified
+// before processing. ok is false if a response has been written.
+func (api *ImageAPI) processURL(w http.ResponseWriter, r *http.Request, endpoint, url string, options processor.ProcessOptions, conditional bool, start time.Time) (result *urlResult, ok bool) {
+	ctx := r.Context()
+
+	// Fetch the image
+	fetchStart := time.Now()
+	source, err := api.imageHandler.FetchImage(ctx, url)
+	if err != nil {
+		api.logger.WarnContext(ctx, "failed to fetch image", slog.String("url", handler.DescribeURL(url)), slog.Any("error", err))
+		http.Error(w, fmt.Sprintf("Failed to fetch image: %v", err), http.StatusBadRequest)
+		return nil, false
+	}
+	imageData := source.Data
+	ratelimit.Charge(ctx, int64(len(imageData)))
+	fetchMs := msSince(fetchStart)
+	api.metrics.ObserveStage(metrics.StageFetch, time.Since(fetchStart))
+	logging.AddAccessAttrs(ctx,
+		slog.String("source_url", handler.DescribeURL(url)),
+		slog.Float64("fetch_ms", fetchMs),
+	)
+
+	etag := imageETag(imageData, options)
+	if conditional && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		api.setCacheHeaders(w, r, etag)
+		w.WriteHeader(http.StatusNotModified)
+		logging.AddAccessAttrs(ctx, slog.Bool("not_modified", true))
+		return nil, false
+	}
+
+	// Process the image
+	processStart := time.Now()
+	processedData, metadata, err := api.process(ctx, endpoint, imageData, &options)
+	if err != nil {
+		api.logger.ErrorContext(ctx, "failed to process image", slog.Any("error", err))
+		http.Error(w, fmt.Sprintf("Failed to process image: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	logResult(r, len(imageData), metadata, processStart)
+	metadata.SourceURL = source.URL
+	metadata.SourceContentType = source.ContentType
+	metadata.Timings.FetchMs = fetchMs
+	metadata.Timings.TotalMs = msSince(start)
+	setServerTiming(w, metadata.Timings, true)
+	if metadata.Cache != "" {
+		w.Header().Set("X-Cache", metadata.Cache)
+	}
+	return &urlResult{data: processedData, metadata: metadata, etag: etag}, true
+}
+
+// writeImage sends a processed image with its caching headers
+func (api *ImageAPI) writeImage(w http.ResponseWriter, r *http.Request, etag string, data []byte) {
+	api.setCacheHeaders(w, r, etag)
 	w.Header().Set("Content-Type", "image/webp")
-	w.Header().Set("Content-Length", strconv.Itoa(len(processedData)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
 	w.WriteHeader(http.StatusOK)
-	w.Write(processedData)
+	w.Write(data)
 }
 
 // ProcessFromUpload handles image upload requests
@@ -102,20 +338,54 @@ func (api *ImageAPI) ProcessFromUpload(w http.ResponseWriter, r *http.Request) {
 
 	// Get processing options from query parameters
 	options := getProcessOptionsFromRequest(r)
+	storeOutput, ok := api.storeRequested(w, r)
+	if !ok {
+		return
+	}
+
+	ctx := r.Context()
+	start := time.Now()
+	logOptions(r, options)
 
 	// Get the image from the form data
 	imageData, err := api.imageHandler.GetImageFromUpload(r, "image")
 	if err != nil {
+		api.logger.WarnContext(ctx, "failed to read upload", slog.Any("error", err))
 		http.Error(w, fmt.Sprintf("Failed to upload image: %v", err), http.StatusBadRequest)
 		return
 	}
 
+	// With a callback the image is processed in the background
+	if callbackURL := r.URL.Query().Get("callback_url"); callbackURL != "" {
+		api.acceptCallback(w, r, endpointUpload, callbackURL, batchJob{
+			name:    uploadFilename(r, "image"),
+			source:  sourceUpload,
+			data:    imageData,
+			options: options,
+		})
+		return
+	}
+
 	// Process the image
-	processedData, metadata, err := api.processor.ProcessFromBytes(imageData, &options)
+	processStart := time.Now()
+	processedData, metadata, err := api.process(ctx, endpointUpload, imageData, &options)
 	if err != nil {
+		api.logger.ErrorContext(ctx, "failed to process image", slog.Any("error", err))
 		http.Error(w, fmt.Sprintf("Failed to process image: %v", err), http.StatusInternalServerError)
 		return
 	}
+	logResult(r, len(imageData), metadata, processStart)
+	metadata.Timings.TotalMs = msSince(start)
+	setServerTiming(w, metadata.Timings, false)
+	if metadata.Cache != "" {
+		w.Header().Set("X-Cache", metadata.Cache)
+	}
+
+	// Return where the image was stored instead of the image itself
+	if storeOutput {
+		api.writeStored(w, r, webpFilename(uploadFilename(r, "image")), processedData, metadata)
+		return
+	}
 
 	// Check if metadata response is requested
 	if r.URL.Query().Get("metadata") == "true" {
@@ -130,7 +400,7 @@ func (api *ImageAPI) ProcessFromUpload(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Query().Get("download") == "true" || r.URL.Query().Get("format") == "webp" {
 		// Use the original filename from the form but change extension to .webp
 		filename := "image.webp"
-		
+
 		// Try to get the original filename from the form
 		file, fileHeader, err := r.FormFile("image")
 		if err == nil && fileHeader != nil {
@@ -144,7 +414,7 @@ func (api *ImageAPI) ProcessFromUpload(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 		}
-		
+
 		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
 	}
 
@@ -162,14 +432,10 @@ func (api *ImageAPI) Health(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "OK"})
 }
 
-// getProcessOptionsFromRequest extracts processing options from request query parameters
+// getProcessOptionsFromRequest extracts processing options from request query
+// parameters, within the limits of the request's API key
 func getProcessOptionsFromRequest(r *http.Request) processor.ProcessOptions {
-	options := processor.ProcessOptions{
-		MaxWidth:      1920, // Default max width
-		MaxHeight:     1080, // Default max height
-		Quality:       85,   // Default quality
-		PreserveRatio: true, // Default preserve ratio
-	}
+	options := keyDefaults(r.Context())
 
 	// Parse max width
 	if maxWidth := r.URL.Query().Get("max_width"); maxWidth != "" {
@@ -197,5 +463,66 @@ func getProcessOptionsFromRequest(r *http.Request) processor.ProcessOptions {
 		options.PreserveRatio = preserveRatio != "false"
 	}
 
-	return options
-} 
\ No newline at end of file
+	// Parse fit mode
+	if fit := r.URL.Query().Get("fit"); fit != "" && processor.ValidFit(fit) {
+		options.Fit = fit
+	}
+
+	return limitOptions(r.Context(), options)
+}
+
+// logOptions adds the processing options of a request to its access log line
+func logOptions(r *http.Request, options processor.ProcessOptions) {
+	logging.AddAccessAttrs(r.Context(),
+		slog.Int("max_width", options.MaxWidth),
+		slog.Int("max_height", options.MaxHeight),
+		slog.Int("quality", options.Quality),
+		slog.Bool("preserve_ratio", options.PreserveRatio),
+		slog.String("fit", options.Fit),
+	)
+}
+
+// logResult adds input/output sizes and processing time to the access log line
+func logResult(r *http.Request, inputSize int, metadata *models.ImageMetadata, processStart time.Time) {
+	attrs := []slog.Attr{
+		slog.Int("input_bytes", inputSize),
+		slog.Float64("process_ms", msSince(processStart)),
+	}
+	if metadata != nil {
+		attrs = append(attrs,
+			slog.String("input_format", metadata.OriginalFormat),
+			slog.Int64("output_bytes", metadata.NewSize),
+			slog.Int("size_reduction_percent", metadata.SizeReduction),
+		)
+	}
+	logging.AddAccessAttrs(r.Context(), attrs...)
+}
+
+// setServerTiming reports per-stage timings in a Server-Timing header. The
+// fetch stage is only reported if the image was fetched.
+func setServerTiming(w http.ResponseWriter, timings models.Timings, fetched bool) {
+	entries := []struct {
+		name string
+		ms   float64
+	}{
+		{"fetch", timings.FetchMs},
+		{"decode", timings.DecodeMs},
+		{"resize", timings.ResizeMs},
+		{"encode", timings.EncodeMs},
+		{"total", timings.TotalMs},
+	}
+
+	parts := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.name == "fetch" && !fetched {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s;dur=%.3f", e.name, e.ms))
+	}
+	w.Header().Set("Server-Timing", strings.Join(parts, ", "))
+}
+
+// msSince returns the milliseconds elapsed since start
+func msSince(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
@@ -2,13 +2,17 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Mark-Life/smart-webp-resize/internal/handler"
 	"github.com/Mark-Life/smart-webp-resize/internal/processor"
@@ -17,16 +21,26 @@ import (
 
 // MockImageHandler implements handler.ImageHandler for testing
 type MockImageHandler struct {
-	GetURLFunc      func(url string) ([]byte, error)
-	ValidateURLFunc func(url string) error
-	GetUploadFunc   func(r *http.Request, fieldName string) ([]byte, error)
+	GetURLFunc       func(url string) ([]byte, error)
+	ValidateURLFunc  func(url string) error
+	GetUploadFunc    func(r *http.Request, fieldName string) ([]byte, error)
+	GetUploadsFunc   func(r *http.Request, fieldName string) ([]handler.UploadedImage, error)
+	GetArchiveFunc   func(r *http.Request, fieldName string) ([]byte, error)
 	ValidateTypeFunc func(fileName string) error
 }
 
-func (m *MockImageHandler) GetImageFromURL(url string) ([]byte, error) {
+func (m *MockImageHandler) GetImageFromURL(ctx context.Context, url string) ([]byte, error) {
 	return m.GetURLFunc(url)
 }
 
+func (m *MockImageHandler) FetchImage(ctx context.Context, url string) (*handler.RemoteImage, error) {
+	data, err := m.GetURLFunc(url)
+	if err != nil {
+		return nil, err
+	}
+	return &handler.RemoteImage{Data: data, URL: url}, nil
+}
+
 func (m *MockImageHandler) ValidateURL(url string) error {
 	return m.ValidateURLFunc(url)
 }
@@ -35,21 +49,24 @@ func (m *MockImageHandler) GetImageFromUpload(r *http.Request, fieldName string)
 	return m.GetUploadFunc(r, fieldName)
 }
 
+func (m *MockImageHandler) GetArchiveFromUpload(r *http.Request, fieldName string) ([]byte, error) {
+	return m.GetArchiveFunc(r, fieldName)
+}
+
+func (m *MockImageHandler) GetImagesFromUpload(r *http.Request, fieldName string) ([]handler.UploadedImage, error) {
+	return m.GetUploadsFunc(r, fieldName)
+}
+
 func (m *MockImageHandler) ValidateFileType(fileName string) error {
 	return m.ValidateTypeFunc(fileName)
 }
 
 // MockImageProcessor implements processor.ImageProcessor for testing
 type MockImageProcessor struct {
-	ProcessURLFunc   func(url string, options *processor.ProcessOptions) ([]byte, *models.ImageMetadata, error)
 	ProcessBytesFunc func(imageData []byte, options *processor.ProcessOptions) ([]byte, *models.ImageMetadata, error)
 }
 
-func (m *MockImageProcessor) ProcessFromURL(url string, options *processor.ProcessOptions) ([]byte, *models.ImageMetadata, error) {
-	return m.ProcessURLFunc(url, options)
-}
-
-func (m *MockImageProcessor) ProcessFromBytes(imageData []byte, options *processor.ProcessOptions) ([]byte, *models.ImageMetadata, error) {
+func (m *MockImageProcessor) ProcessFromBytes(ctx context.Context, imageData []byte, options *processor.ProcessOptions) ([]byte, *models.ImageMetadata, error) {
 	return m.ProcessBytesFunc(imageData, options)
 }
 
@@ -150,6 +167,13 @@ func TestProcessFromURL(t *testing.T) {
 		if !bytes.Equal(body, mockProcessedData) {
 			t.Errorf("Response body does not match expected processed data")
 		}
+
+		serverTiming := resp.Header.Get("Server-Timing")
+		for _, stage := range []string{"fetch;dur=", "decode;dur=", "resize;dur=", "encode;dur=", "total;dur="} {
+			if !strings.Contains(serverTiming, stage) {
+				t.Errorf("Expected Server-Timing to contain %q, got %q", stage, serverTiming)
+			}
+		}
 	})
 
 	// Test metadata response
@@ -261,22 +285,22 @@ func TestProcessFromUpload(t *testing.T) {
 	createMultipartRequest := func(fieldName, fileName string, fileContent []byte) (*http.Request, error) {
 		var requestBody bytes.Buffer
 		multipartWriter := multipart.NewWriter(&requestBody)
-		
+
 		fileWriter, err := multipartWriter.CreateFormFile(fieldName, fileName)
 		if err != nil {
 			return nil, err
 		}
-		
+
 		_, err = fileWriter.Write(fileContent)
 		if err != nil {
 			return nil, err
 		}
-		
+
 		err = multipartWriter.Close()
 		if err != nil {
 			return nil, err
 		}
-		
+
 		req := httptest.NewRequest("POST", "/upload", &requestBody)
 		req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
 		return req, nil
@@ -288,7 +312,7 @@ func TestProcessFromUpload(t *testing.T) {
 		if err != nil {
 			t.Fatalf("Failed to create multipart request: %v", err)
 		}
-		
+
 		w := httptest.NewRecorder()
 		api.ProcessFromUpload(w, req)
 
@@ -311,6 +335,14 @@ func TestProcessFromUpload(t *testing.T) {
 		if !bytes.Equal(body, mockProcessedData) {
 			t.Errorf("Response body does not match expected processed data")
 		}
+
+		serverTiming := resp.Header.Get("Server-Timing")
+		if strings.Contains(serverTiming, "fetch;") {
+			t.Errorf("Expected no fetch stage for an upload, got %q", serverTiming)
+		}
+		if !strings.Contains(serverTiming, "total;dur=") {
+			t.Errorf("Expected Server-Timing to contain the total, got %q", serverTiming)
+		}
 	})
 
 	// Test invalid method
@@ -327,4 +359,58 @@ func TestProcessFromUpload(t *testing.T) {
 			t.Errorf("Expected status Method Not Allowed, got %v", resp.Status)
 		}
 	})
-} 
\ No newline at end of file
+}
+
+func TestProcessFromURLCoalescesConcurrentRequests(t *testing.T) {
+	api := newBatchTestAPI(nil)
+
+	const requests = 8
+	var arrived sync.WaitGroup
+	arrived.Add(requests)
+	mockHandler := api.imageHandler.(*MockImageHandler)
+	mockHandler.GetURLFunc = func(url string) ([]byte, error) {
+		arrived.Done()
+		arrived.Wait()
+		return []byte("image"), nil
+	}
+
+	var calls atomic.Int32
+	mockProcessor := api.processor.(*MockImageProcessor)
+	process := mockProcessor.ProcessBytesFunc
+	mockProcessor.ProcessBytesFunc = func(imageData []byte, options *processor.ProcessOptions) ([]byte, *models.ImageMetadata, error) {
+		calls.Add(1)
+		// Give the other requests time to join
+		time.Sleep(50 * time.Millisecond)
+		return process(imageData, options)
+	}
+
+	var done sync.WaitGroup
+	bodies := make([]string, requests)
+	for i := 0; i < requests; i++ {
+		done.Add(1)
+		go func(i int) {
+			defer done.Done()
+			w := httptest.NewRecorder()
+			api.ProcessFromURL(w, httptest.NewRequest("GET", "/process/url?url=http://example.com/a.jpg", nil))
+			bodies[i] = w.Body.String()
+		}(i)
+	}
+	done.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected 1 processor call, got %d", n)
+	}
+	for _, body := range bodies {
+		if body != "webp q8" {
+			t.Errorf("Expected webp q8, got %q", body)
+		}
+	}
+
+	// Later requests run again
+	w := httptest.NewRecorder()
+	arrived.Add(1)
+	api.ProcessFromURL(w, httptest.NewRequest("GET", "/process/url?url=http://example.com/a.jpg", nil))
+	if n := calls.Load(); n != 2 {
+		t.Errorf("Expected 2 processor calls, got %d", n)
+	}
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/jobs"
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// endpointJobs labels metrics for images processed as asynchronous jobs
+const endpointJobs = "jobs"
+
+// WithJobStore sets where asynchronous jobs and their outputs are kept
+func WithJobStore(store jobs.Store) Option {
+	return func(api *ImageAPI) {
+		api.jobs = store
+	}
+}
+
+// CreateJob accepts the same input as ProcessBatch, answers 202 Accepted
+// with the new job straight away and processes the images in the background
+func (api *ImageAPI) CreateJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !api.jobsEnabled(w) {
+		return
+	}
+
+	batch, err := api.parseBatch(w, r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid job request: %v", err), batchErrorStatus(err))
+		return
+	}
+
+	ctx := r.Context()
+	job := &jobs.Job{Total: len(batch)}
+	if err := api.jobs.Create(ctx, job); err != nil {
+		api.logger.ErrorContext(ctx, "failed to create job", slog.Any("error", err))
+		http.Error(w, fmt.Sprintf("Failed to create job: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logging.AddAccessAttrs(ctx, slog.String("job_id", job.ID), slog.Int("batch_items", len(batch)))
+
+	// The job outlives the request but keeps its request ID and trace
+	accepted := *job // The worker may update the job while it is encoded
+	backgroundCtx := context.WithoutCancel(ctx)
+	if !api.background().submit(func() { api.runJob(backgroundCtx, job, batch) }) {
+		job.Status = jobs.StatusFailed
+		job.Error = "too many jobs queued"
+		if err := api.jobs.Update(ctx, job); err != nil {
+			api.logger.WarnContext(ctx, "failed to update job", slog.Any("error", err))
+		}
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Too many jobs queued, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(accepted)
+}
+
+// GetJob returns the status, progress and metadata of a job
+func (api *ImageAPI) GetJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !api.jobsEnabled(w) {
+		return
+	}
+
+	job, err := api.jobs.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(job); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode job: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// GetJobResult returns the output of a finished job: the WebP image for a
+// single image job, or a ZIP of WebP files and a manifest.json otherwise
+func (api *ImageAPI) GetJobResult(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !api.jobsEnabled(w) {
+		return
+	}
+
+	ctx := r.Context()
+	id := r.PathValue("id")
+
+	job, err := api.jobs.Get(ctx, id)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	if !job.Done() {
+		http.Error(w, fmt.Sprintf("Job is %s", job.Status), http.StatusConflict)
+		return
+	}
+
+	output, err := api.jobs.GetOutput(ctx, id)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", output.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", output.Filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(output.Data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(output.Data)
+}
+
+// runJob processes a job's images, recording progress as each one finishes
+func (api *ImageAPI) runJob(ctx context.Context, job *jobs.Job, batch []batchJob) {
+	logger := api.logger.With(slog.String("job_id", job.ID))
+
+	job.Status = jobs.StatusRunning
+	if err := api.jobs.Update(ctx, job); err != nil {
+		logger.ErrorContext(ctx, "failed to update job", slog.Any("error", err))
+	}
+
+	outputs := api.runBatch(ctx, endpointJobs, batch, batchEvents{
+		finished: func(batchOutput) {
+			job.Completed++
+			if err := api.jobs.Update(ctx, job); err != nil {
+				logger.WarnContext(ctx, "failed to record job progress", slog.Any("error", err))
+			}
+		},
+	})
+
+	result := batchResult(outputs)
+	job.Result = &result
+
+	output, err := jobOutput(outputs, result)
+	switch {
+	case err != nil:
+		job.Status = jobs.StatusFailed
+		job.Error = err.Error()
+	case result.Succeeded == 0:
+		job.Status = jobs.StatusFailed
+		job.Error = "all images failed to process"
+	default:
+		if err = api.jobs.PutOutput(ctx, job.ID, output); err != nil {
+			job.Status = jobs.StatusFailed
+			job.Error = fmt.Sprintf("failed to store output: %v", err)
+		} else {
+			job.Status = jobs.StatusSucceeded
+		}
+	}
+
+	if err := api.jobs.Update(ctx, job); err != nil {
+		logger.ErrorContext(ctx, "failed to update job", slog.Any("error", err))
+	}
+	logger.InfoContext(ctx, "job finished",
+		slog.String("status", string(job.Status)),
+		slog.Int("succeeded", result.Succeeded),
+		slog.Int("failed", result.Failed),
+	)
+}
+
+// jobOutput packages the outputs of a finished job
+func jobOutput(outputs []batchOutput, result models.BatchResult) (*jobs.Output, error) {
+	if len(outputs) == 1 {
+		return &jobs.Output{
+			ContentType: "image/webp",
+			Filename:    result.Items[0].Output,
+			Data:        outputs[0].data,
+		}, nil
+	}
+
+	var buf bytes.Buffer
+	if err := writeBatchZip(&buf, outputs, result); err != nil {
+		return nil, fmt.Errorf("failed to build archive: %w", err)
+	}
+	return &jobs.Output{
+		ContentType: "application/zip",
+		Filename:    "images.zip",
+		Data:        buf.Bytes(),
+	}, nil
+}
+
+// jobsEnabled reports whether a job store is configured, answering 404 if not
+func (api *ImageAPI) jobsEnabled(w http.ResponseWriter) bool {
+	if api.jobs == nil {
+		http.Error(w, "Jobs are not enabled", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// writeJobError maps job store errors onto HTTP responses
+func writeJobError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		http.Error(w, "Job not found", http.StatusNotFound)
+	case errors.Is(err, jobs.ErrNoOutput):
+		http.Error(w, "Job has no output", http.StatusNotFound)
+	default:
+		http.Error(w, fmt.Sprintf("Failed to load job: %v", err), http.StatusInternalServerError)
+	}
+}
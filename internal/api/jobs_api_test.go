@@ -0,0 +1,257 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/jobs"
+)
+
+// newJobsTestAPI returns a batch test API with an in-memory job store
+func newJobsTestAPI() *ImageAPI {
+	api := newBatchTestAPI(nil)
+	WithJobStore(jobs.NewMemoryStore(time.Hour))(api)
+	return api
+}
+
+// createJob posts body to CreateJob and returns the accepted job
+func createJob(t *testing.T, api *ImageAPI, body string) *jobs.Job {
+	t.Helper()
+
+	req := httptest.NewRequest("POST", "/jobs", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	api.CreateJob(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status Accepted, got %v: %s", w.Code, w.Body.String())
+	}
+
+	var job jobs.Job
+	if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+		t.Fatalf("Failed to decode job: %v", err)
+	}
+	if location := w.Header().Get("Location"); location != "/jobs/"+job.ID {
+		t.Errorf("Expected Location /jobs/%s, got %q", job.ID, location)
+	}
+	return &job
+}
+
+// waitForJob polls GetJob until the job has finished
+func waitForJob(t *testing.T, api *ImageAPI, id string) *jobs.Job {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		req := httptest.NewRequest("GET", "/jobs/"+id, nil)
+		req.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+
+		api.GetJob(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status OK, got %v: %s", w.Code, w.Body.String())
+		}
+		var job jobs.Job
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatalf("Failed to decode job: %v", err)
+		}
+		if job.Done() {
+			return &job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Job %s did not finish in time", id)
+	return nil
+}
+
+// getJobResult calls GetJobResult for id
+func getJobResult(api *ImageAPI, id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/jobs/"+id+"/result", nil)
+	req.SetPathValue("id", id)
+	w := httptest.NewRecorder()
+	api.GetJobResult(w, req)
+	return w
+}
+
+func TestJobLifecycle(t *testing.T) {
+	api := newJobsTestAPI()
+
+	job := createJob(t, api, `{"items": [
+		{"url": "http://example.com/a.jpg"},
+		{"url": "http://example.com/missing.png"}
+	]}`)
+	if job.Total != 2 {
+		t.Errorf("Expected total 2, got %d", job.Total)
+	}
+
+	job = waitForJob(t, api, job.ID)
+	if job.Status != jobs.StatusSucceeded {
+		t.Fatalf("Expected status %s, got %s (%s)", jobs.StatusSucceeded, job.Status, job.Error)
+	}
+	if job.Completed != 2 {
+		t.Errorf("Expected 2 completed items, got %d", job.Completed)
+	}
+	if job.Result == nil || job.Result.Succeeded != 1 || job.Result.Failed != 1 {
+		t.Fatalf("Expected 1 success and 1 failure, got %+v", job.Result)
+	}
+
+	w := getJobResult(api, job.ID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v: %s", w.Code, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/zip" {
+		t.Errorf("Expected Content-Type application/zip, got %s", contentType)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read ZIP: %v", err)
+	}
+	names := make([]string, 0, len(zr.File))
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	if strings.Join(names, ",") != "a.webp,manifest.json" {
+		t.Errorf("Expected a.webp and manifest.json in the archive, got %v", names)
+	}
+}
+
+func TestJobSingleImageResult(t *testing.T) {
+	api := newJobsTestAPI()
+
+	job := createJob(t, api, `{"items": [{"url": "http://example.com/photo.jpg"}]}`)
+	waitForJob(t, api, job.ID)
+
+	w := getJobResult(api, job.ID)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v: %s", w.Code, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "image/webp" {
+		t.Errorf("Expected Content-Type image/webp, got %s", contentType)
+	}
+	if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, "photo.webp") {
+		t.Errorf("Expected photo.webp in Content-Disposition, got %s", disposition)
+	}
+}
+
+func TestShutdownFinishesAcceptedJobs(t *testing.T) {
+	api := newJobsTestAPI()
+
+	job := createJob(t, api, `{"items": [{"url": "http://example.com/photo.jpg"}]}`)
+	if err := api.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected shutdown to succeed, got %v", err)
+	}
+	stored, err := api.jobs.Get(context.Background(), job.ID)
+	if err != nil {
+		t.Fatalf("Failed to get job: %v", err)
+	}
+	if stored.Status != jobs.StatusSucceeded {
+		t.Errorf("Expected the job to finish before shutdown returned, got %s", stored.Status)
+	}
+
+	req := httptest.NewRequest("POST", "/jobs", strings.NewReader(`{"items": [{"url": "http://example.com/photo.jpg"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	api.CreateJob(w, req)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status Service Unavailable after shutdown, got %v", w.Code)
+	}
+}
+
+func TestJobAllItemsFailed(t *testing.T) {
+	api := newJobsTestAPI()
+
+	job := createJob(t, api, `{"items": [{"url": "http://example.com/missing.jpg"}]}`)
+	job = waitForJob(t, api, job.ID)
+
+	if job.Status != jobs.StatusFailed {
+		t.Errorf("Expected status %s, got %s", jobs.StatusFailed, job.Status)
+	}
+	if job.Error == "" {
+		t.Error("Expected an error message on the failed job")
+	}
+
+	if w := getJobResult(api, job.ID); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status NotFound for a failed job's result, got %v", w.Code)
+	}
+}
+
+func TestJobResultNotReady(t *testing.T) {
+	api := newJobsTestAPI()
+
+	job := &jobs.Job{Total: 1}
+	if err := api.jobs.Create(t.Context(), job); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if w := getJobResult(api, job.ID); w.Code != http.StatusConflict {
+		t.Errorf("Expected status Conflict, got %v", w.Code)
+	}
+}
+
+func TestJobErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		api          *ImageAPI
+		call         func(api *ImageAPI, w http.ResponseWriter)
+		expectedCode int
+	}{
+		{
+			name: "unknown job",
+			api:  newJobsTestAPI(),
+			call: func(api *ImageAPI, w http.ResponseWriter) {
+				req := httptest.NewRequest("GET", "/jobs/unknown", nil)
+				req.SetPathValue("id", "unknown")
+				api.GetJob(w, req)
+			},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name: "empty batch",
+			api:  newJobsTestAPI(),
+			call: func(api *ImageAPI, w http.ResponseWriter) {
+				req := httptest.NewRequest("POST", "/jobs", strings.NewReader(`{"items": []}`))
+				req.Header.Set("Content-Type", "application/json")
+				api.CreateJob(w, req)
+			},
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name: "wrong method",
+			api:  newJobsTestAPI(),
+			call: func(api *ImageAPI, w http.ResponseWriter) {
+				api.CreateJob(w, httptest.NewRequest("GET", "/jobs", nil))
+			},
+			expectedCode: http.StatusMethodNotAllowed,
+		},
+		{
+			name: "jobs disabled",
+			api:  newBatchTestAPI(nil),
+			call: func(api *ImageAPI, w http.ResponseWriter) {
+				req := httptest.NewRequest("POST", "/jobs", strings.NewReader(`{"items": [{"url": "http://example.com/a.jpg"}]}`))
+				req.Header.Set("Content-Type", "application/json")
+				api.CreateJob(w, req)
+			},
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.call(tt.api, w)
+			if w.Code != tt.expectedCode {
+				t.Errorf("Expected status %v, got %v: %s", tt.expectedCode, w.Code, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"context"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/metrics"
+)
+
+// workerLimiter bounds how many images are decoded and encoded at once.
+// Requests beyond the limit wait in line until a slot frees up or their
+// context is cancelled.
+type workerLimiter struct {
+	slots   chan struct{}
+	metrics *metrics.Metrics
+}
+
+// newWorkerLimiter creates a limiter allowing n concurrent jobs. A
+// non-positive n disables the limit.
+func newWorkerLimiter(n int, m *metrics.Metrics) *workerLimiter {
+	l := &workerLimiter{metrics: m}
+	if n > 0 {
+		l.slots = make(chan struct{}, n)
+	}
+	return l
+}
+
+// acquire blocks until a processing slot is available
+func (l *workerLimiter) acquire(ctx context.Context) error {
+	if l.slots != nil {
+		l.metrics.AddQueued(1)
+		select {
+		case l.slots <- struct{}{}:
+			l.metrics.AddQueued(-1)
+		case <-ctx.Done():
+			l.metrics.AddQueued(-1)
+			return ctx.Err()
+		}
+	}
+	l.metrics.AddInFlight(1)
+	return nil
+}
+
+// release frees a slot taken by acquire
+func (l *workerLimiter) release() {
+	l.metrics.AddInFlight(-1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
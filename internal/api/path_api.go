@@ -0,0 +1,176 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+)
+
+// endpointPath labels metrics of path-based image URLs
+const endpointPath = "img"
+
+// noOptions is the options segment of a path URL using only defaults
+const noOptions = "_"
+
+// errInvalidPathOptions is returned for a malformed options segment
+var errInvalidPathOptions = errors.New("invalid options")
+
+// defaultProcessOptions returns the options used when a request sets none
+func defaultProcessOptions() processor.ProcessOptions {
+	return processor.ProcessOptions{
+		MaxWidth:      1920, // Default max width
+		MaxHeight:     1080, // Default max height
+		Quality:       85,   // Default quality
+		PreserveRatio: true, // Default preserve ratio
+		Fit:           processor.FitContain,
+	}
+}
+
+// ProcessPath handles CDN-friendly URLs of the form
+// /img/{options}/{source}, where options is a comma-separated list such as
+// w:800,h:600,q:80,fit:cover and source is the base64url-encoded image URL.
+// Unsigned requests whose options are not in canonical form are redirected
+// to it, so that caches in front of the service store each result once.
+func (api *ImageAPI) ProcessPath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !api.verifySignature(w, r) {
+		return
+	}
+
+	options, err := parsePathOptions(r.PathValue("options"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	source := r.PathValue("source")
+	url, err := decodePathSource(source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := api.imageHandler.ValidateURL(url); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid source URL: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// A signature covers the path as issued, so signed URLs are served as is
+	if canonical := canonicalPathOptions(options); canonical != r.PathValue("options") && api.signatures == nil {
+		target := "/img/" + canonical + "/" + source
+		if r.URL.RawQuery != "" {
+			target += "?" + r.URL.RawQuery
+		}
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+		return
+	}
+
+	// API keys have no say in the options of a path URL, so that it means
+	// the same to every key, but their size limits still apply
+	options = limitOptions(r.Context(), options)
+	start := time.Now()
+	logOptions(r, options)
+
+	// Fetch and process the image. The ETag is known before processing, so
+	// revalidation skips encoding.
+	result, ok := api.processURL(w, r, endpointPath, url, options, true, start)
+	if !ok {
+		return
+	}
+	api.writeImage(w, r, result.etag, result.data)
+}
+
+// parsePathOptions parses the options segment of a path URL. Unlike query
+// parameters, invalid options are rejected rather than ignored, so that
+// typos do not silently produce cacheable defaults.
+func parsePathOptions(segment string) (processor.ProcessOptions, error) {
+	options := defaultProcessOptions()
+	if segment == noOptions {
+		return options, nil
+	}
+
+	seen := map[string]bool{}
+	for _, option := range strings.Split(segment, ",") {
+		name, value, ok := strings.Cut(option, ":")
+		if !ok || value == "" {
+			return options, fmt.Errorf("%w: %q is not name:value", errInvalidPathOptions, option)
+		}
+		switch name {
+		case "width":
+			name = "w"
+		case "height":
+			name = "h"
+		case "quality":
+			name = "q"
+		}
+		if seen[name] {
+			return options, fmt.Errorf("%w: %s is set twice", errInvalidPathOptions, name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "w", "h", "q":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 || (name == "q" && n > 100) {
+				return options, fmt.Errorf("%w: invalid %s %q", errInvalidPathOptions, name, value)
+			}
+			switch name {
+			case "w":
+				options.MaxWidth = n
+			case "h":
+				options.MaxHeight = n
+			case "q":
+				options.Quality = n
+			}
+		case "fit":
+			if !processor.ValidFit(value) {
+				return options, fmt.Errorf("%w: fit must be %s, %s or %s", errInvalidPathOptions,
+					processor.FitContain, processor.FitCover, processor.FitFill)
+			}
+			options.Fit = value
+		default:
+			return options, fmt.Errorf("%w: unknown option %q", errInvalidPathOptions, name)
+		}
+	}
+	return options, nil
+}
+
+// canonicalPathOptions returns the canonical options segment for options:
+// short names in alphabetical order, leaving out defaults
+func canonicalPathOptions(options processor.ProcessOptions) string {
+	defaults := defaultProcessOptions()
+	var parts []string
+	if options.Fit != "" && options.Fit != defaults.Fit {
+		parts = append(parts, "fit:"+options.Fit)
+	}
+	if options.MaxHeight != defaults.MaxHeight {
+		parts = append(parts, "h:"+strconv.Itoa(options.MaxHeight))
+	}
+	if options.Quality != defaults.Quality {
+		parts = append(parts, "q:"+strconv.Itoa(options.Quality))
+	}
+	if options.MaxWidth != defaults.MaxWidth {
+		parts = append(parts, "w:"+strconv.Itoa(options.MaxWidth))
+	}
+	if len(parts) == 0 {
+		return noOptions
+	}
+	return strings.Join(parts, ",")
+}
+
+// decodePathSource decodes a base64url-encoded source URL, with or without
+// padding
+func decodePathSource(source string) (string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(source, "="))
+	if err != nil || len(decoded) == 0 {
+		return "", errors.New("source must be a base64url-encoded URL")
+	}
+	return string(decoded), nil
+}
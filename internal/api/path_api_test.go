@@ -0,0 +1,223 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/handler"
+	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+)
+
+// newPathTestAPI returns a test API and a mux routing /img URLs to it
+func newPathTestAPI() (*ImageAPI, *http.ServeMux) {
+	api := newBatchTestAPI(nil)
+	WithHTTPCaching("public, max-age=31536000", false)(api)
+	api.imageHandler.(*MockImageHandler).ValidateURLFunc = func(url string) error {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			return handler.ErrInvalidURL
+		}
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/img/{options}/{source}", api.ProcessPath)
+	return api, mux
+}
+
+func encodeSource(url string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(url))
+}
+
+func TestProcessPath(t *testing.T) {
+	_, mux := newPathTestAPI()
+	source := encodeSource("http://example.com/a.jpg")
+
+	tests := []struct {
+		name             string
+		method           string
+		path             string
+		expectedStatus   int
+		expectedBody     string
+		expectedLocation string
+	}{
+		{
+			name:           "canonical options",
+			path:           "/img/fit:cover,h:600,q:80,w:800/" + source,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "webp q8",
+		},
+		{
+			name:           "defaults",
+			path:           "/img/_/" + source,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "webp q8",
+		},
+		{
+			name:           "padded source",
+			path:           "/img/q:50/" + base64.URLEncoding.EncodeToString([]byte("http://example.com/ab.jpg")),
+			expectedStatus: http.StatusOK,
+			expectedBody:   "webp q5",
+		},
+		{
+			name:             "reordered options",
+			path:             "/img/w:800,q:80,fit:cover,h:600/" + source,
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/img/fit:cover,h:600,q:80,w:800/" + source,
+		},
+		{
+			name:             "long names and defaults",
+			path:             "/img/width:800,quality:85,fit:contain/" + source + "?v=2",
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/img/w:800/" + source + "?v=2",
+		},
+		{
+			name:             "only defaults",
+			path:             "/img/q:85/" + source,
+			expectedStatus:   http.StatusMovedPermanently,
+			expectedLocation: "/img/_/" + source,
+		},
+		{
+			name:           "unknown option",
+			path:           "/img/w:800,blur:5/" + source,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid fit",
+			path:           "/img/fit:stretch/" + source,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid source encoding",
+			path:           "/img/w:800/not*base64",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "source is not a URL",
+			path:           "/img/w:800/" + encodeSource("file:///etc/passwd"),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "fetch failure",
+			path:           "/img/w:800/" + encodeSource("http://example.com/missing.jpg"),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "method not allowed",
+			method:         "POST",
+			path:           "/img/w:800/" + source,
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(method, tt.path, nil))
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedBody != "" && w.Body.String() != tt.expectedBody {
+				t.Errorf("Expected body %q, got %q", tt.expectedBody, w.Body.String())
+			}
+			if location := w.Header().Get("Location"); location != tt.expectedLocation {
+				t.Errorf("Expected Location %q, got %q", tt.expectedLocation, location)
+			}
+			if tt.expectedStatus == http.StatusOK {
+				if contentType := w.Header().Get("Content-Type"); contentType != "image/webp" {
+					t.Errorf("Expected Content-Type image/webp, got %s", contentType)
+				}
+				if cacheControl := w.Header().Get("Cache-Control"); cacheControl != "public, max-age=31536000" {
+					t.Errorf("Expected the configured Cache-Control, got %s", cacheControl)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessPathNotModified(t *testing.T) {
+	_, mux := newPathTestAPI()
+	path := "/img/w:800/" + encodeSource("http://example.com/a.jpg")
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("Expected an ETag")
+	}
+
+	req := httptest.NewRequest("GET", path, nil)
+	req.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304, got %v", w.Code)
+	}
+}
+
+func TestParsePathOptions(t *testing.T) {
+	tests := []struct {
+		segment  string
+		expected processor.ProcessOptions
+		wantErr  bool
+	}{
+		{segment: "_", expected: defaultProcessOptions()},
+		{segment: "w:800,h:600,q:80,fit:cover", expected: processor.ProcessOptions{MaxWidth: 800, MaxHeight: 600, Quality: 80, PreserveRatio: true, Fit: processor.FitCover}},
+		{segment: "width:640,height:480,quality:70", expected: processor.ProcessOptions{MaxWidth: 640, MaxHeight: 480, Quality: 70, PreserveRatio: true, Fit: processor.FitContain}},
+		{segment: "fit:fill", expected: processor.ProcessOptions{MaxWidth: 1920, MaxHeight: 1080, Quality: 85, PreserveRatio: true, Fit: processor.FitFill}},
+		{segment: "", wantErr: true},
+		{segment: "w", wantErr: true},
+		{segment: "w:", wantErr: true},
+		{segment: "w:0", wantErr: true},
+		{segment: "w:-5", wantErr: true},
+		{segment: "q:101", wantErr: true},
+		{segment: "w:800,width:600", wantErr: true},
+		{segment: "w:800,", wantErr: true},
+		{segment: "fit:", wantErr: true},
+		{segment: "crop:1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.segment, func(t *testing.T) {
+			options, err := parsePathOptions(tt.segment)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePathOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && options != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, options)
+			}
+		})
+	}
+}
+
+func TestCanonicalPathOptions(t *testing.T) {
+	tests := []struct {
+		segment  string
+		expected string
+	}{
+		{"_", "_"},
+		{"q:85,fit:contain", "_"},
+		{"w:800,h:600", "h:600,w:800"},
+		{"fit:cover,w:800,q:80,h:600", "fit:cover,h:600,q:80,w:800"},
+		{"width:800,height:1080", "w:800"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.segment, func(t *testing.T) {
+			options, err := parsePathOptions(tt.segment)
+			if err != nil {
+				t.Fatalf("parsePathOptions() error = %v", err)
+			}
+			if canonical := canonicalPathOptions(options); canonical != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, canonical)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/handler"
+	"github.com/Mark-Life/smart-webp-resize/internal/processor"
+	"github.com/Mark-Life/smart-webp-resize/internal/ratelimit"
+)
+
+// ProbeFromURL inspects an image specified by URL without processing it
+func (api *ImageAPI) ProbeFromURL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !api.verifySignature(w, r) {
+		return
+	}
+
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		http.Error(w, "URL parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+	imageData, err := api.imageHandler.GetImageFromURL(ctx, url)
+	if err != nil {
+		api.logger.WarnContext(ctx, "failed to fetch image", slog.String("url", handler.DescribeURL(url)), slog.Any("error", err))
+		http.Error(w, fmt.Sprintf("Failed to fetch image: %v", err), http.StatusBadRequest)
+		return
+	}
+	ratelimit.Charge(ctx, int64(len(imageData)))
+
+	api.writeProbe(w, r, imageData)
+}
+
+// ProbeFromUpload inspects an uploaded image without processing it
+func (api *ImageAPI) ProbeFromUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	imageData, err := api.imageHandler.GetImageFromUpload(r, "image")
+	if err != nil {
+		api.logger.WarnContext(r.Context(), "failed to read upload", slog.Any("error", err))
+		http.Error(w, fmt.Sprintf("Failed to upload image: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	api.writeProbe(w, r, imageData)
+}
+
+// writeProbe probes imageData and writes the result as JSON
+func (api *ImageAPI) writeProbe(w http.ResponseWriter, r *http.Request, imageData []byte) {
+	options := getProcessOptionsFromRequest(r)
+
+	result, err := processor.Probe(imageData, &options)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to probe image: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode probe result: %v", err), http.StatusInternalServerError)
+	}
+}
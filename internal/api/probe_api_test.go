@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/handler"
+	"github.com/Mark-Life/smart-webp-resize/internal/ratelimit"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+func TestProbeFromURL(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 300, 150)))
+	pngData := buf.Bytes()
+
+	mockHandler := &MockImageHandler{
+		GetURLFunc: func(url string) ([]byte, error) {
+			if url == "http://example.com/image.png" {
+				return pngData, nil
+			}
+			return nil, handler.ErrHTTPRequestFailed
+		},
+	}
+	mockProcessor := &MockImageProcessor{}
+	api := NewImageAPI(mockHandler, mockProcessor)
+
+	t.Run("probe with options", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/probe/url?url=http://example.com/image.png&max_width=100", nil)
+		w := httptest.NewRecorder()
+
+		api.ProbeFromURL(w, req)
+
+		resp := w.Result()
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status OK, got %v", resp.Status)
+		}
+
+		var result models.ProbeResult
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode probe result: %v", err)
+		}
+
+		if result.Format != "png" || result.Width != 300 || result.Height != 150 {
+			t.Errorf("Unexpected probe result: %+v", result)
+		}
+		if result.OutputWidth != 100 || result.OutputHeight != 50 {
+			t.Errorf("Expected output 100x50, got %dx%d", result.OutputWidth, result.OutputHeight)
+		}
+		if !result.HasAlpha {
+			t.Error("Expected NRGBA PNG to report alpha")
+		}
+	})
+
+	t.Run("fetch failure", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/probe/url?url=http://example.com/missing.png", nil)
+		w := httptest.NewRecorder()
+
+		api.ProbeFromURL(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status Bad Request, got %v", w.Code)
+		}
+	})
+
+	t.Run("invalid method", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", "/probe/url?url=http://example.com/image.png", nil)
+		w := httptest.NewRecorder()
+
+		api.ProbeFromURL(w, req)
+
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status Method Not Allowed, got %v", w.Code)
+		}
+	})
+
+	t.Run("fetched bytes are rate limited", func(t *testing.T) {
+		limited := ratelimit.New(ratelimit.Config{BytesPerSecond: 1}).Limit(http.HandlerFunc(api.ProbeFromURL))
+		serve := func() int {
+			req := httptest.NewRequest("GET", "/probe/url?url=http://example.com/image.png", nil)
+			w := httptest.NewRecorder()
+			limited.ServeHTTP(w, req)
+			return w.Code
+		}
+
+		if code := serve(); code != http.StatusOK {
+			t.Fatalf("Expected the first probe to be allowed, got %d", code)
+		}
+		if code := serve(); code != http.StatusTooManyRequests {
+			t.Errorf("Expected status 429 after fetching an image, got %d", code)
+		}
+	})
+}
+
+func TestProbeFromUploadInvalidImage(t *testing.T) {
+	mockHandler := &MockImageHandler{
+		GetUploadFunc: func(r *http.Request, fieldName string) ([]byte, error) {
+			return []byte("not an image at all"), nil
+		},
+	}
+	api := NewImageAPI(mockHandler, &MockImageProcessor{})
+
+	req := httptest.NewRequest("POST", "/probe/upload", nil).WithContext(context.Background())
+	w := httptest.NewRecorder()
+
+	api.ProbeFromUpload(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request, got %v", w.Code)
+	}
+}
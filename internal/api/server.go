@@ -46,4 +46,4 @@ func (s *Server) handleResize(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusNotImplemented)
 	w.Write([]byte(`{"error":"Not implemented yet"}`))
-} 
\ No newline at end of file
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+	"github.com/Mark-Life/smart-webp-resize/pkg/signing"
+)
+
+// WithURLSigning requires requests to the URL processing endpoints to be
+// signed with one of the verifier's keys
+func WithURLSigning(verifier *signing.Verifier) Option {
+	return func(api *ImageAPI) {
+		api.signatures = verifier
+	}
+}
+
+// verifySignature rejects the request with 403 Forbidden unless signing is
+// disabled or its URL carries a valid signature. It runs before any other
+// work so that unsigned requests cost nothing.
+func (api *ImageAPI) verifySignature(w http.ResponseWriter, r *http.Request) bool {
+	if api.signatures == nil {
+		return true
+	}
+	if err := api.signatures.Verify(r.URL.EscapedPath(), r.URL.Query(), time.Now()); err != nil {
+		logging.AddAccessAttrs(r.Context(), slog.String("signature_error", err.Error()))
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/handler"
+	"github.com/Mark-Life/smart-webp-resize/internal/jobs"
+	"github.com/Mark-Life/smart-webp-resize/pkg/signing"
+)
+
+func TestURLSigning(t *testing.T) {
+	api, _ := newPathTestAPI()
+	key := signing.Key{ID: "k1", Secret: []byte("secret")}
+	WithURLSigning(signing.NewVerifier([]signing.Key{key}))(api)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/process/url", api.ProcessFromURL)
+	mux.HandleFunc("/img/{options}/{source}", api.ProcessPath)
+	mux.HandleFunc("/probe/url", api.ProbeFromURL)
+
+	fetches := 0
+	mockHandler := api.imageHandler.(*MockImageHandler)
+	getURL := mockHandler.GetURLFunc
+	mockHandler.GetURLFunc = func(url string) ([]byte, error) {
+		fetches++
+		return getURL(url)
+	}
+
+	sign := func(rawURL string, expires time.Time) string {
+		t.Helper()
+		signed, err := signing.Sign(rawURL, key, expires)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return signed
+	}
+	source := encodeSource("http://example.com/a.jpg")
+	signedURL := sign("/process/url?url=http://example.com/a.jpg&max_width=800", time.Time{})
+
+	tests := []struct {
+		name           string
+		target         string
+		expectedStatus int
+	}{
+		{"signed", signedURL, http.StatusOK},
+		{"signed with expiry", sign("/process/url?url=http://example.com/a.jpg", time.Now().Add(time.Minute)), http.StatusOK},
+		{"signed path", sign("/img/w:800/"+source, time.Time{}), http.StatusOK},
+		{"signed non-canonical path", sign("/img/w:800,h:600/"+source, time.Time{}), http.StatusOK},
+		{"unsigned", "/process/url?url=http://example.com/a.jpg", http.StatusForbidden},
+		{"unsigned path", "/img/w:800/" + source, http.StatusForbidden},
+		{"unsigned probe", "/probe/url?url=http://example.com/a.jpg", http.StatusForbidden},
+		{"tampered", strings.Replace(signedURL, "max_width=800", "max_width=4000", 1), http.StatusForbidden},
+		{"expired", sign("/process/url?url=http://example.com/a.jpg", time.Now().Add(-time.Minute)), http.StatusForbidden},
+		{"unknown key", strings.Replace(signedURL, "kid=k1", "kid=k2", 1), http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetches = 0
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("GET", tt.target, nil))
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus == http.StatusForbidden && fetches != 0 {
+				t.Errorf("Expected no fetch for a rejected request, got %d", fetches)
+			}
+		})
+	}
+
+	// Callback parameters are only read from the signed query
+	req := httptest.NewRequest("POST", signedURL, strings.NewReader("callback_url=http://example.com/hook&callback_output=link"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected a callback_url in the body to be ignored, got %v: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestURLSigningBatches(t *testing.T) {
+	api := newBatchTestAPI(nil)
+	WithJobStore(jobs.NewMemoryStore(time.Hour))(api)
+	key := signing.Key{ID: "k1", Secret: []byte("secret")}
+	WithURLSigning(signing.NewVerifier([]signing.Key{key}))(api)
+
+	var fetches atomic.Int32 // Jobs fetch in the background
+	mockHandler := api.imageHandler.(*MockImageHandler)
+	getURL := mockHandler.GetURLFunc
+	mockHandler.GetURLFunc = func(url string) ([]byte, error) {
+		fetches.Add(1)
+		return getURL(url)
+	}
+
+	body := `{"items": [{"url": "http://example.com/a.jpg"}]}`
+	signBody := func(target, body string) string {
+		t.Helper()
+		signed, err := signing.SignBody(target, []byte(body), key, time.Time{})
+		if err != nil {
+			t.Fatalf("SignBody() error = %v", err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		target         string
+		body           string
+		expectedStatus int
+	}{
+		{"unsigned batch", api.ProcessBatch, "/process/batch", body, http.StatusForbidden},
+		{"unsigned stream", api.ProcessStream, "/process/stream", body, http.StatusForbidden},
+		{"unsigned job", api.CreateJob, "/jobs", body, http.StatusForbidden},
+		{"signed batch", api.ProcessBatch, signBody("/process/batch", body), body, http.StatusOK},
+		{"signed stream", api.ProcessStream, signBody("/process/stream", body), body, http.StatusOK},
+		{"tampered batch", api.ProcessBatch, signBody("/process/batch", body), strings.Replace(body, "a.jpg", "b.jpg", 1), http.StatusForbidden},
+		{"signed for another endpoint", api.ProcessBatch, strings.Replace(signBody("/jobs", body), "/jobs", "/process/batch", 1), body, http.StatusForbidden},
+		{"URL signature only", api.ProcessBatch, mustSign(t, "/process/batch", key), body, http.StatusForbidden},
+		{"signed job", api.CreateJob, signBody("/jobs", body), body, http.StatusAccepted}, // Last, as it fetches in the background
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetches.Store(0)
+			req := httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			tt.handler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %v, got %v: %s", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if n := fetches.Load(); tt.expectedStatus == http.StatusForbidden && n != 0 {
+				t.Errorf("Expected no fetch for a rejected request, got %d", n)
+			}
+		})
+	}
+
+	// The request field of a multipart batch is signed like a JSON body
+	var signedBody bytes.Buffer
+	mw := multipart.NewWriter(&signedBody)
+	mw.WriteField("request", body)
+	mw.Close()
+	req := httptest.NewRequest("POST", signBody("/process/batch", body), &signedBody)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	api.ProcessBatch(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected a signed request field to be accepted, got %v: %s", w.Code, w.Body.String())
+	}
+
+	// Uploads need no signature
+	var uploadBody bytes.Buffer
+	mw = multipart.NewWriter(&uploadBody)
+	mw.Close()
+	api.imageHandler.(*MockImageHandler).GetUploadsFunc = func(r *http.Request, fieldName string) ([]handler.UploadedImage, error) {
+		return []handler.UploadedImage{{Filename: "photo.jpg", Data: []byte("jpeg data")}}, nil
+	}
+	req = httptest.NewRequest("POST", "/process/batch", &uploadBody)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	w = httptest.NewRecorder()
+	api.ProcessBatch(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected uploads to be accepted, got %v: %s", w.Code, w.Body.String())
+	}
+}
+
+// mustSign signs rawURL with key without an expiry
+func mustSign(t *testing.T, rawURL string, key signing.Key) string {
+	t.Helper()
+	signed, err := signing.Sign(rawURL, key, time.Time{})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	return signed
+}
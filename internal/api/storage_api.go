@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+	"github.com/Mark-Life/smart-webp-resize/internal/storage"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// outputStore is the output parameter value that stores the result and
+// returns its URL
+const outputStore = "store"
+
+// WithStorage sets where outputs are stored for requests with output=store
+func WithStorage(backend storage.Backend) Option {
+	return func(api *ImageAPI) {
+		api.storage = backend
+	}
+}
+
+// storeRequested reports whether a request asks for its output to be
+// stored. It answers 400 and returns false for ok if no backend is
+// configured to store it in.
+func (api *ImageAPI) storeRequested(w http.ResponseWriter, r *http.Request) (store, ok bool) {
+	if r.URL.Query().Get("output") != outputStore {
+		return false, true
+	}
+	if api.storage == nil {
+		http.Error(w, "Output storage is not configured", http.StatusBadRequest)
+		return true, false
+	}
+	return true, true
+}
+
+// writeStored stores a processed image under a new key and responds with
+// its URL and metadata
+func (api *ImageAPI) writeStored(w http.ResponseWriter, r *http.Request, filename string, data []byte, metadata *models.ImageMetadata) {
+	ctx := r.Context()
+	key := storage.NewKey(filename)
+
+	url, err := api.storage.Put(ctx, key, data, "image/webp")
+	if err != nil {
+		api.logger.ErrorContext(ctx, "failed to store image", slog.String("key", key), slog.Any("error", err))
+		http.Error(w, fmt.Sprintf("Failed to store image: %v", err), http.StatusBadGateway)
+		return
+	}
+	logging.AddAccessAttrs(ctx, slog.String("storage_key", key))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", url)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.StoredImage{
+		URL:      url,
+		Key:      key,
+		Metadata: metadata,
+	})
+}
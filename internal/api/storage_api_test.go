@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/storage"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+func TestProcessFromURLStoreOutput(t *testing.T) {
+	backend, err := storage.NewLocal(t.TempDir(), "https://images.example.com/files")
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+	api := newBatchTestAPI(nil)
+	WithStorage(backend)(api)
+
+	req := httptest.NewRequest("GET", "/process/url?url=http://example.com/photos/cat.jpg&output=store", nil)
+	w := httptest.NewRecorder()
+
+	api.ProcessFromURL(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v: %s", w.Code, w.Body.String())
+	}
+
+	var stored models.StoredImage
+	if err := json.Unmarshal(w.Body.Bytes(), &stored); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !strings.HasSuffix(stored.Key, "/cat.webp") {
+		t.Errorf("Expected a key ending in /cat.webp, got %s", stored.Key)
+	}
+	if stored.URL != "https://images.example.com/files/"+stored.Key {
+		t.Errorf("Expected the URL of the stored key, got %s", stored.URL)
+	}
+	if location := w.Header().Get("Location"); location != stored.URL {
+		t.Errorf("Expected Location %s, got %s", stored.URL, location)
+	}
+	if stored.Metadata == nil || stored.Metadata.NewFormat != "webp" {
+		t.Errorf("Expected metadata in the response, got %+v", stored.Metadata)
+	}
+
+	data, err := backend.Get(context.Background(), stored.Key)
+	if err != nil || string(data) != "webp q8" {
+		t.Errorf("Expected the WebP to be stored, got %q, %v", data, err)
+	}
+}
+
+func TestStoreOutputWithoutBackend(t *testing.T) {
+	processed := false
+	api := newBatchTestAPI(nil)
+	api.processor.(*MockImageProcessor).ProcessBytesFunc = nil
+	api.imageHandler.(*MockImageHandler).GetURLFunc = func(url string) ([]byte, error) {
+		processed = true
+		return nil, nil
+	}
+
+	req := httptest.NewRequest("GET", "/process/url?url=http://example.com/cat.jpg&output=store", nil)
+	w := httptest.NewRecorder()
+
+	api.ProcessFromURL(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest, got %v", w.Code)
+	}
+	if processed {
+		t.Error("Expected the request to be rejected before fetching the image")
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/jobs"
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// endpointStream labels metrics for images processed through /process/stream
+const endpointStream = "stream"
+
+// Server-Sent Event types sent by ProcessStream
+const (
+	eventStarted   = "started"
+	eventCompleted = "completed"
+	eventFailed    = "failed"
+	eventDone      = "done"
+)
+
+// ProcessStream accepts the same input as ProcessBatch and streams progress
+// as Server-Sent Events: "started" when an image begins processing,
+// "completed" or "failed" as it finishes, and a final "done" summary. Outputs
+// of completed images are kept in the job store and linked from the event.
+func (api *ImageAPI) ProcessStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	batch, err := api.parseBatch(w, r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid stream request: %v", err), batchErrorStatus(err))
+		return
+	}
+
+	ctx := r.Context()
+	base := api.baseURL(r)
+	stream := newEventStream(w)
+	used := map[string]int{}
+	summary := models.StreamSummary{Total: len(batch)}
+
+	api.runBatch(ctx, endpointStream, batch, batchEvents{
+		started: func(index int, job batchJob) {
+			stream.send(eventStarted, models.BatchItemResult{
+				Index:  index,
+				Name:   job.name,
+				Source: job.source,
+				URL:    job.url,
+			})
+		},
+		finished: func(out batchOutput) {
+			event := models.StreamItemEvent{BatchItemResult: out.result}
+			if event.Error != "" {
+				summary.Failed++
+				stream.send(eventFailed, event)
+				return
+			}
+			summary.Succeeded++
+			event.Output = uniqueName(used, webpFilename(event.Name))
+			api.attachStreamOutput(ctx, base, &event, out.data)
+			stream.send(eventCompleted, event)
+		},
+	})
+
+	stream.send(eventDone, summary)
+	logging.AddAccessAttrs(ctx,
+		slog.Int("batch_items", summary.Total),
+		slog.Int("batch_failed", summary.Failed),
+	)
+}
+
+// attachStreamOutput keeps a completed image as a finished single-image job
+// and links to its result under base. Without a job store, or if storing
+// fails, the output is sent inline.
+func (api *ImageAPI) attachStreamOutput(ctx context.Context, base string, event *models.StreamItemEvent, data []byte) {
+	if api.jobs == nil {
+		event.Data = data
+		return
+	}
+
+	item := event.BatchItemResult
+	job := &jobs.Job{
+		Status:    jobs.StatusSucceeded,
+		Total:     1,
+		Completed: 1,
+		Result:    &models.BatchResult{Items: []models.BatchItemResult{item}, Succeeded: 1},
+	}
+	err := api.jobs.Create(ctx, job)
+	if err == nil {
+		err = api.jobs.PutOutput(ctx, job.ID, &jobs.Output{
+			ContentType: "image/webp",
+			Filename:    item.Output,
+			Data:        data,
+		})
+	}
+	if err != nil {
+		api.logger.WarnContext(ctx, "failed to store stream output", slog.Int("index", item.Index), slog.Any("error", err))
+		event.Data = data
+		return
+	}
+	event.DownloadURL = base + "/jobs/" + job.ID + "/result"
+}
+
+// eventStream writes Server-Sent Events, flushing after each one
+type eventStream struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	nextID int
+	err    error
+}
+
+// newEventStream sends the event stream headers. The server write timeout is
+// lifted, since a stream lasts as long as its slowest image.
+func newEventStream(w http.ResponseWriter) *eventStream {
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Stop nginx buffering the stream
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	return &eventStream{w: w, rc: rc}
+}
+
+// send writes one event with a JSON payload. Once a write fails, for example
+// because the client went away, later events are dropped.
+func (s *eventStream) send(event string, payload any) {
+	if s.err != nil {
+		return
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		s.err = err
+		return
+	}
+	if _, s.err = fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", s.nextID, event, data); s.err != nil {
+		return
+	}
+	s.nextID++
+	s.err = s.rc.Flush()
+}
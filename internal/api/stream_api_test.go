@@ -0,0 +1,154 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/jobs"
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// sseEvent is a parsed Server-Sent Event
+type sseEvent struct {
+	name string
+	data string
+}
+
+// parseEvents splits an event stream body into its events
+func parseEvents(t *testing.T, body string) []sseEvent {
+	t.Helper()
+
+	var (
+		events  []sseEvent
+		current sseEvent
+	)
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			events = append(events, current)
+			current = sseEvent{}
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	return events
+}
+
+func TestProcessStream(t *testing.T) {
+	api := newBatchTestAPI(nil)
+	WithJobStore(jobs.NewMemoryStore(time.Hour))(api)
+
+	body := `{"items": [
+		{"url": "http://example.com/a.jpg"},
+		{"url": "http://example.com/missing.png"},
+		{"url": "http://example.com/b.jpg"}
+	]}`
+	req := httptest.NewRequest("POST", "/process/stream", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	api.ProcessStream(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v: %s", w.Code, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected Content-Type text/event-stream, got %s", contentType)
+	}
+
+	events := parseEvents(t, w.Body.String())
+	counts := map[string]int{}
+	started := map[int]bool{}
+	for _, event := range events {
+		counts[event.name]++
+
+		var item models.StreamItemEvent
+		switch event.name {
+		case eventStarted:
+			json.Unmarshal([]byte(event.data), &item)
+			started[item.Index] = true
+		case eventCompleted:
+			json.Unmarshal([]byte(event.data), &item)
+			if !started[item.Index] {
+				t.Errorf("Item %d completed before it started", item.Index)
+			}
+			if item.Metadata == nil || item.Output == "" {
+				t.Errorf("Expected metadata and an output name, got %+v", item)
+			}
+			id, ok := strings.CutPrefix(item.DownloadURL, "http://example.com/jobs/")
+			if !ok {
+				t.Errorf("Expected an absolute download URL, got %s", item.DownloadURL)
+			}
+			if result := getJobResult(api, strings.TrimSuffix(id, "/result")); result.Code != http.StatusOK {
+				t.Errorf("Expected %s to be downloadable, got %v", item.DownloadURL, result.Code)
+			}
+		case eventFailed:
+			json.Unmarshal([]byte(event.data), &item)
+			if item.URL != "http://example.com/missing.png" || item.Error == "" {
+				t.Errorf("Expected the missing image to fail with an error, got %+v", item)
+			}
+		}
+	}
+
+	if counts[eventStarted] != 3 || counts[eventCompleted] != 2 || counts[eventFailed] != 1 {
+		t.Errorf("Expected 3 started, 2 completed and 1 failed events, got %v", counts)
+	}
+
+	last := events[len(events)-1]
+	if last.name != eventDone {
+		t.Fatalf("Expected the stream to end with %s, got %s", eventDone, last.name)
+	}
+	var summary models.StreamSummary
+	if err := json.Unmarshal([]byte(last.data), &summary); err != nil {
+		t.Fatalf("Failed to decode summary: %v", err)
+	}
+	if summary.Total != 3 || summary.Succeeded != 2 || summary.Failed != 1 {
+		t.Errorf("Expected 3 total, 2 succeeded and 1 failed, got %+v", summary)
+	}
+}
+
+func TestProcessStreamInlineWithoutJobStore(t *testing.T) {
+	api := newBatchTestAPI(nil)
+
+	req := httptest.NewRequest("POST", "/process/stream", strings.NewReader(`{"items": [{"url": "http://example.com/a.jpg"}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	api.ProcessStream(w, req)
+
+	for _, event := range parseEvents(t, w.Body.String()) {
+		if event.name != eventCompleted {
+			continue
+		}
+		var item models.StreamItemEvent
+		json.Unmarshal([]byte(event.data), &item)
+		if item.DownloadURL != "" || string(item.Data) != "webp q8" {
+			t.Errorf("Expected the output inline, got %+v", item)
+		}
+		return
+	}
+	t.Errorf("Expected a completed event, got %s", w.Body.String())
+}
+
+func TestProcessStreamInvalidRequest(t *testing.T) {
+	api := newBatchTestAPI(nil)
+
+	req := httptest.NewRequest("POST", "/process/stream", strings.NewReader(`{"items": []}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	api.ProcessStream(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status BadRequest, got %v", w.Code)
+	}
+}
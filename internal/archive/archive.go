@@ -0,0 +1,161 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// Common errors
+var (
+	ErrInvalidArchive  = errors.New("invalid ZIP archive")
+	ErrUnsafePath      = errors.New("unsafe path in archive")
+	ErrTooManyEntries  = errors.New("archive has too many entries")
+	ErrTooLarge        = errors.New("archive expands beyond the size limit")
+	ErrSuspiciousRatio = errors.New("archive entry has a suspicious compression ratio")
+)
+
+// Limits bounds the resources an archive may consume when extracted
+type Limits struct {
+	// MaxEntries is the maximum number of files in the archive
+	MaxEntries int
+	// MaxTotalSize is the maximum combined uncompressed size of all files
+	MaxTotalSize int64
+	// MaxCompressionRatio is the maximum uncompressed/compressed ratio of a
+	// single entry, to reject zip bombs early
+	MaxCompressionRatio float64
+}
+
+// DefaultLimits are used for any limit left at zero
+var DefaultLimits = Limits{
+	MaxEntries:          1000,
+	MaxTotalSize:        200 << 20,
+	MaxCompressionRatio: 100,
+}
+
+// ratioExemptSize is the size below which entries skip the ratio check;
+// tiny, highly repetitive files legitimately compress very well
+const ratioExemptSize = 1 << 20
+
+// Entry is a regular file extracted from an archive
+type Entry struct {
+	// Name is the cleaned, slash separated path of the file
+	Name     string
+	Data     []byte
+	Modified time.Time
+}
+
+// Read extracts all regular files from a ZIP archive held in memory.
+// Directories are skipped. Entries with absolute or escaping paths, symlinks,
+// or sizes beyond the limits cause the whole archive to be rejected. Sizes are
+// enforced on the bytes actually decompressed, not the sizes the archive
+// claims.
+func Read(data []byte, limits Limits) ([]Entry, error) {
+	limits = limits.withDefaults()
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArchive, err)
+	}
+
+	if len(zr.File) > limits.MaxEntries {
+		return nil, fmt.Errorf("%w: %d exceeds the limit of %d", ErrTooManyEntries, len(zr.File), limits.MaxEntries)
+	}
+
+	var (
+		entries []Entry
+		total   int64
+	)
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		name, err := CleanPath(f.Name)
+		if err != nil {
+			return nil, err
+		}
+		if f.Mode()&^0o777 != 0 {
+			return nil, fmt.Errorf("%w: %s is not a regular file", ErrUnsafePath, f.Name)
+		}
+
+		if f.UncompressedSize64 > ratioExemptSize && f.CompressedSize64 > 0 &&
+			float64(f.UncompressedSize64)/float64(f.CompressedSize64) > limits.MaxCompressionRatio {
+			return nil, fmt.Errorf("%w: %s", ErrSuspiciousRatio, f.Name)
+		}
+
+		content, err := readEntry(f, limits.MaxTotalSize-total)
+		if err != nil {
+			return nil, err
+		}
+		total += int64(len(content))
+
+		entries = append(entries, Entry{Name: name, Data: content, Modified: f.Modified})
+	}
+
+	return entries, nil
+}
+
+// readEntry decompresses f, failing if it produces more than remaining bytes
+func readEntry(f *zip.File, remaining int64) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, f.Name, err)
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(io.LimitReader(rc, remaining+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidArchive, f.Name, err)
+	}
+	if int64(len(content)) > remaining {
+		return nil, ErrTooLarge
+	}
+	return content, nil
+}
+
+// CleanPath normalizes an archive entry name and rejects names that are
+// absolute or would escape the extraction root
+func CleanPath(name string) (string, error) {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if slashed == "" || strings.HasPrefix(slashed, "/") || hasDriveLetter(slashed) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+
+	for _, part := range strings.Split(slashed, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+		}
+	}
+
+	cleaned := path.Clean(slashed)
+	if cleaned == "." {
+		return "", fmt.Errorf("%w: %q", ErrUnsafePath, name)
+	}
+	return cleaned, nil
+}
+
+// hasDriveLetter reports whether name starts with a Windows drive like C:
+func hasDriveLetter(name string) bool {
+	return len(name) >= 2 && name[1] == ':' &&
+		((name[0] >= 'a' && name[0] <= 'z') || (name[0] >= 'A' && name[0] <= 'Z'))
+}
+
+// withDefaults fills zero limits from DefaultLimits
+func (l Limits) withDefaults() Limits {
+	if l.MaxEntries <= 0 {
+		l.MaxEntries = DefaultLimits.MaxEntries
+	}
+	if l.MaxTotalSize <= 0 {
+		l.MaxTotalSize = DefaultLimits.MaxTotalSize
+	}
+	if l.MaxCompressionRatio <= 0 {
+		l.MaxCompressionRatio = DefaultLimits.MaxCompressionRatio
+	}
+	return l
+}
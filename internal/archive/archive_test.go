@@ -0,0 +1,107 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// buildZip creates an archive from name/content pairs
+func buildZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to create entry %s: %v", name, err)
+		}
+		fw.Write([]byte(content))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	data := buildZip(t, map[string]string{
+		"photos/a.jpg":     "jpeg",
+		"photos/raw/b.png": "png",
+		"readme.txt":       "hello",
+		"photos/":          "",
+	})
+
+	entries, err := Read(data, Limits{})
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	got := map[string]string{}
+	for _, e := range entries {
+		got[e.Name] = string(e.Data)
+	}
+	if len(got) != 3 {
+		t.Errorf("Expected 3 files (directories skipped), got %v", got)
+	}
+	if got["photos/raw/b.png"] != "png" {
+		t.Errorf("Expected nested file to be preserved, got %v", got)
+	}
+}
+
+func TestReadRejectsUnsafeArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		limits  Limits
+		wantErr error
+	}{
+		{
+			name:    "parent traversal",
+			files:   map[string]string{"../../etc/passwd": "x"},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "absolute path",
+			files:   map[string]string{"/etc/passwd": "x"},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "windows traversal",
+			files:   map[string]string{"images\\..\\..\\evil.jpg": "x"},
+			wantErr: ErrUnsafePath,
+		},
+		{
+			name:    "too many entries",
+			files:   map[string]string{"a": "1", "b": "2", "c": "3"},
+			limits:  Limits{MaxEntries: 2},
+			wantErr: ErrTooManyEntries,
+		},
+		{
+			name:    "total size",
+			files:   map[string]string{"a": strings.Repeat("a", 600), "b": strings.Repeat("b", 600)},
+			limits:  Limits{MaxTotalSize: 1000},
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "compression bomb",
+			files:   map[string]string{"bomb.bin": strings.Repeat("0", 4<<20)},
+			wantErr: ErrSuspiciousRatio,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(buildZip(t, tt.files), tt.limits)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestReadInvalidArchive(t *testing.T) {
+	if _, err := Read([]byte("not a zip"), Limits{}); !errors.Is(err, ErrInvalidArchive) {
+		t.Errorf("Expected ErrInvalidArchive, got %v", err)
+	}
+}
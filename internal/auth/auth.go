@@ -0,0 +1,377 @@
+// Package auth authenticates API requests with API keys and enforces the
+// endpoints and daily quotas configured for each key.
+//
+// Clients send their key in an X-API-Key header, as a bearer token or in
+// the api_key query parameter. Usage is counted per key and UTC day in a
+// UsageStore, so that quotas survive restarts with a persistent store.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+)
+
+// Where clients send their key
+const (
+	HeaderAPIKey = "X-API-Key"
+	ParamAPIKey  = "api_key"
+)
+
+// Authentication errors
+var (
+	ErrMissingKey    = errors.New("API key required")
+	ErrInvalidKey    = errors.New("invalid API key")
+	ErrEndpoint      = errors.New("API key may not use this endpoint")
+	ErrQuotaExceeded = errors.New("daily quota exceeded")
+)
+
+// Key is an API key and what it may do
+type Key struct {
+	// ID names the key in logs and usage; it is not secret
+	ID string `json:"id"`
+
+	// Secret is the value clients send
+	Secret string `json:"key"`
+
+	// Endpoints the key may use, by metrics endpoint name such as
+	// "process_upload". Empty allows every endpoint.
+	Endpoints []string `json:"endpoints,omitempty"`
+
+	// MaxWidth and MaxHeight cap the output dimensions; zero means no cap
+	MaxWidth  int `json:"max_width,omitempty"`
+	MaxHeight int `json:"max_height,omitempty"`
+
+	// DailyRequests and DailyBytes limit usage per UTC day; zero means
+	// unlimited. Bytes are those uploaded plus those downloaded.
+	DailyRequests int64 `json:"daily_requests,omitempty"`
+	DailyBytes    int64 `json:"daily_bytes,omitempty"`
+
+	// Defaults replace the service defaults for options the client does
+	// not set
+	Defaults Defaults `json:"defaults"`
+}
+
+// Defaults are processing options used for a key when a request leaves
+// them unset. Unset fields keep the service defaults.
+type Defaults struct {
+	MaxWidth      *int    `json:"max_width,omitempty"`
+	MaxHeight     *int    `json:"max_height,omitempty"`
+	Quality       *int    `json:"quality,omitempty"`
+	PreserveRatio *bool   `json:"preserve_ratio,omitempty"`
+	Fit           *string `json:"fit,omitempty"`
+}
+
+// allows reports whether the key may use endpoint
+func (k *Key) allows(endpoint string) bool {
+	return len(k.Endpoints) == 0 || slices.Contains(k.Endpoints, endpoint)
+}
+
+// exceeded returns an error wrapping ErrQuotaExceeded if usage has used
+// up one of the key's quotas
+func (k *Key) exceeded(usage Usage) error {
+	if k.DailyRequests > 0 && usage.Requests >= k.DailyRequests {
+		return fmt.Errorf("%w: %d requests", ErrQuotaExceeded, k.DailyRequests)
+	}
+	if k.DailyBytes > 0 && usage.Bytes >= k.DailyBytes {
+		return fmt.Errorf("%w: %d bytes", ErrQuotaExceeded, k.DailyBytes)
+	}
+	return nil
+}
+
+// ParseKeys parses keys given as a JSON array, such as
+// [{"id": "frontend", "key": "...", "daily_requests": 1000}]. An empty
+// string yields no keys.
+func ParseKeys(s string) ([]Key, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var keys []Key
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&keys); err != nil {
+		return nil, fmt.Errorf("invalid API keys: %w", err)
+	}
+	for i, key := range keys {
+		if err := key.validate(); err != nil {
+			return nil, fmt.Errorf("invalid API key %d: %w", i, err)
+		}
+	}
+	return keys, nil
+}
+
+// validate checks that a key has a usable ID and secret and sane limits
+func (k *Key) validate() error {
+	if !validID(k.ID) {
+		return errors.New("id must be 1-64 letters, digits, '-', '_' or '.'")
+	}
+	if k.Secret == "" {
+		return errors.New("key is required")
+	}
+	if k.MaxWidth < 0 || k.MaxHeight < 0 || k.DailyRequests < 0 || k.DailyBytes < 0 {
+		return errors.New("limits may not be negative")
+	}
+	return nil
+}
+
+// validID reports whether id is safe to log and use as a store key
+func validID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Authenticator checks API keys and counts their usage. A nil
+// *Authenticator lets every request through.
+type Authenticator struct {
+	keys   map[[sha256.Size]byte]*Key
+	usage  UsageStore
+	logger *slog.Logger
+	now    func() time.Time
+}
+
+// Option configures optional Authenticator dependencies
+type Option func(*Authenticator)
+
+// WithUsageStore sets where usage is counted. The default keeps it in
+// memory, so quotas reset on restart.
+func WithUsageStore(store UsageStore) Option {
+	return func(a *Authenticator) {
+		a.usage = store
+	}
+}
+
+// WithLogger sets the logger used for usage store failures
+func WithLogger(logger *slog.Logger) Option {
+	return func(a *Authenticator) {
+		a.logger = logger
+	}
+}
+
+// New creates an Authenticator accepting keys. Key IDs and secrets must be
+// unique.
+func New(keys []Key, opts ...Option) (*Authenticator, error) {
+	a := &Authenticator{
+		keys:   map[[sha256.Size]byte]*Key{},
+		usage:  NewMemoryUsageStore(),
+		logger: logging.Discard(),
+		now:    time.Now,
+	}
+	ids := map[string]bool{}
+	for _, key := range keys {
+		if err := key.validate(); err != nil {
+			return nil, fmt.Errorf("invalid API key %q: %w", key.ID, err)
+		}
+		// Keys are looked up by hash so that lookups do not compare secrets
+		hash := sha256.Sum256([]byte(key.Secret))
+		if ids[key.ID] || a.keys[hash] != nil {
+			return nil, fmt.Errorf("API key %q is defined twice", key.ID)
+		}
+		ids[key.ID] = true
+		a.keys[hash] = &key
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a, nil
+}
+
+// keyContextKey is the context key of the authenticated Key
+type keyContextKey struct{}
+
+// KeyFromContext returns the key a request was authenticated with, or nil
+func KeyFromContext(ctx context.Context) *Key {
+	key, _ := ctx.Value(keyContextKey{}).(*Key)
+	return key
+}
+
+// authenticate returns the key sent with r
+func (a *Authenticator) authenticate(r *http.Request) (*Key, error) {
+	secret := r.Header.Get(HeaderAPIKey)
+	if secret == "" {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			secret = strings.TrimSpace(token)
+		}
+	}
+	if secret == "" {
+		secret = r.URL.Query().Get(ParamAPIKey)
+	}
+	if secret == "" {
+		return nil, ErrMissingKey
+	}
+	key := a.keys[sha256.Sum256([]byte(secret))]
+	if key == nil {
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// Require rejects requests to endpoint without a valid key that may use it
+// or whose quota is used up. Accepted requests carry their key in the
+// context, and count against its usage once served.
+func (a *Authenticator) Require(endpoint string, next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		key, err := a.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		logging.AddAccessAttrs(ctx, slog.String("api_key", key.ID))
+		if !key.allows(endpoint) {
+			http.Error(w, ErrEndpoint.Error(), http.StatusForbidden)
+			return
+		}
+
+		// The request is reserved before the quota is checked, so that of
+		// concurrent requests only those within the quota are let in. Bytes
+		// can only be counted once served.
+		now := a.now().UTC()
+		day := dayOf(now)
+		usage, err := a.usage.Add(ctx, key.ID, day, 1, 0)
+		if err != nil {
+			a.logger.ErrorContext(ctx, "failed to update API key usage", slog.Any("error", err))
+			http.Error(w, "Failed to check quota", http.StatusInternalServerError)
+			return
+		}
+		usage.Requests-- // Usage before this request
+		if err := key.exceeded(usage); err != nil {
+			if _, err := a.usage.Add(ctx, key.ID, day, -1, 0); err != nil {
+				a.logger.ErrorContext(ctx, "failed to update API key usage", slog.Any("error", err))
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(resetAt(now).Sub(now).Seconds())+1))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		cw := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r.WithContext(context.WithValue(ctx, keyContextKey{}, key)))
+
+		if _, err := a.usage.Add(context.WithoutCancel(ctx), key.ID, day, 0, body.n+cw.n); err != nil {
+			a.logger.ErrorContext(ctx, "failed to update API key usage", slog.Any("error", err))
+		}
+	})
+}
+
+// UsageResponse is the body returned by the usage endpoint
+type UsageResponse struct {
+	Key           string    `json:"key"`
+	Day           string    `json:"day"`
+	Requests      int64     `json:"requests"`
+	Bytes         int64     `json:"bytes"`
+	DailyRequests int64     `json:"daily_requests,omitempty"`
+	DailyBytes    int64     `json:"daily_bytes,omitempty"`
+	ResetsAt      time.Time `json:"resets_at"`
+}
+
+// Usage reports today's usage and quotas of the key the request is made
+// with. Requests to it are not counted.
+func (a *Authenticator) Usage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if a == nil {
+		http.Error(w, "API keys are not enabled", http.StatusNotFound)
+		return
+	}
+	key, err := a.authenticate(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	now := a.now().UTC()
+	usage, err := a.usage.Get(r.Context(), key.ID, dayOf(now))
+	if err != nil {
+		a.logger.ErrorContext(r.Context(), "failed to read API key usage", slog.Any("error", err))
+		http.Error(w, "Failed to read usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(UsageResponse{
+		Key:           key.ID,
+		Day:           dayOf(now),
+		Requests:      usage.Requests,
+		Bytes:         usage.Bytes,
+		DailyRequests: key.DailyRequests,
+		DailyBytes:    key.DailyBytes,
+		ResetsAt:      resetAt(now),
+	})
+}
+
+// dayOf returns the UTC day t falls on, as YYYY-MM-DD
+func dayOf(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// resetAt returns when the quotas of the day t falls on reset
+func resetAt(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to a response
+type countingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the writer
+func (w *countingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,278 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{name: "empty", input: " ", want: 0},
+		{name: "keys", input: `[{"id": "a", "key": "s1"}, {"id": "b", "key": "s2", "endpoints": ["process_url"], "defaults": {"quality": 70}}]`, want: 2},
+		{name: "missing id", input: `[{"key": "s1"}]`, wantErr: true},
+		{name: "unsafe id", input: `[{"id": "a b", "key": "s1"}]`, wantErr: true},
+		{name: "missing key", input: `[{"id": "a"}]`, wantErr: true},
+		{name: "negative quota", input: `[{"id": "a", "key": "s1", "daily_requests": -1}]`, wantErr: true},
+		{name: "unknown field", input: `[{"id": "a", "key": "s1", "quota": 5}]`, wantErr: true},
+		{name: "not json", input: `a:s1`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := ParseKeys(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", keys)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeys() error = %v", err)
+			}
+			if len(keys) != tt.want {
+				t.Errorf("Expected %d keys, got %d", tt.want, len(keys))
+			}
+		})
+	}
+}
+
+func TestNewRejectsDuplicates(t *testing.T) {
+	if _, err := New([]Key{{ID: "a", Secret: "s1"}, {ID: "a", Secret: "s2"}}); err == nil {
+		t.Error("Expected an error for a duplicate ID")
+	}
+	if _, err := New([]Key{{ID: "a", Secret: "s1"}, {ID: "b", Secret: "s1"}}); err == nil {
+		t.Error("Expected an error for a duplicate secret")
+	}
+}
+
+func TestRequire(t *testing.T) {
+	a, err := New([]Key{
+		{ID: "all", Secret: "secret-all"},
+		{ID: "upload", Secret: "secret-upload", Endpoints: []string{"process_upload"}},
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	var seen *Key
+	h := a.Require("process_url", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = KeyFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name       string
+		header     string
+		value      string
+		query      string
+		wantStatus int
+		wantKey    string
+	}{
+		{name: "header", header: HeaderAPIKey, value: "secret-all", wantStatus: http.StatusOK, wantKey: "all"},
+		{name: "bearer", header: "Authorization", value: "Bearer secret-all", wantStatus: http.StatusOK, wantKey: "all"},
+		{name: "query", query: "?api_key=secret-all", wantStatus: http.StatusOK, wantKey: "all"},
+		{name: "missing", wantStatus: http.StatusUnauthorized},
+		{name: "invalid", header: HeaderAPIKey, value: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "endpoint not allowed", header: HeaderAPIKey, value: "secret-upload", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			req := httptest.NewRequest("GET", "/process/url"+tt.query, nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantKey != "" && (seen == nil || seen.ID != tt.wantKey) {
+				t.Errorf("Expected key %s in the context, got %+v", tt.wantKey, seen)
+			}
+			if tt.wantKey == "" && seen != nil {
+				t.Errorf("Expected the handler not to run, got key %s", seen.ID)
+			}
+		})
+	}
+}
+
+func TestRequireNil(t *testing.T) {
+	var a *Authenticator
+	called := false
+	h := a.Require("process_url", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/process/url", nil))
+	if !called {
+		t.Error("Expected a nil authenticator to let requests through")
+	}
+}
+
+func TestRequireQuotas(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      Key
+		requests int
+		wantOK   int
+	}{
+		{name: "requests", key: Key{ID: "a", Secret: "s", DailyRequests: 3}, requests: 5, wantOK: 3},
+		// Each request uploads 4 and downloads 6 bytes
+		{name: "bytes", key: Key{ID: "a", Secret: "s", DailyBytes: 25}, requests: 5, wantOK: 3},
+		{name: "unlimited", key: Key{ID: "a", Secret: "s"}, requests: 5, wantOK: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryUsageStore()
+			a, err := New([]Key{tt.key}, WithUsageStore(store))
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			now := time.Date(2024, 1, 1, 23, 0, 0, 0, time.UTC)
+			a.now = func() time.Time { return now }
+			h := a.Require("process_upload", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.ReadAll(r.Body)
+				w.Write([]byte("output"))
+			}))
+			serve := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest("POST", "/process/upload", strings.NewReader("data"))
+				req.Header.Set(HeaderAPIKey, "s")
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, req)
+				return w
+			}
+
+			ok := 0
+			var last *httptest.ResponseRecorder
+			for i := 0; i < tt.requests; i++ {
+				if last = serve(); last.Code == http.StatusOK {
+					ok++
+				}
+			}
+			if ok != tt.wantOK {
+				t.Errorf("Expected %d requests to be served, got %d", tt.wantOK, ok)
+			}
+			if ok < tt.requests {
+				if last.Code != http.StatusTooManyRequests || last.Header().Get("Retry-After") != "3601" {
+					t.Errorf("Expected 429 with Retry-After 3601, got %d with %q", last.Code, last.Header().Get("Retry-After"))
+				}
+			}
+			usage, _ := store.Get(t.Context(), "a", "2024-01-01")
+			if usage.Requests != int64(tt.wantOK) || usage.Bytes != int64(tt.wantOK*10) {
+				t.Errorf("Unexpected usage %+v", usage)
+			}
+
+			// Quotas reset the next day
+			now = now.Add(2 * time.Hour)
+			if w := serve(); w.Code != http.StatusOK {
+				t.Errorf("Expected the quota to reset, got %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestRequireQuotaConcurrent(t *testing.T) {
+	store := NewMemoryUsageStore()
+	a, err := New([]Key{{ID: "a", Secret: "s", DailyRequests: 5}}, WithUsageStore(store))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	release := make(chan struct{})
+	h := a.Require("process_url", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+
+	var (
+		wg     sync.WaitGroup
+		served atomic.Int32
+	)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/process/url", nil)
+			req.Header.Set(HeaderAPIKey, "s")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code == http.StatusOK {
+				served.Add(1)
+			}
+		}()
+	}
+	close(release)
+	wg.Wait()
+
+	if served.Load() != 5 {
+		t.Errorf("Expected 5 requests to be served, got %d", served.Load())
+	}
+	if usage, _ := store.Get(t.Context(), "a", dayOf(time.Now().UTC())); usage.Requests != 5 {
+		t.Errorf("Expected refused requests not to be counted, got %d", usage.Requests)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	store := NewMemoryUsageStore()
+	a, err := New([]Key{{ID: "a", Secret: "s", DailyRequests: 100}}, WithUsageStore(store))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	store.Add(t.Context(), "a", "2024-01-01", 7, 1234)
+
+	req := httptest.NewRequest("GET", "/usage", nil)
+	req.Header.Set(HeaderAPIKey, "s")
+	w := httptest.NewRecorder()
+	a.Usage(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status OK, got %d", w.Code)
+	}
+	var resp UsageResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := UsageResponse{Key: "a", Day: "2024-01-01", Requests: 7, Bytes: 1234, DailyRequests: 100, ResetsAt: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+	if resp != want {
+		t.Errorf("Expected %+v, got %+v", want, resp)
+	}
+
+	// Checking usage does not count against it
+	if usage, _ := store.Get(t.Context(), "a", "2024-01-01"); usage.Requests != 7 {
+		t.Errorf("Expected 7 requests, got %d", usage.Requests)
+	}
+
+	w = httptest.NewRecorder()
+	a.Usage(w, httptest.NewRequest("GET", "/usage", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a key, got %d", w.Code)
+	}
+}
+
+func TestKeyExceeded(t *testing.T) {
+	key := Key{DailyRequests: 2, DailyBytes: 100}
+	if err := key.exceeded(Usage{Requests: 1, Bytes: 99}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if err := key.exceeded(Usage{Requests: 2}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected %v, got %v", ErrQuotaExceeded, err)
+	}
+	if err := key.exceeded(Usage{Bytes: 100}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("Expected %v, got %v", ErrQuotaExceeded, err)
+	}
+}
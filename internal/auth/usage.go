@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Usage is what a key used on one UTC day
+type Usage struct {
+	Day      string `json:"day"`
+	Requests int64  `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// UsageStore counts the usage of API keys per day. Only the current day
+// needs to be kept; a key's usage starts from zero on a new day.
+type UsageStore interface {
+	// Get returns the usage of a key on day
+	Get(ctx context.Context, keyID, day string) (Usage, error)
+
+	// Add adds requests and bytes, which may be negative, to the usage of
+	// a key on day and returns the new totals. Concurrent calls must not
+	// see the same totals.
+	Add(ctx context.Context, keyID, day string, requests, bytes int64) (Usage, error)
+}
+
+// usageCounts is the usage of each key on its latest day
+type usageCounts map[string]Usage
+
+// get returns the usage of a key on day
+func (c usageCounts) get(keyID, day string) Usage {
+	if usage, ok := c[keyID]; ok && usage.Day == day {
+		return usage
+	}
+	return Usage{Day: day}
+}
+
+// add adds to the usage of a key on day, dropping that of earlier days
+func (c usageCounts) add(keyID, day string, requests, bytes int64) Usage {
+	usage := c.get(keyID, day)
+	usage.Requests += requests
+	usage.Bytes += bytes
+	c[keyID] = usage
+	return usage
+}
+
+// MemoryUsageStore keeps usage in process memory. It is lost on restart.
+type MemoryUsageStore struct {
+	mu     sync.Mutex
+	counts usageCounts
+}
+
+// NewMemoryUsageStore creates an empty in-memory usage store
+func NewMemoryUsageStore() *MemoryUsageStore {
+	return &MemoryUsageStore{counts: usageCounts{}}
+}
+
+// Get implements UsageStore
+func (s *MemoryUsageStore) Get(ctx context.Context, keyID, day string) (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts.get(keyID, day), nil
+}
+
+// Add implements UsageStore
+func (s *MemoryUsageStore) Add(ctx context.Context, keyID, day string, requests, bytes int64) (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts.add(keyID, day, requests, bytes), nil
+}
+
+// FileUsageStore keeps usage in memory and writes it to usage.json in a
+// directory when flushed, so that quotas survive restarts. Usage added
+// since the last flush is lost if the process dies.
+type FileUsageStore struct {
+	path    string
+	flushMu sync.Mutex // Keeps flushes in order
+
+	mu     sync.Mutex
+	counts usageCounts
+	dirty  bool
+}
+
+// NewFileUsageStore creates a usage store in dir, loading the usage saved
+// there
+func NewFileUsageStore(dir string) (*FileUsageStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create usage directory: %w", err)
+	}
+	s := &FileUsageStore{path: filepath.Join(dir, "usage.json"), counts: usageCounts{}}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.counts); err != nil {
+		return nil, fmt.Errorf("failed to read usage: %w", err)
+	}
+	return s, nil
+}
+
+// Get implements UsageStore
+func (s *FileUsageStore) Get(ctx context.Context, keyID, day string) (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts.get(keyID, day), nil
+}
+
+// Add implements UsageStore
+func (s *FileUsageStore) Add(ctx context.Context, keyID, day string, requests, bytes int64) (Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dirty = true
+	return s.counts.add(keyID, day, requests, bytes), nil
+}
+
+// Flush writes the usage to disk if it changed since the last flush
+func (s *FileUsageStore) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(s.counts)
+	s.dirty = false
+	s.mu.Unlock()
+	if err == nil {
+		err = s.save(data)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Run flushes the usage every interval until ctx is cancelled
+func (s *FileUsageStore) Run(ctx context.Context, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				logger.WarnContext(ctx, "failed to save API key usage", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+// save atomically writes data by renaming a temporary file over the
+// previous one
+func (s *FileUsageStore) save(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write usage: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
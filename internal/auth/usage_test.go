@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUsageStores(t *testing.T) {
+	dir := t.TempDir()
+	fileStore, err := NewFileUsageStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileUsageStore() error = %v", err)
+	}
+
+	stores := map[string]UsageStore{
+		"memory": NewMemoryUsageStore(),
+		"file":   fileStore,
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if usage, err := store.Get(ctx, "a", "2024-01-01"); err != nil || usage != (Usage{Day: "2024-01-01"}) {
+				t.Errorf("Expected no usage, got %+v (%v)", usage, err)
+			}
+
+			store.Add(ctx, "a", "2024-01-01", 1, 10)
+			usage, err := store.Add(ctx, "a", "2024-01-01", 2, 5)
+			if err != nil {
+				t.Fatalf("Add() error = %v", err)
+			}
+			if usage != (Usage{Day: "2024-01-01", Requests: 3, Bytes: 15}) {
+				t.Errorf("Unexpected usage %+v", usage)
+			}
+			if usage, _ := store.Get(ctx, "b", "2024-01-01"); usage.Requests != 0 {
+				t.Errorf("Expected keys to be counted separately, got %+v", usage)
+			}
+
+			// A new day starts from zero
+			usage, _ = store.Add(ctx, "a", "2024-01-02", 1, 1)
+			if usage != (Usage{Day: "2024-01-02", Requests: 1, Bytes: 1}) {
+				t.Errorf("Expected usage to reset on a new day, got %+v", usage)
+			}
+		})
+	}
+}
+
+func TestFileUsageStorePersists(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileUsageStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileUsageStore() error = %v", err)
+	}
+	store.Add(context.Background(), "a", "2024-01-01", 4, 100)
+
+	// Usage is only written when flushed
+	if _, err := os.Stat(filepath.Join(dir, "usage.json")); err == nil {
+		t.Error("Expected usage not to be written before a flush")
+	}
+	if err := store.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+
+	reopened, err := NewFileUsageStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileUsageStore() error = %v", err)
+	}
+	usage, _ := reopened.Get(context.Background(), "a", "2024-01-01")
+	if usage.Requests != 4 || usage.Bytes != 100 {
+		t.Errorf("Expected usage to survive reopening, got %+v", usage)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "usage.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileUsageStore(dir); err == nil {
+		t.Error("Expected an error for a corrupt usage file")
+	}
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// entryOverhead approximates the memory used by an entry besides its data
+const entryOverhead = 512
+
+// Entry is a cached processing result
+type Entry struct {
+	Data     []byte
+	Metadata models.ImageMetadata
+}
+
+// Config sizes the cache tiers. A zero MemoryMaxBytes disables the memory
+// tier; an empty Dir disables the disk tier.
+type Config struct {
+	MemoryMaxBytes int64
+	Dir            string
+	DiskMaxBytes   int64
+}
+
+// Stats reports cache effectiveness and usage
+type Stats struct {
+	Hits           uint64 `json:"hits"`
+	Misses         uint64 `json:"misses"`
+	DiskHits       uint64 `json:"disk_hits"`
+	Evictions      uint64 `json:"evictions"`
+	MemoryEntries  int    `json:"memory_entries"`
+	MemoryBytes    int64  `json:"memory_bytes"`
+	MemoryMaxBytes int64  `json:"memory_max_bytes"`
+	DiskEntries    int    `json:"disk_entries"`
+	DiskBytes      int64  `json:"disk_bytes"`
+	DiskMaxBytes   int64  `json:"disk_max_bytes"`
+}
+
+// Cache stores processing results in a memory LRU backed by an optional
+// disk tier. Results found only on disk are promoted to memory. A nil *Cache
+// is valid and never hits, so callers do not need nil checks.
+type Cache struct {
+	mu     sync.Mutex
+	memory *lru[*Entry]
+	disk   *diskTier
+	stats  Stats
+}
+
+// New creates a cache with the given tiers
+func New(cfg Config) (*Cache, error) {
+	c := &Cache{memory: newLRU[*Entry](cfg.MemoryMaxBytes)}
+	if cfg.Dir != "" {
+		disk, err := newDiskTier(cfg.Dir, cfg.DiskMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+		c.disk = disk
+	}
+	return c, nil
+}
+
+// Key returns the content address of processing input with the given
+// canonical options: the SHA-256 of both, hex encoded
+func Key(input []byte, options string) string {
+	h := sha256.New()
+	inputSum := sha256.Sum256(input)
+	h.Write(inputSum[:])
+	h.Write([]byte(options))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the result cached under key
+func (c *Cache) Get(key string) (*Entry, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	entry, ok := c.memory.get(key)
+	if ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return copyEntry(entry), true
+	}
+	c.mu.Unlock()
+
+	if c.disk != nil {
+		if entry, ok := c.disk.get(key); ok {
+			c.mu.Lock()
+			c.stats.Hits++
+			c.stats.DiskHits++
+			c.addToMemory(key, entry)
+			c.mu.Unlock()
+			return copyEntry(entry), true
+		}
+	}
+
+	c.mu.Lock()
+	c.stats.Misses++
+	c.mu.Unlock()
+	return nil, false
+}
+
+// Put caches a result under key in every tier
+func (c *Cache) Put(key string, entry *Entry) {
+	if c == nil {
+		return
+	}
+
+	entry = copyEntry(entry)
+	c.mu.Lock()
+	c.addToMemory(key, entry)
+	c.mu.Unlock()
+
+	if c.disk != nil {
+		c.disk.put(key, entry)
+	}
+}
+
+// Stats returns a snapshot of the cache statistics
+func (c *Cache) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+
+	c.mu.Lock()
+	stats := c.stats
+	stats.MemoryEntries = c.memory.len()
+	stats.MemoryBytes = c.memory.bytes
+	stats.MemoryMaxBytes = c.memory.maxBytes
+	c.mu.Unlock()
+
+	if c.disk != nil {
+		disk := c.disk.stats()
+		stats.Evictions += disk.Evictions
+		stats.DiskEntries = disk.DiskEntries
+		stats.DiskBytes = disk.DiskBytes
+		stats.DiskMaxBytes = disk.DiskMaxBytes
+	}
+	return stats
+}
+
+// addToMemory adds entry to the memory tier. The caller must hold c.mu.
+func (c *Cache) addToMemory(key string, entry *Entry) {
+	if c.memory.maxBytes <= 0 {
+		return
+	}
+	evicted := c.memory.add(key, entry, int64(len(entry.Data))+entryOverhead)
+	c.stats.Evictions += uint64(len(evicted))
+}
+
+// copyEntry returns a copy of entry whose metadata can be modified freely.
+// The data is shared and must not be modified.
+func copyEntry(entry *Entry) *Entry {
+	c := *entry
+	return &c
+}
@@ -0,0 +1,178 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// entryOfSize returns an entry whose data is n bytes long
+func entryOfSize(n int) *Entry {
+	return &Entry{
+		Data:     []byte(strings.Repeat("x", n)),
+		Metadata: models.ImageMetadata{NewFormat: "webp", NewSize: int64(n)},
+	}
+}
+
+func TestKey(t *testing.T) {
+	a := Key([]byte("image"), "w=100")
+	if a != Key([]byte("image"), "w=100") {
+		t.Error("Expected equal inputs and options to give equal keys")
+	}
+	if a == Key([]byte("image"), "w=200") {
+		t.Error("Expected different options to give different keys")
+	}
+	if a == Key([]byte("other"), "w=100") {
+		t.Error("Expected different inputs to give different keys")
+	}
+	if !validKey(a) {
+		t.Errorf("Expected a 64 character hex key, got %q", a)
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	c, err := New(Config{MemoryMaxBytes: 3 * (1000 + entryOverhead)})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	keys := []string{Key([]byte("a"), ""), Key([]byte("b"), ""), Key([]byte("c"), ""), Key([]byte("d"), "")}
+	if _, ok := c.Get(keys[0]); ok {
+		t.Error("Expected a miss on an empty cache")
+	}
+
+	for _, key := range keys[:3] {
+		c.Put(key, entryOfSize(1000))
+	}
+	// Touch a so that b is the least recently used
+	entry, ok := c.Get(keys[0])
+	if !ok || len(entry.Data) != 1000 || entry.Metadata.NewFormat != "webp" {
+		t.Fatalf("Expected a hit with the cached entry, got %+v, %v", entry, ok)
+	}
+	entry.Metadata.NewFormat = "modified"
+
+	c.Put(keys[3], entryOfSize(1000))
+
+	if _, ok := c.Get(keys[1]); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	entry, ok = c.Get(keys[0])
+	if !ok {
+		t.Fatal("Expected the recently used entry to be kept")
+	}
+	if entry.Metadata.NewFormat != "webp" {
+		t.Error("Expected changes to a returned entry not to affect the cache")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.Misses != 2 || stats.Evictions != 1 || stats.MemoryEntries != 3 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats.MemoryBytes != 3*(1000+entryOverhead) {
+		t.Errorf("Expected %d bytes in memory, got %d", 3*(1000+entryOverhead), stats.MemoryBytes)
+	}
+}
+
+func TestOversizedEntriesAreNotCached(t *testing.T) {
+	c, _ := New(Config{MemoryMaxBytes: 1000})
+	key := Key([]byte("big"), "")
+
+	c.Put(key, entryOfSize(5000))
+
+	if _, ok := c.Get(key); ok {
+		t.Error("Expected an entry larger than the cache not to be cached")
+	}
+	if stats := c.Stats(); stats.MemoryBytes != 0 {
+		t.Errorf("Expected an empty cache, got %d bytes", stats.MemoryBytes)
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	key := Key([]byte("a"), "")
+
+	c, err := New(Config{Dir: dir, DiskMaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c.Put(key, entryOfSize(100))
+
+	// A new cache on the same directory finds the entry and promotes it
+	c, err = New(Config{MemoryMaxBytes: 1 << 20, Dir: dir, DiskMaxBytes: 1 << 20})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if stats := c.Stats(); stats.DiskEntries != 1 {
+		t.Errorf("Expected 1 indexed disk entry, got %d", stats.DiskEntries)
+	}
+
+	entry, ok := c.Get(key)
+	if !ok || len(entry.Data) != 100 || entry.Metadata.NewSize != 100 {
+		t.Fatalf("Expected a disk hit with the cached entry, got %+v, %v", entry, ok)
+	}
+	if _, ok := c.Get(key); !ok {
+		t.Fatal("Expected a memory hit after promotion")
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 2 || stats.DiskHits != 1 || stats.MemoryEntries != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := New(Config{Dir: dir, DiskMaxBytes: 3000}) // Room for two entries with their metadata
+
+	keys := []string{Key([]byte("a"), ""), Key([]byte("b"), ""), Key([]byte("c"), "")}
+	for _, key := range keys {
+		c.Put(key, entryOfSize(1000))
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, keys[0][:2], keys[0]+dataExt)); !os.IsNotExist(err) {
+		t.Errorf("Expected the evicted entry's file to be removed, got %v", err)
+	}
+	if _, ok := c.Get(keys[2]); !ok {
+		t.Error("Expected the newest entry to be kept")
+	}
+	if stats := c.Stats(); stats.DiskEntries != 2 || stats.Evictions != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestDiskCacheRestoresRecency(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := New(Config{Dir: dir, DiskMaxBytes: 1 << 20})
+
+	older, newer := Key([]byte("older"), ""), Key([]byte("newer"), "")
+	c.Put(older, entryOfSize(1000))
+	c.Put(newer, entryOfSize(1000))
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, older[:2], older+dataExt), past, past)
+
+	// Reopening with room for one entry keeps the most recently used
+	c, _ = New(Config{Dir: dir, DiskMaxBytes: 2000})
+	if _, ok := c.Get(older); ok {
+		t.Error("Expected the older entry to be evicted on startup")
+	}
+	if _, ok := c.Get(newer); !ok {
+		t.Error("Expected the newer entry to be kept on startup")
+	}
+}
+
+func TestNilCache(t *testing.T) {
+	var c *Cache
+
+	// None of these should panic
+	c.Put(Key([]byte("a"), ""), entryOfSize(1))
+	if _, ok := c.Get(Key([]byte("a"), "")); ok {
+		t.Error("Expected a nil cache to miss")
+	}
+	if stats := c.Stats(); stats != (Stats{}) {
+		t.Errorf("Expected empty stats, got %+v", stats)
+	}
+}
@@ -0,0 +1,198 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Extensions of the files making up a disk entry
+const (
+	dataExt     = ".webp"
+	metadataExt = ".json"
+)
+
+// diskTier stores entries as a WebP file and a metadata file per key,
+// evicting the least recently used once their total size exceeds maxBytes.
+// File operations happen outside the lock; a file that disappears between
+// the index lookup and the read is treated as a miss.
+type diskTier struct {
+	dir       string
+	mu        sync.Mutex
+	index     *lru[struct{}]
+	evictions uint64
+}
+
+// newDiskTier opens the disk tier in dir, indexing entries left by earlier
+// runs in order of last use
+func newDiskTier(dir string, maxBytes int64) (*diskTier, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	d := &diskTier{dir: dir, index: newLRU[struct{}](maxBytes)}
+
+	type found struct {
+		key     string
+		size    int64
+		modTime time.Time
+	}
+	var entries []found
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name := entry.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			os.Remove(path)
+			return nil
+		}
+		key, ok := strings.CutSuffix(name, dataExt)
+		if !ok || !validKey(key) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		meta, err := os.Stat(d.path(key, metadataExt))
+		if err != nil {
+			os.Remove(path)
+			return nil
+		}
+		entries = append(entries, found{key: key, size: info.Size() + meta.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index cache directory: %w", err)
+	}
+
+	// Add the oldest first so that the most recently used end up in front
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	for _, e := range entries {
+		d.removeFiles(d.index.add(e.key, struct{}{}, e.size))
+	}
+	return d, nil
+}
+
+// get reads the entry for key
+func (d *diskTier) get(key string) (*Entry, bool) {
+	d.mu.Lock()
+	_, ok := d.index.get(key)
+	d.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(d.path(key, dataExt))
+	if err != nil {
+		return nil, false
+	}
+	metadata, err := os.ReadFile(d.path(key, metadataExt))
+	if err != nil {
+		return nil, false
+	}
+	entry := &Entry{Data: data}
+	if err := json.Unmarshal(metadata, &entry.Metadata); err != nil {
+		return nil, false
+	}
+
+	// Record the use so that recency survives restarts
+	now := time.Now()
+	os.Chtimes(d.path(key, dataExt), now, now)
+	return entry, true
+}
+
+// put writes the entry for key. Failures are ignored; the entry is simply
+// not cached on disk.
+func (d *diskTier) put(key string, entry *Entry) {
+	if !validKey(key) {
+		return
+	}
+	metadata, err := json.Marshal(entry.Metadata)
+	if err != nil || int64(len(entry.Data)+len(metadata)) > d.index.maxBytes {
+		return
+	}
+
+	// Metadata goes first: an entry is only indexed on startup if its data
+	// file exists
+	if err := d.writeFile(key, metadataExt, metadata); err != nil {
+		return
+	}
+	if err := d.writeFile(key, dataExt, entry.Data); err != nil {
+		os.Remove(d.path(key, metadataExt))
+		return
+	}
+
+	d.mu.Lock()
+	evicted := d.index.add(key, struct{}{}, int64(len(entry.Data)+len(metadata)))
+	d.evictions += uint64(len(evicted))
+	d.mu.Unlock()
+	d.removeFiles(evicted)
+}
+
+// stats reports the disk tier's share of the cache statistics
+func (d *diskTier) stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return Stats{
+		Evictions:    d.evictions,
+		DiskEntries:  d.index.len(),
+		DiskBytes:    d.index.bytes,
+		DiskMaxBytes: d.index.maxBytes,
+	}
+}
+
+// writeFile atomically writes one file of an entry
+func (d *diskTier) writeFile(key, ext string, data []byte) error {
+	path := d.path(key, ext)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// removeFiles deletes the files of evicted entries
+func (d *diskTier) removeFiles(evicted []lruItem[struct{}]) {
+	for _, item := range evicted {
+		os.Remove(d.path(item.key, dataExt))
+		os.Remove(d.path(item.key, metadataExt))
+	}
+}
+
+// path returns the path of one file of an entry, sharded by key prefix
+func (d *diskTier) path(key, ext string) string {
+	return filepath.Join(d.dir, key[:2], key+ext)
+}
+
+// validKey reports whether key is a hex SHA-256 as returned by Key
+func validKey(key string) bool {
+	if len(key) != 64 {
+		return false
+	}
+	for _, c := range key {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
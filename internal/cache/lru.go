@@ -0,0 +1,81 @@
+package cache
+
+import "container/list"
+
+// lru tracks entries in least recently used order and evicts the oldest
+// once their total size exceeds maxBytes. It is not safe for concurrent use.
+type lru[V any] struct {
+	maxBytes int64
+	bytes    int64
+	order    *list.List // Front is most recently used
+	items    map[string]*list.Element
+}
+
+// lruItem is an entry in the lru list
+type lruItem[V any] struct {
+	key   string
+	value V
+	size  int64
+}
+
+// newLRU creates an lru bounded to maxBytes
+func newLRU[V any](maxBytes int64) *lru[V] {
+	return &lru[V]{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// get returns the value for key and marks it as recently used
+func (l *lru[V]) get(key string) (V, bool) {
+	el, ok := l.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	l.order.MoveToFront(el)
+	return el.Value.(*lruItem[V]).value, true
+}
+
+// add inserts or replaces key and returns the entries evicted to make room.
+// An entry larger than the whole cache is not added and is returned as
+// evicted itself.
+func (l *lru[V]) add(key string, value V, size int64) (evicted []lruItem[V]) {
+	if size > l.maxBytes {
+		l.remove(key)
+		return []lruItem[V]{{key: key, value: value, size: size}}
+	}
+
+	if el, ok := l.items[key]; ok {
+		item := el.Value.(*lruItem[V])
+		l.bytes += size - item.size
+		item.value, item.size = value, size
+		l.order.MoveToFront(el)
+	} else {
+		l.items[key] = l.order.PushFront(&lruItem[V]{key: key, value: value, size: size})
+		l.bytes += size
+	}
+
+	for l.bytes > l.maxBytes {
+		oldest := l.order.Back()
+		item := oldest.Value.(*lruItem[V])
+		l.remove(item.key)
+		evicted = append(evicted, *item)
+	}
+	return evicted
+}
+
+// remove deletes key if present
+func (l *lru[V]) remove(key string) {
+	if el, ok := l.items[key]; ok {
+		l.bytes -= el.Value.(*lruItem[V]).size
+		l.order.Remove(el)
+		delete(l.items, key)
+	}
+}
+
+// len returns the number of entries
+func (l *lru[V]) len() int {
+	return len(l.items)
+}
@@ -0,0 +1,76 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// sourceOverhead approximates the memory used by a source besides its data
+const sourceOverhead = 256
+
+// Source is a downloaded image kept with the validators needed to
+// revalidate it with its origin
+type Source struct {
+	Data         []byte
+	URL          string // Final URL after redirects
+	ContentType  string
+	ETag         string
+	LastModified string
+
+	// FreshUntil is when the origin's max-age runs out. Until then the
+	// source is used without contacting the origin.
+	FreshUntil time.Time
+}
+
+// Fresh reports whether the source can be used without revalidation
+func (s *Source) Fresh(now time.Time) bool {
+	return now.Before(s.FreshUntil)
+}
+
+// Validatable reports whether the origin can be asked if the source changed
+func (s *Source) Validatable() bool {
+	return s.ETag != "" || s.LastModified != ""
+}
+
+// SourceCache keeps downloaded images by URL in an LRU bounded by size. A
+// nil *SourceCache is valid and never hits.
+type SourceCache struct {
+	mu      sync.Mutex
+	sources *lru[*Source]
+}
+
+// NewSourceCache creates a source cache holding up to maxBytes
+func NewSourceCache(maxBytes int64) *SourceCache {
+	return &SourceCache{sources: newLRU[*Source](maxBytes)}
+}
+
+// Get returns the source cached for url. The source is shared and must not
+// be modified.
+func (c *SourceCache) Get(url string) (*Source, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sources.get(url)
+}
+
+// Put caches the source for url, replacing any earlier version
+func (c *SourceCache) Put(url string, source *Source) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sources.add(url, source, int64(len(source.Data)+len(url))+sourceOverhead)
+}
+
+// Delete removes the source cached for url
+func (c *SourceCache) Delete(url string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sources.remove(url)
+}
@@ -0,0 +1,173 @@
+package fetch
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outcome is how a request to a host went, as far as its health goes
+type outcome int
+
+const (
+	// succeeded means the host answered, even if with an error status
+	succeeded outcome = iota
+
+	// failed means the request failed in a way that may be transient
+	failed
+
+	// abandoned means the request ended without telling anything about
+	// the host, e.g. because the caller gave up
+	abandoned
+)
+
+// Hosts are chosen by callers, so the number tracked is bounded
+const (
+	// breakerIdleTimeout is how long a host is remembered after its last
+	// request, unless the cooldown is longer
+	breakerIdleTimeout = 10 * time.Minute
+
+	// breakerSweepInterval is how often idle hosts are forgotten
+	breakerSweepInterval = time.Minute
+
+	// maxBreakerHosts caps how many hosts are tracked at once
+	maxBreakerHosts = 1000
+)
+
+// breakers tracks consecutive failures per host and refuses requests to a
+// host after threshold of them, until cooldown has passed. One request is
+// then let through as a probe: if it succeeds the host is trusted again,
+// otherwise it is refused for another cooldown. Only hosts with failures
+// are tracked; idle hosts are forgotten, and once maxHosts are tracked the
+// least recently used one makes room for a new one.
+type breakers struct {
+	threshold int
+	cooldown  time.Duration
+	maxHosts  int
+
+	mu        sync.Mutex
+	hosts     map[string]*breaker
+	lastSweep time.Time
+
+	trips      atomic.Uint64
+	rejections atomic.Uint64
+}
+
+// breaker is the state of one host
+type breaker struct {
+	failures int
+	openedAt time.Time // Zero while closed
+	probing  bool      // A probe is in flight while half-open
+	lastUsed time.Time
+}
+
+func newBreakers(threshold int, cooldown time.Duration) *breakers {
+	return &breakers{threshold: threshold, cooldown: cooldown, maxHosts: maxBreakerHosts, hosts: map[string]*breaker{}}
+}
+
+// allow returns an error wrapping ErrCircuitOpen if requests to host are
+// currently refused. Every allowed request must be followed by done.
+func (b *breakers) allow(host string, now time.Time) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h := b.hosts[host]
+	if h == nil || h.openedAt.IsZero() {
+		return nil
+	}
+	if now.Sub(h.openedAt) < b.cooldown || h.probing {
+		b.rejections.Add(1)
+		return fmt.Errorf("%w: %s failed %d times in a row", ErrCircuitOpen, host, h.failures)
+	}
+	h.probing = true
+	h.lastUsed = now
+	return nil
+}
+
+// done records the outcome of a request allowed by allow
+func (b *breakers) done(host string, result outcome, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.sweep(now)
+	h := b.hosts[host]
+	switch result {
+	case succeeded:
+		delete(b.hosts, host)
+	case abandoned:
+		if h != nil {
+			h.probing = false
+		}
+	case failed:
+		if h == nil {
+			if len(b.hosts) >= b.maxHosts {
+				b.evict()
+			}
+			h = &breaker{}
+			b.hosts[host] = h
+		}
+		h.failures++
+		h.lastUsed = now
+		if h.probing || (h.openedAt.IsZero() && h.failures >= b.threshold) {
+			h.openedAt = now
+			h.probing = false
+			b.trips.Add(1)
+		}
+	}
+}
+
+// sweep forgets hosts that have not been requested for the idle timeout.
+// b.mu must be held.
+func (b *breakers) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < breakerSweepInterval {
+		return
+	}
+	b.lastSweep = now
+	idle := max(breakerIdleTimeout, b.cooldown)
+	for host, h := range b.hosts {
+		if !h.probing && now.Sub(h.lastUsed) >= idle {
+			delete(b.hosts, host)
+		}
+	}
+}
+
+// evict forgets the least recently used host that is not being probed.
+// b.mu must be held.
+func (b *breakers) evict() {
+	var (
+		oldest string
+		found  bool
+	)
+	for host, h := range b.hosts {
+		if !h.probing && (!found || h.lastUsed.Before(b.hosts[oldest].lastUsed)) {
+			oldest, found = host, true
+		}
+	}
+	if found {
+		delete(b.hosts, oldest)
+	}
+}
+
+// open returns how many hosts are currently refused or being probed
+func (b *breakers) open() int {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	n := 0
+	for _, h := range b.hosts {
+		if !h.openedAt.IsZero() {
+			n++
+		}
+	}
+	return n
+}
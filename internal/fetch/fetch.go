@@ -0,0 +1,420 @@
+// Package fetch downloads remote images with the limits and protections
+// that fetching user-supplied URLs needs: size caps, timeouts, redirect
+// limits, content type checks and refusal of non-public destinations.
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/tracing"
+)
+
+// Common errors
+var (
+	ErrInvalidURL           = errors.New("invalid URL format")
+	ErrForbiddenDestination = errors.New("destination not allowed")
+	ErrTooManyRedirects     = errors.New("too many redirects")
+	ErrStatus               = errors.New("unexpected response status")
+	ErrTooLarge             = errors.New("response too large")
+	ErrContentType          = errors.New("unsupported content type")
+	ErrCircuitOpen          = errors.New("origin is failing, requests are paused")
+)
+
+// Defaults for unset Config fields
+const (
+	DefaultMaxBytes         = 32 << 20
+	DefaultConnectTimeout   = 10 * time.Second
+	DefaultReadTimeout      = 30 * time.Second
+	DefaultTimeout          = 60 * time.Second
+	DefaultMaxRedirects     = 5
+	DefaultRetries          = 2
+	DefaultRetryBaseDelay   = 200 * time.Millisecond
+	DefaultRetryMaxDelay    = 5 * time.Second
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = 30 * time.Second
+	DefaultUserAgent        = "smart-webp-resize/1.0 (+https://github.com/Mark-Life/smart-webp-resize)"
+)
+
+// DefaultAcceptedTypes are the response content types accepted by default.
+// Many object stores serve images as application/octet-stream; the
+// processor rejects anything that does not decode as an image anyway.
+var DefaultAcceptedTypes = []string{"image/*", "application/octet-stream"}
+
+// Config configures a Fetcher. Zero fields take the defaults above.
+type Config struct {
+	// MaxBytes caps the size of a response body
+	MaxBytes int64
+
+	// ConnectTimeout bounds establishing a connection, including TLS
+	ConnectTimeout time.Duration
+
+	// ReadTimeout bounds waiting for the response headers and for each
+	// read of the body, so a stalled origin is abandoned
+	ReadTimeout time.Duration
+
+	// Timeout bounds the whole request, including redirects and the body
+	Timeout time.Duration
+
+	// MaxRedirects is how many redirects are followed. Negative disables
+	// following redirects.
+	MaxRedirects int
+
+	// AcceptedTypes lists accepted response media types, such as
+	// "image/png" or "image/*". Responses without a Content-Type are
+	// accepted.
+	AcceptedTypes []string
+
+	// UserAgent is sent with every request
+	UserAgent string
+
+	// Policy restricts where URLs may be fetched from
+	Policy Policy
+
+	// Origins add headers to requests to particular hosts, so that private
+	// sources can be fetched without credentials in their URLs
+	Origins []Origin
+
+	// Retries is how many times a request that failed in a way that may be
+	// transient is retried: connection errors, timeouts and 408, 429, 502,
+	// 503 and 504 responses. Negative disables retries.
+	Retries int
+
+	// RetryBaseDelay and RetryMaxDelay bound the jittered exponential
+	// backoff between retries. A Retry-After longer than RetryMaxDelay is
+	// not waited for.
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+
+	// BreakerThreshold is how many consecutive transient failures make
+	// requests to a host fail fast with ErrCircuitOpen for BreakerCooldown.
+	// Negative disables the circuit breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+}
+
+// Response is a fetched resource
+type Response struct {
+	// StatusCode is http.StatusOK, or http.StatusNotModified for a
+	// conditional request whose validators matched
+	StatusCode int
+
+	// Data is the response body; nil for a 304
+	Data []byte
+
+	// URL is the final URL after redirects
+	URL string
+
+	// ContentType is the media type of the response, without parameters
+	ContentType string
+
+	// Header holds the response headers
+	Header http.Header
+}
+
+// Fetcher downloads resources over HTTP. It is safe for concurrent use.
+type Fetcher struct {
+	client        *http.Client
+	maxBytes      int64
+	acceptedTypes []string
+	userAgent     string
+	policy        Policy
+	origins       origins
+
+	retries        int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+	breakers       *breakers
+	retried        atomic.Uint64
+}
+
+// Stats are counters of a fetcher's retries and circuit breakers
+type Stats struct {
+	Retries           uint64 // Requests retried
+	BreakerTrips      uint64 // Times a host's circuit breaker opened
+	BreakerRejections uint64 // Requests refused by an open circuit breaker
+	OpenBreakers      int    // Hosts whose circuit breaker is open or probing
+}
+
+// New creates a fetcher
+func New(cfg Config) *Fetcher {
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultMaxBytes
+	}
+	if cfg.ConnectTimeout <= 0 {
+		cfg.ConnectTimeout = DefaultConnectTimeout
+	}
+	if cfg.ReadTimeout <= 0 {
+		cfg.ReadTimeout = DefaultReadTimeout
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MaxRedirects == 0 {
+		cfg.MaxRedirects = DefaultMaxRedirects
+	}
+	if len(cfg.AcceptedTypes) == 0 {
+		cfg.AcceptedTypes = DefaultAcceptedTypes
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = DefaultUserAgent
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = DefaultRetries
+	}
+	if cfg.RetryBaseDelay <= 0 {
+		cfg.RetryBaseDelay = DefaultRetryBaseDelay
+	}
+	if cfg.RetryMaxDelay <= 0 {
+		cfg.RetryMaxDelay = DefaultRetryMaxDelay
+	}
+	if cfg.BreakerThreshold == 0 {
+		cfg.BreakerThreshold = DefaultBreakerThreshold
+	}
+	if cfg.BreakerCooldown <= 0 {
+		cfg.BreakerCooldown = DefaultBreakerCooldown
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   cfg.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+		Control:   cfg.Policy.control,
+	}
+	readTimeout := cfg.ReadTimeout
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Proxies are not used since they would connect on our behalf
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return &readDeadlineConn{Conn: conn, timeout: readTimeout}, nil
+	}
+	transport.TLSHandshakeTimeout = cfg.ConnectTimeout
+	transport.ResponseHeaderTimeout = cfg.ReadTimeout
+
+	f := &Fetcher{
+		maxBytes:      cfg.MaxBytes,
+		acceptedTypes: cfg.AcceptedTypes,
+		userAgent:     cfg.UserAgent,
+		policy:        cfg.Policy,
+		origins:       cfg.Origins,
+
+		retries:        max(cfg.Retries, 0),
+		retryBaseDelay: cfg.RetryBaseDelay,
+		retryMaxDelay:  cfg.RetryMaxDelay,
+	}
+	if cfg.BreakerThreshold > 0 {
+		f.breakers = newBreakers(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	}
+	checkRedirect := cfg.Policy.redirectChecker(max(cfg.MaxRedirects, 0))
+	f.client = &http.Client{
+		Transport: transport,
+		Timeout:   cfg.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if err := checkRedirect(req, via); err != nil {
+				return err
+			}
+			f.origins.apply(req.Header, req.URL)
+			return nil
+		},
+	}
+	return f
+}
+
+// CheckURL reports whether rawURL may be fetched, short of resolving its
+// host
+func (f *Fetcher) CheckURL(rawURL string) error {
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	return f.policy.checkURL(u)
+}
+
+// Get fetches rawURL, adding header to the request. Any status other than
+// 200, or 304 when header makes the request conditional, is an error.
+// Transient failures are retried, and requests to a host that keeps failing
+// fail fast with ErrCircuitOpen. Details are recorded on the span in ctx.
+func (f *Fetcher) Get(ctx context.Context, rawURL string, header http.Header) (*Response, error) {
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if err := f.policy.checkURL(u); err != nil {
+		return nil, err
+	}
+	span := trace.SpanFromContext(ctx)
+	host := strings.ToLower(u.Host)
+
+	for attempt := 0; ; attempt++ {
+		if err := f.breakers.allow(host, time.Now()); err != nil {
+			return nil, err
+		}
+		resp, err := f.get(ctx, rawURL, header)
+		switch {
+		case err == nil:
+			f.breakers.done(host, succeeded, time.Now())
+		case ctx.Err() != nil:
+			f.breakers.done(host, abandoned, time.Now())
+		case transient(err):
+			f.breakers.done(host, failed, time.Now())
+		default:
+			f.breakers.done(host, succeeded, time.Now())
+		}
+		span.SetAttributes(attribute.Int("fetch.attempts", attempt+1))
+
+		delay, retry := f.retryDelay(ctx, attempt, err)
+		if err == nil || !retry {
+			return resp, err
+		}
+		f.retried.Add(1)
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.String("error", err.Error()),
+			attribute.Int64("delay_ms", delay.Milliseconds()),
+		))
+		if sleep(ctx, delay) != nil {
+			return nil, err
+		}
+	}
+}
+
+// get makes a single request for Get
+func (f *Fetcher) get(ctx context.Context, rawURL string, header http.Header) (*Response, error) {
+	span := trace.SpanFromContext(ctx)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	f.origins.apply(req.Header, req.URL)
+
+	// Propagate the trace context to the origin
+	tracing.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	finalURL := resp.Request.URL.String()
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", resp.StatusCode),
+		attribute.String("fetch.final_url", finalURL),
+	)
+
+	response := &Response{StatusCode: resp.StatusCode, URL: finalURL, Header: resp.Header}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return response, nil
+	default:
+		return nil, &statusError{
+			code:       resp.StatusCode,
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	response.ContentType, err = f.checkContentType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	if resp.ContentLength > f.maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrTooLarge, resp.ContentLength, f.maxBytes)
+	}
+
+	response.Data, err = ReadAll(resp.Body, f.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.Int("http.response.body.size", len(response.Data)),
+		attribute.String("http.response.content_type", response.ContentType),
+	)
+	return response, nil
+}
+
+// Stats returns the fetcher's retry and circuit breaker counters
+func (f *Fetcher) Stats() Stats {
+	stats := Stats{Retries: f.retried.Load(), OpenBreakers: f.breakers.open()}
+	if f.breakers != nil {
+		stats.BreakerTrips = f.breakers.trips.Load()
+		stats.BreakerRejections = f.breakers.rejections.Load()
+	}
+	return stats
+}
+
+// MaxBytes returns the largest body the fetcher downloads, which other
+// sources of images should also respect
+func (f *Fetcher) MaxBytes() int64 {
+	return f.maxBytes
+}
+
+// ReadAll reads r to the end, failing with ErrTooLarge once more than
+// maxBytes have been read
+func ReadAll(r io.Reader, maxBytes int64) ([]byte, error) {
+	// Read one byte past the limit to detect an oversized body
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, fmt.Errorf("%w: body exceeds the limit of %d bytes", ErrTooLarge, maxBytes)
+	}
+	return data, nil
+}
+
+// checkContentType returns the media type of a Content-Type header if it is
+// accepted
+func (f *Fetcher) checkContentType(contentType string) (string, error) {
+	if contentType == "" {
+		return "", nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", fmt.Errorf("%w: %q", ErrContentType, contentType)
+	}
+	for _, accepted := range f.acceptedTypes {
+		accepted = strings.ToLower(strings.TrimSpace(accepted))
+		if prefix, ok := strings.CutSuffix(accepted, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return mediaType, nil
+			}
+		} else if mediaType == accepted {
+			return mediaType, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrContentType, mediaType)
+}
+
+// readDeadlineConn fails a read that waits longer than timeout for data
+type readDeadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+// Read extends the read deadline before each read
+func (c *readDeadlineConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
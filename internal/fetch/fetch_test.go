@@ -0,0 +1,229 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loopback lets fetchers reach httptest servers
+var loopback = Policy{AllowNets: []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::1/128"),
+}}
+
+// writeImage answers with a small body declared as an image
+func writeImage(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Write([]byte("mock image data"))
+}
+
+func TestGet(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image.jpg":
+			writeImage(w)
+		case "/params":
+			w.Header().Set("Content-Type", "Image/PNG; charset=binary")
+			w.Write([]byte("png"))
+		case "/octet":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write([]byte("bytes"))
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/invalid-type":
+			w.Header().Set("Content-Type", "image/;;")
+			w.Write([]byte("bytes"))
+		case "/large":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte(strings.Repeat("x", 20)))
+		case "/large-chunked":
+			w.Header().Set("Content-Type", "image/jpeg")
+			for i := 0; i < 20; i++ {
+				w.Write([]byte("x"))
+				w.(http.Flusher).Flush()
+			}
+		case "/redirect":
+			http.Redirect(w, r, "/image.jpg", http.StatusFound)
+		case "/missing":
+			http.NotFound(w, r)
+		}
+	}))
+	defer mockServer.Close()
+
+	fetcher := New(Config{MaxBytes: 16, Policy: loopback})
+
+	tests := []struct {
+		path            string
+		wantData        string
+		wantContentType string
+		wantPath        string
+		expected        error
+	}{
+		{path: "/image.jpg", wantData: "mock image data", wantContentType: "image/jpeg", wantPath: "/image.jpg"},
+		{path: "/params", wantData: "png", wantContentType: "image/png", wantPath: "/params"},
+		{path: "/octet", wantData: "bytes", wantContentType: "application/octet-stream", wantPath: "/octet"},
+		{path: "/redirect", wantData: "mock image data", wantContentType: "image/jpeg", wantPath: "/image.jpg"},
+		{path: "/html", expected: ErrContentType},
+		{path: "/invalid-type", expected: ErrContentType},
+		{path: "/large", expected: ErrTooLarge},
+		{path: "/large-chunked", expected: ErrTooLarge},
+		{path: "/missing", expected: ErrStatus},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := fetcher.Get(context.Background(), mockServer.URL+tt.path, nil)
+			if tt.expected != nil {
+				if !errors.Is(err, tt.expected) {
+					t.Errorf("Expected %v, got %v", tt.expected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if string(resp.Data) != tt.wantData {
+				t.Errorf("Expected data %q, got %q", tt.wantData, resp.Data)
+			}
+			if resp.ContentType != tt.wantContentType {
+				t.Errorf("Expected content type %q, got %q", tt.wantContentType, resp.ContentType)
+			}
+			if resp.URL != mockServer.URL+tt.wantPath {
+				t.Errorf("Expected final URL %s, got %s", mockServer.URL+tt.wantPath, resp.URL)
+			}
+		})
+	}
+}
+
+func TestGetAcceptedTypes(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.Write([]byte("data"))
+	}))
+	defer mockServer.Close()
+
+	fetcher := New(Config{AcceptedTypes: []string{"image/png", "image/webp"}, Policy: loopback})
+
+	tests := []struct {
+		contentType string
+		expected    error
+	}{
+		{"image/png", nil},
+		{"image/webp", nil},
+		{"image/jpeg", ErrContentType},
+		{"application/octet-stream", ErrContentType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			_, err := fetcher.Get(context.Background(), mockServer.URL+"/?type="+tt.contentType, nil)
+			if tt.expected == nil && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestGetMaxRedirects(t *testing.T) {
+	// /n redirects to /n-1 until /0, which serves the image
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		if _, err := fmt.Sscanf(r.URL.Path, "/%d", &n); err != nil || n == 0 {
+			writeImage(w)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/%d", n-1), http.StatusFound)
+	}))
+	defer mockServer.Close()
+
+	tests := []struct {
+		name         string
+		maxRedirects int
+		redirects    int
+		expected     error
+	}{
+		{"within default", 0, DefaultMaxRedirects, nil},
+		{"over default", 0, DefaultMaxRedirects + 1, ErrTooManyRedirects},
+		{"within limit", 2, 2, nil},
+		{"over limit", 2, 3, ErrTooManyRedirects},
+		{"disabled", -1, 1, ErrTooManyRedirects},
+		{"disabled without redirect", -1, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := New(Config{MaxRedirects: tt.maxRedirects, Policy: loopback})
+			_, err := fetcher.Get(context.Background(), fmt.Sprintf("%s/%d", mockServer.URL, tt.redirects), nil)
+			if tt.expected == nil && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestGetHeaders(t *testing.T) {
+	var userAgent, ifNoneMatch string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent, ifNoneMatch = r.UserAgent(), r.Header.Get("If-None-Match")
+		if ifNoneMatch == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeImage(w)
+	}))
+	defer mockServer.Close()
+
+	if _, err := New(Config{Policy: loopback}).Get(context.Background(), mockServer.URL, nil); err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if userAgent != DefaultUserAgent {
+		t.Errorf("Expected User-Agent %q, got %q", DefaultUserAgent, userAgent)
+	}
+
+	fetcher := New(Config{UserAgent: "custom/1.0", Policy: loopback})
+	resp, err := fetcher.Get(context.Background(), mockServer.URL, http.Header{"If-None-Match": {`"v1"`}})
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if userAgent != "custom/1.0" {
+		t.Errorf("Expected User-Agent custom/1.0, got %q", userAgent)
+	}
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected status %d, got %d", http.StatusNotModified, resp.StatusCode)
+	}
+}
+
+func TestGetReadTimeout(t *testing.T) {
+	release := make(chan struct{})
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		<-release
+	}))
+	defer mockServer.Close()
+	defer close(release)
+
+	fetcher := New(Config{ReadTimeout: 50 * time.Millisecond, Policy: loopback})
+	start := time.Now()
+	if _, err := fetcher.Get(context.Background(), mockServer.URL, nil); err == nil {
+		t.Error("Expected a stalled body to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the read timeout to end the request, took %s", elapsed)
+	}
+}
@@ -0,0 +1,55 @@
+package fetch
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Origin adds headers, such as credentials or a Referer, to every request
+// sent to matching hosts
+type Origin struct {
+	// Host matches a host exactly, or any subdomain when written as
+	// "*.example.com"
+	Host string
+
+	// Header is set on requests to the host, replacing any value the
+	// request already had
+	Header http.Header
+}
+
+// origins applies the first matching Origin to each request, including
+// every redirect hop
+type origins []Origin
+
+// apply sets the headers of the origin matching u on header, and removes
+// those of any other origin so that they are not carried along redirects
+// to other hosts
+func (o origins) apply(header http.Header, u *url.URL) {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	matched := o.match(host)
+	for i := range o {
+		if i == matched {
+			continue
+		}
+		for name := range o[i].Header {
+			header.Del(name)
+		}
+	}
+	if matched < 0 {
+		return
+	}
+	for name, values := range o[matched].Header {
+		header[http.CanonicalHeaderKey(name)] = values
+	}
+}
+
+// match returns the index of the first origin matching host, or -1
+func (o origins) match(host string) int {
+	for i := range o {
+		if matchesHost([]string{o[i].Host}, host) {
+			return i
+		}
+	}
+	return -1
+}
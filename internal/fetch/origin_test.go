@@ -0,0 +1,96 @@
+package fetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetOriginHeaders(t *testing.T) {
+	received := map[string]http.Header{}
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received[r.Host] = r.Header.Clone()
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+			return
+		}
+		writeImage(w)
+	}))
+	defer mockServer.Close()
+
+	// localhost and 127.0.0.1 reach the same server as different hosts
+	port := mockServer.URL[strings.LastIndex(mockServer.URL, ":")+1:]
+	private := "localhost:" + port
+	other := "127.0.0.1:" + port
+
+	fetcher := New(Config{
+		Policy: loopback,
+		Origins: []Origin{
+			{Host: "localhost", Header: http.Header{
+				"Authorization": {"Bearer secret"},
+				"Referer":       {"https://www.example.com/"},
+			}},
+			{Host: "*.example.com", Header: http.Header{"X-Api-Key": {"unused"}}},
+		},
+	})
+
+	tests := []struct {
+		name          string
+		url           string
+		host          string
+		authorization string
+		referer       string
+	}{
+		{
+			name:          "matching host",
+			url:           "http://" + private + "/image.jpg",
+			host:          private,
+			authorization: "Bearer secret",
+			referer:       "https://www.example.com/",
+		},
+		{
+			name: "other host",
+			url:  "http://" + other + "/image.jpg",
+			host: other,
+		},
+		{
+			name: "redirect away from matching host",
+			url:  "http://" + private + "/redirect?to=http://" + other + "/image.jpg",
+			host: other,
+		},
+		{
+			name:          "redirect to matching host",
+			url:           "http://" + other + "/redirect?to=http://" + private + "/image.jpg",
+			host:          private,
+			authorization: "Bearer secret",
+			referer:       "https://www.example.com/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clear(received)
+			if _, err := fetcher.Get(context.Background(), tt.url, nil); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			header := received[tt.host]
+			if header == nil {
+				t.Fatalf("Expected a request to %s", tt.host)
+			}
+			if got := header.Get("Authorization"); got != tt.authorization {
+				t.Errorf("Expected Authorization %q, got %q", tt.authorization, got)
+			}
+			if tt.referer != "" && header.Get("Referer") != tt.referer {
+				t.Errorf("Expected Referer %q, got %q", tt.referer, header.Get("Referer"))
+			}
+			if tt.referer == "" && header.Get("Referer") == "https://www.example.com/" {
+				t.Error("Expected the configured Referer not to be sent")
+			}
+			if header.Get("X-Api-Key") != "" {
+				t.Errorf("Expected no X-Api-Key, got %q", header.Get("X-Api-Key"))
+			}
+		})
+	}
+}
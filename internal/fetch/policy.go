@@ -0,0 +1,150 @@
+package fetch
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Policy restricts where URLs may be fetched from. Loopback, private,
+// link-local (including cloud metadata endpoints) and other non-public
+// addresses are always refused unless covered by AllowNets.
+type Policy struct {
+	// AllowHosts, if set, lists the only hosts that may be fetched from.
+	// Entries match a host exactly, or any subdomain when written as
+	// "*.example.com".
+	AllowHosts []string
+
+	// DenyHosts lists hosts that may never be fetched from, in the same form
+	DenyHosts []string
+
+	// AllowNets lists networks that may be fetched from even though they
+	// are not public, such as an internal image server
+	AllowNets []netip.Prefix
+}
+
+// reservedNets are non-public ranges not covered by the netip.Addr
+// predicates
+var reservedNets = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "This" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // Carrier-grade NAT, also used for some metadata services
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // Benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // Reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, which can reach private IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"), // Local-use NAT64
+}
+
+// checkURL checks the scheme and host of a URL against the policy. The
+// addresses a host resolves to are checked when connecting, so a DNS answer
+// that changes between the two checks cannot bypass them.
+func (p Policy) checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme must be http or https", ErrInvalidURL)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return fmt.Errorf("%w: missing host", ErrInvalidURL)
+	}
+
+	if matchesHost(p.DenyHosts, host) {
+		return fmt.Errorf("%w: host %s is denied", ErrForbiddenDestination, host)
+	}
+	if len(p.AllowHosts) > 0 && !matchesHost(p.AllowHosts, host) {
+		return fmt.Errorf("%w: host %s is not allowed", ErrForbiddenDestination, host)
+	}
+
+	// Literal addresses can be refused before connecting
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return p.checkAddr(addr)
+	}
+	return nil
+}
+
+// checkAddr checks an address about to be connected to
+func (p Policy) checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap()
+	for _, prefix := range p.AllowNets {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	if !publicAddr(addr) {
+		return fmt.Errorf("%w: %s is not a public address", ErrForbiddenDestination, addr)
+	}
+	return nil
+}
+
+// publicAddr reports whether addr is a globally routable unicast address
+func publicAddr(addr netip.Addr) bool {
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range reservedNets {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// control vets every connection after DNS resolution and before it is made
+func (p Policy) control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrForbiddenDestination, err)
+	}
+	return p.checkAddr(addrPort.Addr())
+}
+
+// redirectChecker vets every redirect hop like the original URL and stops
+// after maxRedirects hops
+func (p Policy) redirectChecker(maxRedirects int) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return fmt.Errorf("%w: more than %d redirects", ErrTooManyRedirects, maxRedirects)
+		}
+		return p.checkURL(req.URL)
+	}
+}
+
+// NewClient returns an HTTP client that only connects to destinations the
+// policy allows, for requests to caller-supplied URLs other than image
+// fetches, such as webhooks. Redirects are vetted like the original URL and
+// limited to maxRedirects hops.
+func (p Policy) NewClient(timeout time.Duration, maxRedirects int) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.control,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Proxies are not used since they would connect on our behalf
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Transport:     transport,
+		Timeout:       timeout,
+		CheckRedirect: p.redirectChecker(max(maxRedirects, 0)),
+	}
+}
+
+// matchesHost reports whether host matches one of patterns
+func matchesHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(pattern)), ".")
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckURLPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		url      string
+		expected error
+	}{
+		{name: "public host", url: "https://example.com/a.jpg"},
+		{name: "public address", url: "http://93.184.216.34/a.jpg"},
+		{name: "loopback", url: "http://127.0.0.1/a.jpg", expected: ErrForbiddenDestination},
+		{name: "loopback IPv6", url: "http://[::1]:8080/a.jpg", expected: ErrForbiddenDestination},
+		{name: "IPv4-mapped loopback", url: "http://[::ffff:127.0.0.1]/a.jpg", expected: ErrForbiddenDestination},
+		{name: "private", url: "http://10.0.0.5/a.jpg", expected: ErrForbiddenDestination},
+		{name: "private 192.168", url: "http://192.168.1.1/a.jpg", expected: ErrForbiddenDestination},
+		{name: "metadata", url: "http://169.254.169.254/latest/meta-data/", expected: ErrForbiddenDestination},
+		{name: "metadata IPv6", url: "http://[fd00:ec2::254]/latest/meta-data/", expected: ErrForbiddenDestination},
+		{name: "carrier-grade NAT", url: "http://100.100.100.200/latest/meta-data/", expected: ErrForbiddenDestination},
+		{name: "unspecified", url: "http://0.0.0.0/a.jpg", expected: ErrForbiddenDestination},
+		{name: "NAT64", url: "http://[64:ff9b::a00:1]/a.jpg", expected: ErrForbiddenDestination},
+		{
+			name:   "allowed network",
+			policy: Policy{AllowNets: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}},
+			url:    "http://10.1.2.3/a.jpg",
+		},
+		{
+			name:     "outside allowed network",
+			policy:   Policy{AllowNets: []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16")}},
+			url:      "http://10.2.0.1/a.jpg",
+			expected: ErrForbiddenDestination,
+		},
+		{
+			name:   "allowed host",
+			policy: Policy{AllowHosts: []string{"images.example.com"}},
+			url:    "https://Images.Example.com./a.jpg",
+		},
+		{
+			name:   "allowed subdomain",
+			policy: Policy{AllowHosts: []string{"*.example.com"}},
+			url:    "https://cdn.eu.example.com/a.jpg",
+		},
+		{
+			name:     "wildcard does not match apex",
+			policy:   Policy{AllowHosts: []string{"*.example.com"}},
+			url:      "https://example.com/a.jpg",
+			expected: ErrForbiddenDestination,
+		},
+		{
+			name:     "not allowed host",
+			policy:   Policy{AllowHosts: []string{"images.example.com"}},
+			url:      "https://evil.com/a.jpg",
+			expected: ErrForbiddenDestination,
+		},
+		{
+			name:     "suffix is not a subdomain",
+			policy:   Policy{AllowHosts: []string{"*.example.com"}},
+			url:      "https://evilexample.com/a.jpg",
+			expected: ErrForbiddenDestination,
+		},
+		{
+			name:     "denied host",
+			policy:   Policy{DenyHosts: []string{"*.internal"}},
+			url:      "http://metadata.google.internal/a.jpg",
+			expected: ErrForbiddenDestination,
+		},
+		{
+			name:     "deny wins over allow",
+			policy:   Policy{AllowHosts: []string{"*.example.com"}, DenyHosts: []string{"admin.example.com"}},
+			url:      "https://admin.example.com/a.jpg",
+			expected: ErrForbiddenDestination,
+		},
+		{name: "unsupported scheme", url: "ftp://example.com/a.jpg", expected: ErrInvalidURL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := New(Config{Policy: tt.policy}).CheckURL(tt.url)
+			if tt.expected == nil && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestGetBlocksResolvedAddresses(t *testing.T) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		writeImage(w)
+	}))
+	defer mockServer.Close()
+
+	// The host name passes validation; its address is refused when dialing
+	url := strings.Replace(mockServer.URL, "127.0.0.1", "localhost", 1) + "/image.jpg"
+	_, err := New(Config{}).Get(context.Background(), url, nil)
+	if !errors.Is(err, ErrForbiddenDestination) {
+		t.Errorf("Expected %v, got %v", ErrForbiddenDestination, err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected no request to reach the server, got %d", requests)
+	}
+}
+
+func TestGetValidatesRedirects(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/metadata":
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		case "/internal":
+			http.Redirect(w, r, "http://db.internal/dump", http.StatusFound)
+		case "/scheme":
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+		case "/local":
+			http.Redirect(w, r, "/image.jpg", http.StatusFound)
+		default:
+			writeImage(w)
+		}
+	}))
+	defer mockServer.Close()
+
+	fetcher := New(Config{Policy: Policy{
+		DenyHosts: []string{"*.internal"},
+		AllowNets: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+	}})
+
+	tests := []struct {
+		path     string
+		expected error
+	}{
+		{"/local", nil},
+		{"/metadata", ErrForbiddenDestination},
+		{"/internal", ErrForbiddenDestination},
+		{"/scheme", ErrInvalidURL},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			_, err := fetcher.Get(context.Background(), mockServer.URL+tt.path, nil)
+			if tt.expected == nil && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestPolicyClient(t *testing.T) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/metadata" {
+			http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+		}
+	}))
+	defer mockServer.Close()
+
+	// The test server is on loopback, so it is refused unless allowed
+	resp, err := Policy{}.NewClient(time.Second, 3).Post(mockServer.URL+"/hook", "application/json", nil)
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, ErrForbiddenDestination) || requests != 0 {
+		t.Errorf("Expected %v without a request, got %v after %d requests", ErrForbiddenDestination, err, requests)
+	}
+
+	client := Policy{AllowNets: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}.NewClient(time.Second, 3)
+	resp, err = client.Post(mockServer.URL+"/hook", "application/json", nil)
+	if err != nil {
+		t.Fatalf("Expected an allowed network to be reached, got %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = client.Get(mockServer.URL + "/metadata")
+	if err == nil {
+		resp.Body.Close()
+	}
+	if !errors.Is(err, ErrForbiddenDestination) {
+		t.Errorf("Expected redirect to be refused with %v, got %v", ErrForbiddenDestination, err)
+	}
+}
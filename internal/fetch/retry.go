@@ -0,0 +1,110 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryableStatuses are response statuses that may succeed if retried
+var retryableStatuses = map[int]bool{
+	http.StatusRequestTimeout:     true,
+	http.StatusTooManyRequests:    true,
+	http.StatusBadGateway:         true,
+	http.StatusServiceUnavailable: true,
+	http.StatusGatewayTimeout:     true,
+}
+
+// statusError is an unexpected response status
+type statusError struct {
+	code       int
+	retryAfter time.Duration // From the Retry-After header, if any
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%v: server returned status %d", ErrStatus, e.code)
+}
+
+// Is makes a statusError match ErrStatus
+func (e *statusError) Is(target error) bool {
+	return target == ErrStatus
+}
+
+// transient reports whether a failed request may succeed if retried. Such
+// failures also count against the host's circuit breaker.
+func transient(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return retryableStatuses[status.code]
+	}
+	for _, permanent := range []error{ErrInvalidURL, ErrForbiddenDestination, ErrTooManyRedirects, ErrTooLarge, ErrContentType, ErrCircuitOpen} {
+		if errors.Is(err, permanent) {
+			return false
+		}
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+
+	// Anything else failed connecting, or while sending or reading
+	return true
+}
+
+// retryDelay returns how long to wait before retry number attempt (from
+// 0), or false if the request should not be retried
+func (f *Fetcher) retryDelay(ctx context.Context, attempt int, err error) (time.Duration, bool) {
+	if attempt >= f.retries || ctx.Err() != nil || !transient(err) {
+		return 0, false
+	}
+
+	// Full jitter: a random delay up to an exponentially growing bound
+	bound := min(f.retryMaxDelay, f.retryBaseDelay<<attempt)
+	delay := time.Duration(rand.Int64N(int64(bound) + 1))
+
+	// The origin knows best when to come back, unless it is too far off
+	var status *statusError
+	if errors.As(err, &status) && status.retryAfter > 0 {
+		if status.retryAfter > f.retryMaxDelay {
+			return 0, false
+		}
+		delay = status.retryAfter
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+		return 0, false
+	}
+	return delay, true
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP
+// date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(t.Sub(now), 0)
+	}
+	return 0
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,234 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		retries      int
+		failures     int32
+		status       int
+		retryAfter   string
+		wantRequests int32
+		expected     error
+	}{
+		{name: "recovers", failures: 2, status: http.StatusBadGateway, wantRequests: 3},
+		{name: "gives up", failures: 3, status: http.StatusBadGateway, wantRequests: 3, expected: ErrStatus},
+		{name: "rate limited", failures: 1, status: http.StatusTooManyRequests, retryAfter: "0", wantRequests: 2},
+		{name: "retry after too long", failures: 1, status: http.StatusServiceUnavailable, retryAfter: "3600", wantRequests: 1, expected: ErrStatus},
+		{name: "not retryable", failures: 1, status: http.StatusNotFound, wantRequests: 1, expected: ErrStatus},
+		{name: "more retries", retries: 4, failures: 4, status: http.StatusGatewayTimeout, wantRequests: 5},
+		{name: "disabled", retries: -1, failures: 1, status: http.StatusBadGateway, wantRequests: 1, expected: ErrStatus},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) <= tt.failures {
+					if tt.retryAfter != "" {
+						w.Header().Set("Retry-After", tt.retryAfter)
+					}
+					w.WriteHeader(tt.status)
+					return
+				}
+				writeImage(w)
+			}))
+			defer mockServer.Close()
+
+			fetcher := New(Config{Retries: tt.retries, RetryBaseDelay: time.Millisecond, Policy: loopback})
+			_, err := fetcher.Get(context.Background(), mockServer.URL, nil)
+			if tt.expected == nil && err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+			if tt.expected != nil && !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+			if got := requests.Load(); got != tt.wantRequests {
+				t.Errorf("Expected %d requests, got %d", tt.wantRequests, got)
+			}
+			if got := fetcher.Stats().Retries; got != uint64(tt.wantRequests-1) {
+				t.Errorf("Expected %d retries, got %d", tt.wantRequests-1, got)
+			}
+		})
+	}
+}
+
+func TestGetRetriesConnectionErrors(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := mockServer.URL
+	mockServer.Close()
+
+	fetcher := New(Config{RetryBaseDelay: time.Millisecond, Policy: loopback})
+	if _, err := fetcher.Get(context.Background(), url, nil); err == nil {
+		t.Fatal("Expected an error from a closed server")
+	}
+	if got := fetcher.Stats().Retries; got != DefaultRetries {
+		t.Errorf("Expected %d retries, got %d", DefaultRetries, got)
+	}
+}
+
+func TestGetRetryStopsAtDeadline(t *testing.T) {
+	var requests atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := New(Config{Policy: loopback}).Get(ctx, mockServer.URL, nil)
+	if !errors.Is(err, ErrStatus) {
+		t.Errorf("Expected %v, got %v", ErrStatus, err)
+	}
+	if requests.Load() != 1 || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("Expected to give up at once, got %d requests in %s", requests.Load(), time.Since(start))
+	}
+}
+
+func TestGetCircuitBreaker(t *testing.T) {
+	var requests atomic.Int32
+	var healthy atomic.Bool
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		writeImage(w)
+	}))
+	defer mockServer.Close()
+
+	fetcher := New(Config{
+		Retries:          -1,
+		BreakerThreshold: 2,
+		BreakerCooldown:  50 * time.Millisecond,
+		Policy:           loopback,
+	})
+	get := func() error {
+		_, err := fetcher.Get(context.Background(), mockServer.URL, nil)
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := get(); !errors.Is(err, ErrStatus) {
+			t.Fatalf("Expected %v, got %v", ErrStatus, err)
+		}
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected %v, got %v", ErrCircuitOpen, err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("Expected the open breaker to stop requests, got %d", requests.Load())
+	}
+	stats := fetcher.Stats()
+	if stats.BreakerTrips != 1 || stats.BreakerRejections != 1 || stats.OpenBreakers != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// After the cooldown a failed probe opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	if err := get(); !errors.Is(err, ErrStatus) {
+		t.Fatalf("Expected the probe to reach the server, got %v", err)
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected %v, got %v", ErrCircuitOpen, err)
+	}
+
+	// A successful probe closes it
+	time.Sleep(60 * time.Millisecond)
+	healthy.Store(true)
+	for i := 0; i < 2; i++ {
+		if err := get(); err != nil {
+			t.Fatalf("Expected the breaker to close, got %v", err)
+		}
+	}
+	if stats := fetcher.Stats(); stats.BreakerTrips != 2 || stats.OpenBreakers != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestBreakersProbe(t *testing.T) {
+	b := newBreakers(1, time.Minute)
+	now := time.Now()
+
+	if err := b.allow("a", now); err != nil {
+		t.Fatalf("Expected a closed breaker, got %v", err)
+	}
+	b.done("a", failed, now)
+	if err := b.allow("a", now); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected %v, got %v", ErrCircuitOpen, err)
+	}
+	if err := b.allow("b", now); err != nil {
+		t.Errorf("Expected other hosts to be allowed, got %v", err)
+	}
+
+	// Only one probe is let through at a time
+	later := now.Add(time.Minute)
+	if err := b.allow("a", later); err != nil {
+		t.Fatalf("Expected a probe, got %v", err)
+	}
+	if err := b.allow("a", later); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected a second probe to be refused, got %v", err)
+	}
+
+	// An abandoned probe lets another one through
+	b.done("a", abandoned, later)
+	if err := b.allow("a", later); err != nil {
+		t.Errorf("Expected a new probe, got %v", err)
+	}
+}
+
+func TestBreakersForgetHosts(t *testing.T) {
+	b := newBreakers(2, time.Minute)
+	b.maxHosts = 3
+	now := time.Now()
+
+	// Idle hosts are forgotten
+	b.done("idle", failed, now)
+	now = now.Add(breakerIdleTimeout)
+	b.done("a", failed, now)
+	if _, ok := b.hosts["idle"]; ok || len(b.hosts) != 1 {
+		t.Errorf("Expected the idle host to be forgotten, got %d hosts", len(b.hosts))
+	}
+
+	// Once full, the least recently used host makes room
+	b.done("b", failed, now.Add(time.Second))
+	b.done("c", failed, now.Add(2*time.Second))
+	b.done("d", failed, now.Add(3*time.Second))
+	if _, ok := b.hosts["a"]; ok || len(b.hosts) != 3 {
+		t.Errorf("Expected %d hosts without a, got %v", b.maxHosts, b.hosts)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{now.Add(30 * time.Second).Format(http.TimeFormat), 30 * time.Second},
+		{now.Add(-time.Hour).Format(http.TimeFormat), 0},
+		{"soon", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, expected %s", tt.value, got, tt.want)
+		}
+	}
+}
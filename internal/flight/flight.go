@@ -0,0 +1,89 @@
+// Package flight coalesces concurrent identical work so that callers asking
+// for the same thing at the same time share one execution.
+package flight
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Group coalesces concurrent calls with the same key. The zero value is
+// ready to use.
+type Group[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*call[V]
+}
+
+// call is an execution in progress and the callers waiting for it
+type call[V any] struct {
+	done    chan struct{}
+	value   V
+	err     error
+	waiters int
+	dups    int
+	cancel  context.CancelFunc
+}
+
+// Do runs fn once for all concurrent callers with the same key and returns
+// its result to each of them. fn runs with a context carrying the values of
+// the first caller's ctx that is canceled only once every waiting caller has
+// given up, so one client going away does not fail the others. shared
+// reports whether the result went to more than one caller.
+func (g *Group[V]) Do(ctx context.Context, key string, fn func(context.Context) (V, error)) (value V, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*call[V]{}
+	}
+	c, ok := g.calls[key]
+	if ok {
+		c.waiters++
+		c.dups++
+	} else {
+		workCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[V]{done: make(chan struct{}), waiters: 1, cancel: cancel}
+		g.calls[key] = c
+		go g.run(workCtx, key, c, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.dups > 0, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// Nobody wants the result any more; later callers start afresh
+			c.cancel()
+			g.forget(key, c)
+		}
+		g.mu.Unlock()
+		var zero V
+		return zero, false, ctx.Err()
+	}
+}
+
+// run executes fn for c and publishes its result
+func (g *Group[V]) run(ctx context.Context, key string, c *call[V], fn func(context.Context) (V, error)) {
+	defer func() {
+		// A panic would otherwise take the whole process down
+		if r := recover(); r != nil {
+			c.err = fmt.Errorf("panic: %v", r)
+		}
+		g.mu.Lock()
+		g.forget(key, c)
+		g.mu.Unlock()
+		c.cancel()
+		close(c.done)
+	}()
+	c.value, c.err = fn(ctx)
+}
+
+// forget removes c from the in-flight calls unless it was already replaced.
+// The caller must hold g.mu.
+func (g *Group[V]) forget(key string, c *call[V]) {
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
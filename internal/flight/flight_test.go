@@ -0,0 +1,158 @@
+package flight
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoCoalesces(t *testing.T) {
+	var g Group[string]
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	const callers = 10
+	var started, finished sync.WaitGroup
+	results := make([]string, callers)
+	shared := make([]bool, callers)
+	for i := 0; i < callers; i++ {
+		started.Add(1)
+		finished.Add(1)
+		go func(i int) {
+			defer finished.Done()
+			started.Done()
+			results[i], shared[i], _ = g.Do(context.Background(), "key", func(context.Context) (string, error) {
+				calls.Add(1)
+				<-release
+				return "result", nil
+			})
+		}(i)
+	}
+	started.Wait()
+	waitForWaiters(t, &g, "key", callers)
+	close(release)
+	finished.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected 1 call, got %d", n)
+	}
+	for i := range results {
+		if results[i] != "result" || !shared[i] {
+			t.Errorf("Expected shared result, got %q (shared %t)", results[i], shared[i])
+		}
+	}
+}
+
+func TestDoRunsAgainAfterCompletion(t *testing.T) {
+	var g Group[int]
+	var calls int
+	for i := 1; i <= 3; i++ {
+		value, shared, err := g.Do(context.Background(), "key", func(context.Context) (int, error) {
+			calls++
+			return calls, nil
+		})
+		if err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+		if value != i || shared {
+			t.Errorf("Expected unshared %d, got %d (shared %t)", i, value, shared)
+		}
+	}
+}
+
+func TestDoSharesErrors(t *testing.T) {
+	var g Group[int]
+	errFailed := errors.New("failed")
+	_, _, err := g.Do(context.Background(), "key", func(context.Context) (int, error) {
+		return 0, errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Errorf("Expected %v, got %v", errFailed, err)
+	}
+}
+
+func TestDoRecoversPanics(t *testing.T) {
+	var g Group[int]
+	_, _, err := g.Do(context.Background(), "key", func(context.Context) (int, error) {
+		panic("boom")
+	})
+	if err == nil || err.Error() != "panic: boom" {
+		t.Errorf("Expected panic error, got %v", err)
+	}
+}
+
+func TestDoCancellation(t *testing.T) {
+	var g Group[string]
+	release := make(chan struct{})
+	workCanceled := make(chan struct{})
+	fn := func(ctx context.Context) (string, error) {
+		select {
+		case <-release:
+			return "result", nil
+		case <-ctx.Done():
+			close(workCanceled)
+			return "", ctx.Err()
+		}
+	}
+
+	// The first caller giving up leaves the work running for the second
+	firstCtx, cancelFirst := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, _, err := g.Do(firstCtx, "key", fn)
+		firstErr <- err
+	}()
+	waitForWaiters(t, &g, "key", 1)
+
+	secondCtx, cancelSecond := context.WithCancel(context.Background())
+	secondErr := make(chan error, 1)
+	go func() {
+		_, _, err := g.Do(secondCtx, "key", fn)
+		secondErr <- err
+	}()
+	waitForWaiters(t, &g, "key", 2)
+
+	cancelFirst()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected first caller canceled, got %v", err)
+	}
+	select {
+	case <-workCanceled:
+		t.Fatal("Expected work to continue while a caller waits")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The last caller giving up cancels the work
+	cancelSecond()
+	if err := <-secondErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected second caller canceled, got %v", err)
+	}
+	select {
+	case <-workCanceled:
+	case <-time.After(time.Second):
+		t.Fatal("Expected work to be canceled once every caller gave up")
+	}
+}
+
+// waitForWaiters blocks until n callers wait for key
+func waitForWaiters[V any](t *testing.T, g *Group[V], key string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		g.mu.Lock()
+		c, ok := g.calls[key]
+		waiters := 0
+		if ok {
+			waiters = c.waiters
+		}
+		g.mu.Unlock()
+		if waiters == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d waiters for %q", n, key)
+}
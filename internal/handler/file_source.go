@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/fetch"
+)
+
+// fileSource loads images from file:// URLs under a set of root
+// directories
+type fileSource struct {
+	roots    []fileRoot
+	maxBytes int64
+}
+
+// fileRoot is a directory images may be loaded from. Opening files through
+// os.Root keeps symlinks from escaping it.
+type fileRoot struct {
+	dir  string
+	root *os.Root
+}
+
+// NewFileSource creates a source for file:// URLs that only loads files
+// under the given directories, of at most maxBytes
+func NewFileSource(dirs []string, maxBytes int64) (Source, error) {
+	s := &fileSource{maxBytes: maxBytes}
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root directory %q: %w", dir, err)
+		}
+		root, err := os.OpenRoot(abs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open root directory: %w", err)
+		}
+		s.roots = append(s.roots, fileRoot{dir: abs, root: root})
+	}
+	return s, nil
+}
+
+// Check reports whether rawURL names a file under one of the roots
+func (s *fileSource) Check(rawURL string) error {
+	_, _, err := s.resolve(rawURL)
+	return err
+}
+
+// Load reads the file named by rawURL
+func (s *fileSource) Load(ctx context.Context, rawURL string) (*RemoteImage, error) {
+	root, name, err := s.resolve(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := root.root.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: not a regular file", ErrInvalidFileType)
+	}
+	if info.Size() > s.maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d", fetch.ErrTooLarge, info.Size(), s.maxBytes)
+	}
+
+	data, err := fetch.ReadAll(file, s.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrEmptyFile
+	}
+
+	contentType, _, _ := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(name)))
+	return &RemoteImage{Data: data, URL: rawURL, ContentType: contentType}, nil
+}
+
+// resolve finds the root a file:// URL is under, and the file's path
+// relative to it
+func (s *fileSource) resolve(rawURL string) (fileRoot, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fileRoot{}, "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return fileRoot{}, "", fmt.Errorf("%w: file URLs must not name a remote host", ErrInvalidURL)
+	}
+	if !filepath.IsAbs(u.Path) {
+		return fileRoot{}, "", fmt.Errorf("%w: file URLs must have an absolute path", ErrInvalidURL)
+	}
+
+	path := filepath.Clean(u.Path)
+	for _, root := range s.roots {
+		rel, err := filepath.Rel(root.dir, path)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		return root, rel, nil
+	}
+	return fileRoot{}, "", fmt.Errorf("%w: %s is not under an allowed directory", ErrForbiddenDestination, path)
+}
@@ -13,22 +13,22 @@ func TestGetImageFromUpload(t *testing.T) {
 	createMultipartRequest := func(fieldName, fileName string, fileContent []byte) (*http.Request, error) {
 		var requestBody bytes.Buffer
 		multipartWriter := multipart.NewWriter(&requestBody)
-		
+
 		fileWriter, err := multipartWriter.CreateFormFile(fieldName, fileName)
 		if err != nil {
 			return nil, err
 		}
-		
+
 		_, err = fileWriter.Write(fileContent)
 		if err != nil {
 			return nil, err
 		}
-		
+
 		err = multipartWriter.Close()
 		if err != nil {
 			return nil, err
 		}
-		
+
 		req := httptest.NewRequest("POST", "/upload", &requestBody)
 		req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
 		return req, nil
@@ -82,6 +82,63 @@ func TestGetImageFromUpload(t *testing.T) {
 	}
 }
 
+func TestGetImagesFromUpload(t *testing.T) {
+	var requestBody bytes.Buffer
+	multipartWriter := multipart.NewWriter(&requestBody)
+	for _, name := range []string{"one.jpg", "two.png", "three.txt"} {
+		fileWriter, err := multipartWriter.CreateFormFile("images", name)
+		if err != nil {
+			t.Fatalf("Failed to create form file: %v", err)
+		}
+		fileWriter.Write([]byte("data for " + name))
+	}
+	multipartWriter.Close()
+
+	req := httptest.NewRequest("POST", "/process/batch", &requestBody)
+	req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+
+	images, err := NewImageHandler().GetImagesFromUpload(req, "images")
+	if err != nil {
+		t.Fatalf("GetImagesFromUpload() error = %v", err)
+	}
+
+	if len(images) != 3 {
+		t.Fatalf("Expected 3 files, got %d", len(images))
+	}
+	// Files are returned in upload order, without type validation
+	if images[2].Filename != "three.txt" || string(images[2].Data) != "data for three.txt" {
+		t.Errorf("Unexpected third file: %s %q", images[2].Filename, images[2].Data)
+	}
+}
+
+func TestGetArchiveFromUpload(t *testing.T) {
+	createRequest := func(fileName string) *http.Request {
+		var requestBody bytes.Buffer
+		multipartWriter := multipart.NewWriter(&requestBody)
+		fileWriter, _ := multipartWriter.CreateFormFile("archive", fileName)
+		fileWriter.Write([]byte("PK archive bytes"))
+		multipartWriter.Close()
+
+		req := httptest.NewRequest("POST", "/process/zip", &requestBody)
+		req.Header.Set("Content-Type", multipartWriter.FormDataContentType())
+		return req
+	}
+
+	handler := NewImageHandler()
+
+	data, err := handler.GetArchiveFromUpload(createRequest("images.ZIP"), "archive")
+	if err != nil {
+		t.Fatalf("GetArchiveFromUpload() error = %v", err)
+	}
+	if string(data) != "PK archive bytes" {
+		t.Errorf("GetArchiveFromUpload() got unexpected data")
+	}
+
+	if _, err := handler.GetArchiveFromUpload(createRequest("images.jpg"), "archive"); err == nil {
+		t.Error("Expected error for non-ZIP upload")
+	}
+}
+
 func TestValidateFileType(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -135,4 +192,4 @@ func TestValidateFileType(t *testing.T) {
 			}
 		})
 	}
-} 
\ No newline at end of file
+}
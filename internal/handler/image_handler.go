@@ -1,134 +1,310 @@
 package handler
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/cache"
+	"github.com/Mark-Life/smart-webp-resize/internal/fetch"
+	"github.com/Mark-Life/smart-webp-resize/internal/flight"
+	"github.com/Mark-Life/smart-webp-resize/internal/tracing"
 )
 
 // Common errors
 var (
-	ErrEmptyURL          = errors.New("URL cannot be empty")
-	ErrInvalidURL        = errors.New("invalid URL format")
-	ErrHTTPRequestFailed = errors.New("HTTP request failed")
-	ErrEmptyFile         = errors.New("file content is empty")
-	ErrInvalidFileType   = errors.New("invalid file type")
-	ErrNoFile            = errors.New("no file found in request")
+	ErrEmptyURL             = errors.New("URL cannot be empty")
+	ErrInvalidURL           = fetch.ErrInvalidURL
+	ErrForbiddenDestination = fetch.ErrForbiddenDestination
+	ErrHTTPRequestFailed    = errors.New("HTTP request failed")
+	ErrEmptyFile            = errors.New("file content is empty")
+	ErrInvalidFileType      = errors.New("invalid file type")
+	ErrNoFile               = errors.New("no file found in request")
 )
 
 // ImageHandler handles image input from different sources
 type ImageHandler interface {
 	// GetImageFromURL fetches an image from a URL
-	GetImageFromURL(url string) ([]byte, error)
-	
+	GetImageFromURL(ctx context.Context, url string) ([]byte, error)
+
+	// FetchImage fetches an image from a URL, reporting where it was
+	// finally found and its content type
+	FetchImage(ctx context.Context, url string) (*RemoteImage, error)
+
 	// ValidateURL checks if a URL is valid
 	ValidateURL(url string) error
-	
+
 	// GetImageFromUpload extracts an image from an HTTP file upload
 	GetImageFromUpload(r *http.Request, fieldName string) ([]byte, error)
-	
+
+	// GetArchiveFromUpload extracts a ZIP archive from an HTTP file upload
+	GetArchiveFromUpload(r *http.Request, fieldName string) ([]byte, error)
+
+	// GetImagesFromUpload extracts every file uploaded under a form field
+	GetImagesFromUpload(r *http.Request, fieldName string) ([]UploadedImage, error)
+
 	// ValidateFileType checks if the file has a valid image extension
 	ValidateFileType(fileName string) error
 }
 
-// NewImageHandler creates a new image handler
-func NewImageHandler() ImageHandler {
-	return &defaultImageHandler{}
+// UploadedImage is a file received in a multipart upload
+type UploadedImage struct {
+	Filename string
+	Data     []byte
+}
+
+// RemoteImage is an image fetched from a URL. The data may be shared and
+// must not be modified.
+type RemoteImage struct {
+	Data        []byte
+	URL         string // Final URL after redirects
+	ContentType string // Media type reported by the origin, if any
+}
+
+// Option configures an image handler created by NewImageHandler
+type Option func(*defaultImageHandler)
+
+// WithFetcher sets the fetcher used to download images, and with it the
+// limits and destination policy applied to URLs
+func WithFetcher(f *fetch.Fetcher) Option {
+	return func(h *defaultImageHandler) {
+		h.fetcher = f
+	}
+}
+
+// WithSourceCache keeps downloaded images so that unchanged sources are not
+// downloaded again
+func WithSourceCache(c *cache.SourceCache) Option {
+	return func(h *defaultImageHandler) {
+		h.sourceCache = c
+	}
+}
+
+// NewImageHandler creates a new image handler. HTTP, HTTPS and data URLs
+// are supported unless replaced with WithSource; other schemes must be
+// added with WithSource.
+func NewImageHandler(opts ...Option) ImageHandler {
+	h := &defaultImageHandler{}
+	for _, opt := range opts {
+		opt(h)
+	}
+	if h.fetcher == nil {
+		h.fetcher = fetch.New(fetch.Config{})
+	}
+	if h.sources == nil {
+		h.sources = map[string]Source{}
+	}
+	defaults := map[string]Source{
+		"http":  &httpSource{fetcher: h.fetcher, cache: h.sourceCache},
+		"https": &httpSource{fetcher: h.fetcher, cache: h.sourceCache},
+		"data":  dataSource{maxBytes: h.fetcher.MaxBytes()},
+	}
+	for scheme, source := range defaults {
+		if _, ok := h.sources[scheme]; !ok {
+			h.sources[scheme] = source
+		}
+	}
+	return h
 }
 
 // defaultImageHandler is the default implementation of ImageHandler
-type defaultImageHandler struct{}
+type defaultImageHandler struct {
+	fetcher     *fetch.Fetcher
+	sourceCache *cache.SourceCache
+	sources     map[string]Source // By URL scheme
+	downloads   flight.Group[*RemoteImage]
+}
 
 // GetImageFromURL fetches an image from a URL
-func (h *defaultImageHandler) GetImageFromURL(imageURL string) ([]byte, error) {
-	if err := h.ValidateURL(imageURL); err != nil {
+func (h *defaultImageHandler) GetImageFromURL(ctx context.Context, imageURL string) ([]byte, error) {
+	image, err := h.FetchImage(ctx, imageURL)
+	if err != nil {
 		return nil, err
 	}
-	
-	// Create HTTP client with sensible defaults
-	client := &http.Client{
-		Timeout: http.DefaultClient.Timeout,
-	}
-	
-	// Make the request
-	resp, err := client.Get(imageURL)
+	return image.Data, nil
+}
+
+// FetchImage fetches an image from a URL, reporting where it was finally
+// found and its content type
+func (h *defaultImageHandler) FetchImage(ctx context.Context, imageURL string) (image *RemoteImage, err error) {
+	ctx, span := tracing.Start(ctx, "fetch",
+		attribute.String("url.full", DescribeURL(imageURL)),
+		attribute.String("url.scheme", schemeOf(imageURL)),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	source, err := h.source(imageURL)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrHTTPRequestFailed, err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-	
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("%w: server returned status %d", ErrHTTPRequestFailed, resp.StatusCode)
+
+	// Concurrent requests for the same URL share one download
+	image, shared, err := h.downloads.Do(ctx, imageURL, func(ctx context.Context) (*RemoteImage, error) {
+		return source.Load(ctx, imageURL)
+	})
+	span.SetAttributes(attribute.Bool("fetch.coalesced", shared))
+	return image, err
+}
+
+// httpSource downloads images over HTTP and HTTPS
+type httpSource struct {
+	fetcher *fetch.Fetcher
+	cache   *cache.SourceCache
+}
+
+// Check checks the scheme and that the destination may be fetched from
+func (s *httpSource) Check(rawURL string) error {
+	return s.fetcher.CheckURL(rawURL)
+}
+
+// Load fetches an image, reusing the cached copy while the origin confirms
+// it is current. Details are recorded on the span in ctx.
+func (s *httpSource) Load(ctx context.Context, imageURL string) (*RemoteImage, error) {
+	span := trace.SpanFromContext(ctx)
+
+	// Use a cached copy while the origin says it is fresh
+	cached, ok := s.cache.Get(imageURL)
+	if ok && cached.Fresh(time.Now()) {
+		span.SetAttributes(attribute.String("source_cache", "fresh"))
+		return remoteImage(cached), nil
 	}
-	
-	// Read the response body
-	imageData, err := io.ReadAll(resp.Body)
+
+	// Ask the origin whether a stale cached copy is still current
+	header := http.Header{}
+	if ok {
+		setValidators(header, cached)
+	}
+
+	resp, err := s.fetcher.Get(ctx, imageURL, header)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("%w: %w", ErrHTTPRequestFailed, err)
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		if !ok {
+			return nil, fmt.Errorf("%w: server returned status %d", ErrHTTPRequestFailed, resp.StatusCode)
+		}
+		span.SetAttributes(attribute.String("source_cache", "revalidated"))
+		s.store(imageURL, revalidated(cached, resp.Header, time.Now()))
+		return remoteImage(cached), nil
 	}
-	
-	if len(imageData) == 0 {
+	span.SetAttributes(attribute.String("source_cache", "miss"))
+
+	if len(resp.Data) == 0 {
 		return nil, ErrEmptyFile
 	}
-	
-	return imageData, nil
+
+	image := &RemoteImage{Data: resp.Data, URL: resp.URL, ContentType: resp.ContentType}
+	s.store(imageURL, newSource(image, resp.Header, time.Now()))
+	return image, nil
 }
 
 // ValidateURL checks if a URL is valid
 func (h *defaultImageHandler) ValidateURL(urlStr string) error {
-	if urlStr == "" {
-		return ErrEmptyURL
+	_, err := h.source(urlStr)
+	return err
+}
+
+// source returns the source that loads rawURL after checking that it may
+// be loaded
+func (h *defaultImageHandler) source(rawURL string) (Source, error) {
+	if rawURL == "" {
+		return nil, ErrEmptyURL
 	}
-	
-	parsedURL, err := url.ParseRequestURI(urlStr)
-	if err != nil {
-		return fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	scheme := schemeOf(rawURL)
+	source, ok := h.sources[scheme]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported scheme %q", ErrInvalidURL, scheme)
 	}
-	
-	// Check if the URL has a scheme (http:// or https://)
-	if parsedURL.Scheme != "http" && parsedURL.Scheme != "https" {
-		return fmt.Errorf("%w: scheme must be http or https", ErrInvalidURL)
+	if err := source.Check(rawURL); err != nil {
+		return nil, err
 	}
-	
-	return nil
+	return source, nil
 }
 
 // GetImageFromUpload extracts an image from an HTTP file upload
 func (h *defaultImageHandler) GetImageFromUpload(r *http.Request, fieldName string) ([]byte, error) {
+	return h.readUpload(r, fieldName, h.ValidateFileType)
+}
+
+// GetArchiveFromUpload extracts a ZIP archive from an HTTP file upload
+func (h *defaultImageHandler) GetArchiveFromUpload(r *http.Request, fieldName string) ([]byte, error) {
+	return h.readUpload(r, fieldName, validateArchiveType)
+}
+
+// readUpload reads a single uploaded file after validating its name
+func (h *defaultImageHandler) readUpload(r *http.Request, fieldName string, validate func(string) error) ([]byte, error) {
 	// Parse the multipart form, with a reasonable max memory
 	err := r.ParseMultipartForm(32 << 20) // 32MB max memory
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse multipart form: %w", err)
 	}
-	
+
 	// Get the file from the form
 	file, header, err := r.FormFile(fieldName)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrNoFile, err)
 	}
 	defer file.Close()
-	
+
 	// Validate the file type
-	if err := h.ValidateFileType(header.Filename); err != nil {
+	if err := validate(header.Filename); err != nil {
 		return nil, err
 	}
-	
+
 	// Read the file
-	imageData, err := io.ReadAll(file)
+	data, err := io.ReadAll(file)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
-	
-	if len(imageData) == 0 {
+
+	if len(data) == 0 {
 		return nil, ErrEmptyFile
 	}
-	
-	return imageData, nil
+
+	return data, nil
+}
+
+// validateArchiveType checks that an uploaded archive has a .zip extension
+func validateArchiveType(fileName string) error {
+	if ext := strings.ToLower(filepath.Ext(fileName)); ext != ".zip" {
+		return fmt.Errorf("%w: expected a .zip archive", ErrInvalidFileType)
+	}
+	return nil
+}
+
+// GetImagesFromUpload extracts every file uploaded under a form field. File
+// types are not validated so that callers can report problems per file.
+func (h *defaultImageHandler) GetImagesFromUpload(r *http.Request, fieldName string) ([]UploadedImage, error) {
+	// Parse the multipart form, with a reasonable max memory
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return nil, fmt.Errorf("failed to parse multipart form: %w", err)
+	}
+
+	headers := r.MultipartForm.File[fieldName]
+	images := make([]UploadedImage, 0, len(headers))
+	for _, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", header.Filename, err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", header.Filename, err)
+		}
+		images = append(images, UploadedImage{Filename: header.Filename, Data: data})
+	}
+
+	return images, nil
 }
 
 // ValidateFileType checks if the file has a valid image extension
@@ -136,12 +312,12 @@ func (h *defaultImageHandler) ValidateFileType(fileName string) error {
 	if fileName == "" {
 		return fmt.Errorf("%w: filename is empty", ErrInvalidFileType)
 	}
-	
+
 	ext := strings.ToLower(filepath.Ext(fileName))
 	if ext == "" {
 		return fmt.Errorf("%w: file has no extension", ErrInvalidFileType)
 	}
-	
+
 	// List of supported image formats
 	validExtensions := map[string]bool{
 		".jpg":  true,
@@ -151,10 +327,10 @@ func (h *defaultImageHandler) ValidateFileType(fileName string) error {
 		".bmp":  true,
 		".tiff": true,
 	}
-	
+
 	if !validExtensions[ext] {
 		return fmt.Errorf("%w: extension %s is not supported", ErrInvalidFileType, ext)
 	}
-	
+
 	return nil
-} 
\ No newline at end of file
+}
@@ -1,11 +1,29 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/fetch"
 )
 
+// allowLoopback lets handlers fetch from httptest servers
+var allowLoopback = WithFetcher(fetch.New(fetch.Config{Policy: fetch.Policy{AllowNets: []netip.Prefix{
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("::1/128"),
+}}}))
+
 func TestGetImageFromURL(t *testing.T) {
 	// Create a mock server to return test images
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -59,11 +77,11 @@ func TestGetImageFromURL(t *testing.T) {
 		},
 	}
 
-	handler := NewImageHandler()
+	handler := NewImageHandler(allowLoopback)
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			data, err := handler.GetImageFromURL(tt.url)
+			data, err := handler.GetImageFromURL(context.Background(), tt.url)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetImageFromURL() error = %v, wantErr %v", err, tt.wantErr)
@@ -120,4 +138,94 @@ func TestValidateURL(t *testing.T) {
 			}
 		})
 	}
-} 
\ No newline at end of file
+}
+
+func TestGetImageFromURLPropagatesTraceContext(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	var traceparent string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("mock image data"))
+	}))
+	defer mockServer.Close()
+
+	provider := sdktrace.NewTracerProvider()
+	ctx, span := provider.Tracer("test").Start(context.Background(), "request")
+	defer span.End()
+
+	if _, err := NewImageHandler(allowLoopback).GetImageFromURL(ctx, mockServer.URL+"/image.jpg"); err != nil {
+		t.Fatalf("GetImageFromURL() error = %v", err)
+	}
+
+	if !strings.Contains(traceparent, span.SpanContext().TraceID().String()) {
+		t.Errorf("Expected traceparent with trace ID %s, got %q", span.SpanContext().TraceID(), traceparent)
+	}
+}
+
+func TestGetImageFromURLCoalescesConcurrentRequests(t *testing.T) {
+	var requests atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// Give the other requests time to join
+		time.Sleep(50 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("mock image data"))
+	}))
+	defer mockServer.Close()
+
+	handler := NewImageHandler(allowLoopback)
+	const callers = 8
+	var ready, done sync.WaitGroup
+	ready.Add(callers)
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			ready.Done()
+			ready.Wait()
+			data, err := handler.GetImageFromURL(context.Background(), mockServer.URL+"/image.jpg")
+			if err == nil && string(data) != "mock image data" {
+				t.Errorf("Expected mock image data, got %q", data)
+			}
+			errs <- err
+		}()
+	}
+	done.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("GetImageFromURL() error = %v", err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Expected 1 request to the origin, got %d", n)
+	}
+}
+
+func TestFetchImageReportsSource(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old.png" {
+			http.Redirect(w, r, "/new.png", http.StatusMovedPermanently)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png; charset=binary")
+		w.Write([]byte("mock image data"))
+	}))
+	defer mockServer.Close()
+
+	image, err := NewImageHandler(allowLoopback).FetchImage(context.Background(), mockServer.URL+"/old.png")
+	if err != nil {
+		t.Fatalf("FetchImage() error = %v", err)
+	}
+	if image.URL != mockServer.URL+"/new.png" {
+		t.Errorf("Expected final URL %s, got %s", mockServer.URL+"/new.png", image.URL)
+	}
+	if image.ContentType != "image/png" {
+		t.Errorf("Expected content type image/png, got %s", image.ContentType)
+	}
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/fetch"
+	"github.com/Mark-Life/smart-webp-resize/internal/storage"
+)
+
+// s3Source loads images from s3://bucket/key URLs in a set of buckets of
+// an S3-compatible service
+type s3Source struct {
+	buckets  map[string]*storage.S3
+	maxBytes int64
+}
+
+// NewS3Source creates a source for s3:// URLs that only loads objects of at
+// most maxBytes from the given buckets. cfg configures the service and
+// credentials; its Bucket is ignored.
+func NewS3Source(cfg storage.S3Config, buckets []string, maxBytes int64) (Source, error) {
+	s := &s3Source{buckets: map[string]*storage.S3{}, maxBytes: maxBytes}
+	for _, bucket := range buckets {
+		cfg.Bucket = bucket
+		backend, err := storage.NewS3(cfg)
+		if err != nil {
+			return nil, err
+		}
+		s.buckets[bucket] = backend
+	}
+	return s, nil
+}
+
+// Check reports whether rawURL names an object in one of the buckets
+func (s *s3Source) Check(rawURL string) error {
+	_, _, err := s.resolve(rawURL)
+	return err
+}
+
+// Load downloads the object named by rawURL
+func (s *s3Source) Load(ctx context.Context, rawURL string) (*RemoteImage, error) {
+	backend, key, err := s.resolve(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := backend.Open(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	defer object.Body.Close()
+	if object.Size > s.maxBytes {
+		return nil, fmt.Errorf("%w: %d bytes exceeds the limit of %d", fetch.ErrTooLarge, object.Size, s.maxBytes)
+	}
+
+	data, err := fetch.ReadAll(object.Body, s.maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, ErrEmptyFile
+	}
+
+	contentType, _, _ := mime.ParseMediaType(object.ContentType)
+	return &RemoteImage{Data: data, URL: rawURL, ContentType: contentType}, nil
+}
+
+// resolve finds the bucket and key of an s3:// URL
+func (s *s3Source) resolve(rawURL string) (*storage.S3, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, "", fmt.Errorf("%w: expected s3://bucket/key", ErrInvalidURL)
+	}
+
+	backend, ok := s.buckets[u.Host]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: bucket %s is not allowed", ErrForbiddenDestination, u.Host)
+	}
+	return backend, key, nil
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/fetch"
+)
+
+// Source loads images from the URLs of one scheme
+type Source interface {
+	// Check reports whether rawURL may be loaded, without loading it
+	Check(rawURL string) error
+
+	// Load loads the image at rawURL
+	Load(ctx context.Context, rawURL string) (*RemoteImage, error)
+}
+
+// WithSource loads URLs with the given scheme, such as "s3" or "file", from
+// source. It replaces any source already registered for the scheme.
+func WithSource(scheme string, source Source) Option {
+	return func(h *defaultImageHandler) {
+		if h.sources == nil {
+			h.sources = map[string]Source{}
+		}
+		h.sources[strings.ToLower(scheme)] = source
+	}
+}
+
+// schemeOf returns the lower-cased scheme of rawURL, or "" if it has none
+func schemeOf(rawURL string) string {
+	scheme, _, ok := strings.Cut(rawURL, ":")
+	if !ok {
+		return ""
+	}
+	return strings.ToLower(scheme)
+}
+
+// DescribeURL returns rawURL in a form suitable for logs and traces. Data
+// URIs are shortened to their media type, since they hold the whole image,
+// and passwords in URLs are redacted.
+func DescribeURL(rawURL string) string {
+	if schemeOf(rawURL) != "data" {
+		if u, err := url.Parse(rawURL); err == nil {
+			return u.Redacted()
+		}
+		return rawURL
+	}
+	header, _, _ := strings.Cut(rawURL, ",")
+	return fmt.Sprintf("%s,... (%d bytes)", header, len(rawURL))
+}
+
+// dataSource loads images embedded in data URIs, such as
+// data:image/png;base64,iVBORw0KGgo...
+type dataSource struct {
+	maxBytes int64
+}
+
+// Check validates the header of a data URI and that its data is within
+// the size limit
+func (s dataSource) Check(rawURL string) error {
+	_, _, err := s.parse(rawURL)
+	return err
+}
+
+// Load decodes the image held by a data URI
+func (s dataSource) Load(ctx context.Context, rawURL string) (*RemoteImage, error) {
+	mediaType, payload, err := s.parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := base64.StdEncoding
+	if !strings.HasSuffix(payload, "=") {
+		encoding = base64.RawStdEncoding
+	}
+	data, err := encoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid base64 data: %v", ErrInvalidURL, err)
+	}
+	if len(data) == 0 {
+		return nil, ErrEmptyFile
+	}
+	if int64(len(data)) > s.maxBytes {
+		return nil, fmt.Errorf("%w: data exceeds the limit of %d bytes", fetch.ErrTooLarge, s.maxBytes)
+	}
+	return &RemoteImage{Data: data, ContentType: mediaType}, nil
+}
+
+// parse splits a data URI into its media type and base64 payload
+func (s dataSource) parse(rawURL string) (mediaType, payload string, err error) {
+	header, payload, ok := strings.Cut(rawURL[len("data:"):], ",")
+	if !ok {
+		return "", "", fmt.Errorf("%w: data URI has no data", ErrInvalidURL)
+	}
+
+	params := strings.Split(header, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(params[0]))
+	if !strings.HasPrefix(mediaType, "image/") {
+		return "", "", fmt.Errorf("%w: data URI must have an image media type", ErrInvalidURL)
+	}
+	if !strings.EqualFold(params[len(params)-1], "base64") {
+		return "", "", fmt.Errorf("%w: data URI must be base64 encoded", ErrInvalidURL)
+	}
+
+	if size := int64(base64.StdEncoding.DecodedLen(len(payload))); size > s.maxBytes+2 {
+		return "", "", fmt.Errorf("%w: data exceeds the limit of %d bytes", fetch.ErrTooLarge, s.maxBytes)
+	}
+	return mediaType, payload, nil
+}
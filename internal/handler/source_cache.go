@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/cache"
+)
+
+// setValidators makes a request conditional on the cached source having
+// changed
+func setValidators(header http.Header, cached *cache.Source) {
+	if cached.ETag != "" {
+		header.Set("If-None-Match", cached.ETag)
+	}
+	if cached.LastModified != "" {
+		header.Set("If-Modified-Since", cached.LastModified)
+	}
+}
+
+// newSource builds the cache entry for a downloaded image, or returns nil if
+// the origin's response may not be reused
+func newSource(image *RemoteImage, header http.Header, now time.Time) *cache.Source {
+	freshUntil, storable := freshness(header, now)
+	if !storable {
+		return nil
+	}
+	source := &cache.Source{
+		Data:         image.Data,
+		URL:          image.URL,
+		ContentType:  image.ContentType,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		FreshUntil:   freshUntil,
+	}
+	if !source.Fresh(now) && !source.Validatable() {
+		return nil
+	}
+	return source
+}
+
+// revalidated refreshes a cached source after the origin confirmed it is
+// unchanged. A 304 carries the current caching headers, and may carry
+// updated validators.
+func revalidated(cached *cache.Source, header http.Header, now time.Time) *cache.Source {
+	merged := header.Clone()
+	if merged.Get("ETag") == "" {
+		merged.Set("ETag", cached.ETag)
+	}
+	if merged.Get("Last-Modified") == "" {
+		merged.Set("Last-Modified", cached.LastModified)
+	}
+	return newSource(remoteImage(cached), merged, now)
+}
+
+// remoteImage returns the image held by a cached source
+func remoteImage(source *cache.Source) *RemoteImage {
+	return &RemoteImage{Data: source.Data, URL: source.URL, ContentType: source.ContentType}
+}
+
+// store caches source for imageURL, or forgets imageURL if source is nil
+func (s *httpSource) store(imageURL string, source *cache.Source) {
+	if source == nil {
+		s.cache.Delete(imageURL)
+		return
+	}
+	s.cache.Put(imageURL, source)
+}
+
+// freshness reads how long a response may be used without revalidation from
+// its Cache-Control, Age and Expires headers. storable is false if the
+// origin forbids keeping the response, or marks it private: the cache is
+// shared by every client of the service.
+func freshness(header http.Header, now time.Time) (freshUntil time.Time, storable bool) {
+	directives := parseCacheControl(header.Values("Cache-Control"))
+	for _, name := range []string{"no-store", "private"} {
+		if _, ok := directives[name]; ok {
+			return time.Time{}, false
+		}
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return now, true
+	}
+
+	// s-maxage applies to shared caches and takes precedence over max-age
+	for _, name := range []string{"s-maxage", "max-age"} {
+		value, ok := directives[name]
+		if !ok {
+			continue
+		}
+		maxAge, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || maxAge <= 0 {
+			return now, true
+		}
+		age, _ := strconv.ParseInt(header.Get("Age"), 10, 64)
+		return now.Add(time.Duration(maxAge-max(age, 0)) * time.Second), true
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return now, true
+		}
+		return t, true
+	}
+	return now, true
+}
+
+// parseCacheControl splits Cache-Control header values into lower-cased
+// directives and their unquoted arguments
+func parseCacheControl(values []string) map[string]string {
+	directives := map[string]string{}
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
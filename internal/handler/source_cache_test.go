@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/cache"
+)
+
+// originServer serves a single image with the given caching headers,
+// answering conditional requests with 304 when the validators match
+type originServer struct {
+	*httptest.Server
+	header      http.Header
+	body        string
+	requests    int
+	downloads   int
+	conditional int
+}
+
+func newOriginServer(header http.Header, body string) *originServer {
+	o := &originServer{header: header, body: body}
+	o.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o.requests++
+		for name, values := range o.header {
+			w.Header()[name] = values
+		}
+
+		inm, ims := r.Header.Get("If-None-Match"), r.Header.Get("If-Modified-Since")
+		if inm != "" || ims != "" {
+			o.conditional++
+		}
+		if (inm != "" && inm == o.header.Get("ETag")) || (ims != "" && ims == o.header.Get("Last-Modified")) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		o.downloads++
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte(o.body))
+	}))
+	return o
+}
+
+func TestGetImageFromURLSourceCache(t *testing.T) {
+	lastModified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Format(http.TimeFormat)
+
+	tests := []struct {
+		name            string
+		header          http.Header
+		wantRequests    int
+		wantDownloads   int
+		wantConditional int
+	}{
+		{
+			name:          "fresh by max-age",
+			header:        http.Header{"Cache-Control": {"public, max-age=3600"}},
+			wantRequests:  1,
+			wantDownloads: 1,
+		},
+		{
+			name:          "fresh by s-maxage",
+			header:        http.Header{"Cache-Control": {"max-age=0, s-maxage=3600"}},
+			wantRequests:  1,
+			wantDownloads: 1,
+		},
+		{
+			name:          "fresh by expires",
+			header:        http.Header{"Expires": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+			wantRequests:  1,
+			wantDownloads: 1,
+		},
+		{
+			name:            "revalidated by etag",
+			header:          http.Header{"Etag": {`"v1"`}, "Cache-Control": {"no-cache"}},
+			wantRequests:    3,
+			wantDownloads:   1,
+			wantConditional: 2,
+		},
+		{
+			name:            "revalidated by last-modified once stale",
+			header:          http.Header{"Last-Modified": {lastModified}, "Cache-Control": {"max-age=60"}, "Age": {"120"}},
+			wantRequests:    3,
+			wantDownloads:   1,
+			wantConditional: 2,
+		},
+		{
+			name:          "no-store",
+			header:        http.Header{"Etag": {`"v1"`}, "Cache-Control": {"no-store, max-age=3600"}},
+			wantRequests:  3,
+			wantDownloads: 3,
+		},
+		{
+			name:          "no validators or lifetime",
+			header:        http.Header{},
+			wantRequests:  3,
+			wantDownloads: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			origin := newOriginServer(tt.header, "mock image data")
+			defer origin.Close()
+
+			h := NewImageHandler(allowLoopback, WithSourceCache(cache.NewSourceCache(1<<20)))
+			for i := 0; i < 3; i++ {
+				data, err := h.GetImageFromURL(context.Background(), origin.URL+"/image.jpg")
+				if err != nil {
+					t.Fatalf("GetImageFromURL() error = %v", err)
+				}
+				if string(data) != "mock image data" {
+					t.Errorf("Expected mock image data, got %q", data)
+				}
+			}
+
+			if origin.requests != tt.wantRequests {
+				t.Errorf("Expected %d requests, got %d", tt.wantRequests, origin.requests)
+			}
+			if origin.downloads != tt.wantDownloads {
+				t.Errorf("Expected %d downloads, got %d", tt.wantDownloads, origin.downloads)
+			}
+			if origin.conditional != tt.wantConditional {
+				t.Errorf("Expected %d conditional requests, got %d", tt.wantConditional, origin.conditional)
+			}
+		})
+	}
+}
+
+func TestGetImageFromURLSourceChanged(t *testing.T) {
+	origin := newOriginServer(http.Header{"Etag": {`"v1"`}, "Cache-Control": {"no-cache"}}, "first")
+	defer origin.Close()
+
+	h := NewImageHandler(allowLoopback, WithSourceCache(cache.NewSourceCache(1<<20)))
+	if _, err := h.GetImageFromURL(context.Background(), origin.URL); err != nil {
+		t.Fatalf("GetImageFromURL() error = %v", err)
+	}
+
+	origin.header.Set("Etag", `"v2"`)
+	origin.body = "second"
+	for _, want := range []string{"second", "second"} {
+		data, err := h.GetImageFromURL(context.Background(), origin.URL)
+		if err != nil {
+			t.Fatalf("GetImageFromURL() error = %v", err)
+		}
+		if string(data) != want {
+			t.Errorf("Expected %q, got %q", want, data)
+		}
+	}
+	if origin.downloads != 2 {
+		t.Errorf("Expected 2 downloads, got %d", origin.downloads)
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		header       http.Header
+		wantFresh    time.Duration
+		wantStorable bool
+	}{
+		{"none", http.Header{}, 0, true},
+		{"max-age", http.Header{"Cache-Control": {"max-age=600"}}, 10 * time.Minute, true},
+		{"quoted max-age", http.Header{"Cache-Control": {`max-age="600"`}}, 10 * time.Minute, true},
+		{"max-age minus age", http.Header{"Cache-Control": {"max-age=600"}, "Age": {"60"}}, 9 * time.Minute, true},
+		{"s-maxage wins", http.Header{"Cache-Control": {"max-age=600, s-maxage=60"}}, time.Minute, true},
+		{"split header", http.Header{"Cache-Control": {"public", "Max-Age=60"}}, time.Minute, true},
+		{"invalid max-age", http.Header{"Cache-Control": {"max-age=soon"}}, 0, true},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache, max-age=600"}}, 0, true},
+		{"no-store", http.Header{"Cache-Control": {"no-store"}}, 0, false},
+		{"private", http.Header{"Cache-Control": {"private, max-age=60"}}, 0, false},
+		{"expires", http.Header{"Expires": {now.Add(time.Hour).Format(http.TimeFormat)}}, time.Hour, true},
+		{"max-age over expires", http.Header{"Cache-Control": {"max-age=60"}, "Expires": {now.Add(time.Hour).Format(http.TimeFormat)}}, time.Minute, true},
+		{"invalid expires", http.Header{"Expires": {"0"}}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			freshUntil, storable := freshness(tt.header, now)
+			if storable != tt.wantStorable {
+				t.Errorf("Expected storable %t, got %t", tt.wantStorable, storable)
+			}
+			if storable && freshUntil.Sub(now) != tt.wantFresh {
+				t.Errorf("Expected fresh for %s, got %s", tt.wantFresh, freshUntil.Sub(now))
+			}
+		})
+	}
+}
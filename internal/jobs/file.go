@@ -0,0 +1,210 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileStore keeps jobs on disk so they survive restarts. Each job is stored
+// as <id>.json, with its output in <id>.out and the output description in
+// <id>.out.json.
+type FileStore struct {
+	dir string
+	ttl time.Duration
+
+	// mu serializes writes; readers see either the old or new file because
+	// writes go through a rename
+	mu sync.Mutex
+}
+
+// NewFileStore creates a file-backed store in dir whose jobs expire after ttl
+func NewFileStore(dir string, ttl time.Duration) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create job directory: %w", err)
+	}
+	return &FileStore{dir: dir, ttl: ttl}, nil
+}
+
+// Create implements Store
+func (s *FileStore) Create(ctx context.Context, job *Job) error {
+	prepare(job, s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeJSON(s.jobPath(job.ID), job)
+}
+
+// Get implements Store
+func (s *FileStore) Get(ctx context.Context, id string) (*Job, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+
+	var job Job
+	if err := s.readJSON(s.jobPath(id), &job); err != nil {
+		return nil, err
+	}
+	if time.Now().After(job.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return &job, nil
+}
+
+// Update implements Store
+func (s *FileStore) Update(ctx context.Context, job *Job) error {
+	if !validID(job.ID) {
+		return ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.jobPath(job.ID)); err != nil {
+		return ErrNotFound
+	}
+	job.UpdatedAt = time.Now().UTC()
+	return s.writeJSON(s.jobPath(job.ID), job)
+}
+
+// PutOutput implements Store
+func (s *FileStore) PutOutput(ctx context.Context, id string, output *Output) error {
+	if !validID(id) {
+		return ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := os.Stat(s.jobPath(id)); err != nil {
+		return ErrNotFound
+	}
+	if err := s.writeFile(s.outputPath(id), output.Data); err != nil {
+		return err
+	}
+	return s.writeJSON(s.outputPath(id)+".json", output)
+}
+
+// GetOutput implements Store
+func (s *FileStore) GetOutput(ctx context.Context, id string) (*Output, error) {
+	if _, err := s.Get(ctx, id); err != nil {
+		return nil, err
+	}
+
+	var output Output
+	if err := s.readJSON(s.outputPath(id)+".json", &output); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrNoOutput
+		}
+		return nil, err
+	}
+
+	data, err := os.ReadFile(s.outputPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job output: %w", err)
+	}
+	output.Data = data
+	return &output, nil
+}
+
+// DeleteExpired implements Store
+func (s *FileStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		if !validID(id) {
+			continue // output descriptions and foreign files
+		}
+
+		var job Job
+		if err := s.readJSON(path, &job); err != nil || !now.After(job.ExpiresAt) {
+			continue
+		}
+
+		for _, p := range []string{path, s.outputPath(id), s.outputPath(id) + ".json"} {
+			if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return removed, err
+			}
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// jobPath returns where a job's state is stored
+func (s *FileStore) jobPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// outputPath returns where a job's output file is stored
+func (s *FileStore) outputPath(id string) string {
+	return filepath.Join(s.dir, id+".out")
+}
+
+// writeJSON atomically writes v as JSON to path
+func (s *FileStore) writeJSON(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.writeFile(path, data)
+}
+
+// writeFile atomically writes data to path by renaming a temporary file
+func (s *FileStore) writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write job file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write job file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write job file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readJSON decodes the JSON file at path into v
+func (s *FileStore) readJSON(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read job file: %w", err)
+	}
+	return json.Unmarshal(data, v)
+}
+
+// validID reports whether id looks like an ID from NewID, which keeps
+// client supplied IDs from addressing paths outside the store directory
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
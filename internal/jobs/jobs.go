@@ -0,0 +1,131 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// Common errors
+var (
+	ErrNotFound = errors.New("job not found")
+	ErrNoOutput = errors.New("job has no output")
+)
+
+// Status is the lifecycle state of a job
+type Status string
+
+// Job statuses
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Job tracks an asynchronous conversion
+type Job struct {
+	ID        string              `json:"id"`
+	Status    Status              `json:"status"`
+	Total     int                 `json:"total"`
+	Completed int                 `json:"completed"`
+	Result    *models.BatchResult `json:"result,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+	ExpiresAt time.Time           `json:"expires_at"`
+}
+
+// Done reports whether the job has finished, successfully or not
+func (j *Job) Done() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed
+}
+
+// Output is the file produced by a finished job
+type Output struct {
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename"`
+	Data        []byte `json:"-"`
+}
+
+// Store persists jobs and their outputs. Jobs and outputs expire a fixed
+// time after the job was created; expired entries are reported as not found
+// and removed by DeleteExpired.
+type Store interface {
+	// Create saves a new job, assigning its ID, timestamps and expiry
+	Create(ctx context.Context, job *Job) error
+
+	// Get returns a copy of the job with the given ID
+	Get(ctx context.Context, id string) (*Job, error)
+
+	// Update replaces the stored state of an existing job
+	Update(ctx context.Context, job *Job) error
+
+	// PutOutput stores the output file of a job
+	PutOutput(ctx context.Context, id string, output *Output) error
+
+	// GetOutput returns the output file of a job
+	GetOutput(ctx context.Context, id string) (*Output, error)
+
+	// DeleteExpired removes jobs that expired before now and returns how
+	// many were removed
+	DeleteExpired(ctx context.Context, now time.Time) (int, error)
+}
+
+// NewID returns a random job ID
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RunJanitor deletes expired jobs from store every interval until ctx is
+// cancelled
+func RunJanitor(ctx context.Context, store Store, interval time.Duration, logger *slog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := store.DeleteExpired(ctx, now)
+			if err != nil {
+				logger.WarnContext(ctx, "failed to delete expired jobs", slog.Any("error", err))
+			} else if n > 0 {
+				logger.DebugContext(ctx, "deleted expired jobs", slog.Int("count", n))
+			}
+		}
+	}
+}
+
+// prepare fills in the ID, timestamps and expiry of a new job
+func prepare(job *Job, ttl time.Duration) {
+	now := time.Now().UTC()
+	if job.ID == "" {
+		job.ID = NewID()
+	}
+	if job.Status == "" {
+		job.Status = StatusQueued
+	}
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	job.ExpiresAt = now.Add(ttl)
+}
+
+// copyJob returns a copy of job that shares no mutable state with it
+func copyJob(job *Job) *Job {
+	c := *job
+	if job.Result != nil {
+		result := *job.Result
+		result.Items = append([]models.BatchItemResult(nil), job.Result.Items...)
+		c.Result = &result
+	}
+	return &c
+}
@@ -0,0 +1,144 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+)
+
+// stores returns one of each store implementation with the given TTL
+func stores(t *testing.T, ttl time.Duration) map[string]Store {
+	fileStore, err := NewFileStore(t.TempDir(), ttl)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	return map[string]Store{
+		"memory": NewMemoryStore(ttl),
+		"file":   fileStore,
+	}
+}
+
+func TestStoreLifecycle(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			job := &Job{Total: 2}
+			if err := store.Create(ctx, job); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if len(job.ID) != 32 {
+				t.Errorf("Expected a 32 character ID, got %q", job.ID)
+			}
+			if job.Status != StatusQueued {
+				t.Errorf("Expected status %s, got %s", StatusQueued, job.Status)
+			}
+			if !job.ExpiresAt.After(job.CreatedAt) {
+				t.Errorf("Expected expiry after creation, got %v and %v", job.ExpiresAt, job.CreatedAt)
+			}
+
+			if _, err := store.GetOutput(ctx, job.ID); !errors.Is(err, ErrNoOutput) {
+				t.Errorf("Expected ErrNoOutput before the output is stored, got %v", err)
+			}
+
+			job.Status = StatusSucceeded
+			job.Completed = 2
+			job.Result = &models.BatchResult{Succeeded: 2}
+			if err := store.Update(ctx, job); err != nil {
+				t.Fatalf("Update() error = %v", err)
+			}
+			output := &Output{ContentType: "image/webp", Filename: "a.webp", Data: []byte("webp")}
+			if err := store.PutOutput(ctx, job.ID, output); err != nil {
+				t.Fatalf("PutOutput() error = %v", err)
+			}
+
+			got, err := store.Get(ctx, job.ID)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			if !got.Done() || got.Completed != 2 || got.Result == nil || got.Result.Succeeded != 2 {
+				t.Errorf("Expected the updated job, got %+v", got)
+			}
+
+			gotOutput, err := store.GetOutput(ctx, job.ID)
+			if err != nil {
+				t.Fatalf("GetOutput() error = %v", err)
+			}
+			if gotOutput.Filename != "a.webp" || gotOutput.ContentType != "image/webp" || string(gotOutput.Data) != "webp" {
+				t.Errorf("Expected the stored output, got %+v", gotOutput)
+			}
+		})
+	}
+}
+
+func TestStoreNotFound(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t, time.Hour) {
+		t.Run(name, func(t *testing.T) {
+			for _, id := range []string{NewID(), "../../etc/passwd", ""} {
+				if _, err := store.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Get(%q): expected ErrNotFound, got %v", id, err)
+				}
+			}
+			if err := store.Update(ctx, &Job{ID: NewID()}); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Update(): expected ErrNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreExpiry(t *testing.T) {
+	ctx := context.Background()
+
+	for name, store := range stores(t, -time.Second) {
+		t.Run(name, func(t *testing.T) {
+			job := &Job{Total: 1}
+			if err := store.Create(ctx, job); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+			if err := store.PutOutput(ctx, job.ID, &Output{Data: []byte("webp")}); err != nil {
+				t.Fatalf("PutOutput() error = %v", err)
+			}
+
+			if _, err := store.Get(ctx, job.ID); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Expected an expired job to be not found, got %v", err)
+			}
+
+			n, err := store.DeleteExpired(ctx, time.Now())
+			if err != nil {
+				t.Fatalf("DeleteExpired() error = %v", err)
+			}
+			if n != 1 {
+				t.Errorf("Expected 1 expired job deleted, got %d", n)
+			}
+
+			n, err = store.DeleteExpired(ctx, time.Now())
+			if err != nil || n != 0 {
+				t.Errorf("Expected nothing left to delete, got %d, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(time.Hour)
+
+	job := &Job{Total: 1}
+	if err := store.Create(ctx, job); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	job.Completed = 1
+
+	got, err := store.Get(ctx, job.ID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Completed != 0 {
+		t.Errorf("Expected the stored job to be unaffected by later changes, got completed %d", got.Completed)
+	}
+}
@@ -0,0 +1,104 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps jobs in process memory. Jobs are lost on restart.
+type MemoryStore struct {
+	ttl time.Duration
+
+	mu      sync.RWMutex
+	jobs    map[string]*Job
+	outputs map[string]*Output
+}
+
+// NewMemoryStore creates an in-memory store whose jobs expire after ttl
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		ttl:     ttl,
+		jobs:    map[string]*Job{},
+		outputs: map[string]*Output{},
+	}
+}
+
+// Create implements Store
+func (s *MemoryStore) Create(ctx context.Context, job *Job) error {
+	prepare(job, s.ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = copyJob(job)
+	return nil
+}
+
+// Get implements Store
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok || time.Now().After(job.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	return copyJob(job), nil
+}
+
+// Update implements Store
+func (s *MemoryStore) Update(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[job.ID]; !ok {
+		return ErrNotFound
+	}
+	job.UpdatedAt = time.Now().UTC()
+	s.jobs[job.ID] = copyJob(job)
+	return nil
+}
+
+// PutOutput implements Store
+func (s *MemoryStore) PutOutput(ctx context.Context, id string, output *Output) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[id]; !ok {
+		return ErrNotFound
+	}
+	s.outputs[id] = output
+	return nil
+}
+
+// GetOutput implements Store
+func (s *MemoryStore) GetOutput(ctx context.Context, id string) (*Output, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok || time.Now().After(job.ExpiresAt) {
+		return nil, ErrNotFound
+	}
+	output, ok := s.outputs[id]
+	if !ok {
+		return nil, ErrNoOutput
+	}
+	return output, nil
+}
+
+// DeleteExpired implements Store
+func (s *MemoryStore) DeleteExpired(ctx context.Context, now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for id, job := range s.jobs {
+		if now.After(job.ExpiresAt) {
+			delete(s.jobs, id)
+			delete(s.outputs, id)
+			removed++
+		}
+	}
+	return removed, nil
+}
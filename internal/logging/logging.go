@@ -0,0 +1,129 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// contextKey is the type for values stored in a context by this package
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	accessRecordKey
+)
+
+// New creates a structured logger writing to w. Level is one of debug, info,
+// warn or error and format is either json or text. Records logged with a
+// context carrying a request ID are annotated with a request_id attribute.
+func New(w io.Writer, level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(level)}
+
+	var h slog.Handler
+	if strings.EqualFold(format, "text") {
+		h = slog.NewTextHandler(w, opts)
+	} else {
+		h = slog.NewJSONHandler(w, opts)
+	}
+
+	return slog.New(&contextHandler{Handler: h})
+}
+
+// Discard returns a logger that drops every record
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
+
+// ParseLevel converts a level name into a slog.Level, defaulting to info
+func ParseLevel(level string) slog.Level {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// WithRequestID returns a copy of ctx carrying the given request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the request ID stored in ctx, or an empty string
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// accessRecord collects attributes that handlers contribute to the access log
+type accessRecord struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// WithAccessRecord returns a copy of ctx that collects access log attributes
+// added with AddAccessAttrs
+func WithAccessRecord(ctx context.Context) context.Context {
+	return context.WithValue(ctx, accessRecordKey, &accessRecord{})
+}
+
+// AddAccessAttrs attaches attributes to the access log line of the request
+// that ctx belongs to. It is a no-op if ctx has no access record.
+func AddAccessAttrs(ctx context.Context, attrs ...slog.Attr) {
+	rec, ok := ctx.Value(accessRecordKey).(*accessRecord)
+	if !ok {
+		return
+	}
+	rec.mu.Lock()
+	rec.attrs = append(rec.attrs, attrs...)
+	rec.mu.Unlock()
+}
+
+// AccessAttrs returns the attributes collected for the request ctx belongs to
+func AccessAttrs(ctx context.Context) []slog.Attr {
+	rec, ok := ctx.Value(accessRecordKey).(*accessRecord)
+	if !ok {
+		return nil
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]slog.Attr(nil), rec.attrs...)
+}
+
+// contextHandler adds the request ID and trace ID found in the record's context
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle implements slog.Handler
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs implements slog.Handler
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup implements slog.Handler
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
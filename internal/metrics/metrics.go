@@ -0,0 +1,265 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/cache"
+	"github.com/Mark-Life/smart-webp-resize/internal/fetch"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Processing stages reported by ObserveStage
+const (
+	StageFetch  = "fetch"
+	StageDecode = "decode"
+	StageResize = "resize"
+	StageEncode = "encode"
+)
+
+// namespace prefixes every metric exported by the service
+const namespace = "webp_resizer"
+
+// Metrics holds the Prometheus collectors for the service. A nil *Metrics is
+// valid and records nothing, so instrumented code does not need nil checks.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests      *prometheus.CounterVec
+	bytesIn       *prometheus.CounterVec
+	bytesOut      *prometheus.CounterVec
+	sizeReduction prometheus.Histogram
+	stageDuration *prometheus.HistogramVec
+	inputFormats  *prometheus.CounterVec
+	queueDepth    prometheus.Gauge
+	inFlight      prometheus.Gauge
+}
+
+// New creates a Metrics instance with its own registry, including the
+// standard Go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by endpoint and status code.",
+		}, []string{"endpoint", "status"}),
+		bytesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "input_bytes_total",
+			Help:      "Bytes of source images received, by endpoint.",
+		}, []string{"endpoint"}),
+		bytesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "output_bytes_total",
+			Help:      "Bytes of WebP output produced, by endpoint.",
+		}, []string{"endpoint"}),
+		sizeReduction: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "size_ratio",
+			Help:      "Ratio of output size to input size for processed images.",
+			Buckets:   []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1, 1.25, 1.5, 2},
+		}),
+		stageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "stage_duration_seconds",
+			Help:      "Latency of each processing stage (fetch, decode, resize, encode).",
+			Buckets:   []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		}, []string{"stage"}),
+		inputFormats: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "input_format_total",
+			Help:      "Processed images by detected input format.",
+		}, []string{"format"}),
+		queueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "queue_depth",
+			Help:      "Requests waiting for a processing slot.",
+		}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "in_flight_jobs",
+			Help:      "Images currently being processed.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.bytesIn,
+		m.bytesOut,
+		m.sizeReduction,
+		m.stageDuration,
+		m.inputFormats,
+		m.queueDepth,
+		m.inFlight,
+	)
+
+	return m
+}
+
+// Registry returns the registry so other packages can add their own collectors
+func (m *Metrics) Registry() *prometheus.Registry {
+	if m == nil {
+		return nil
+	}
+	return m.registry
+}
+
+// Handler serves the metrics in the Prometheus text exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Instrument wraps h to count requests by endpoint and response status
+func (m *Metrics) Instrument(endpoint string, h http.Handler) http.Handler {
+	if m == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		m.requests.WithLabelValues(endpoint, strconv.Itoa(sw.status)).Inc()
+	})
+}
+
+// ObserveStage records how long a processing stage took
+func (m *Metrics) ObserveStage(stage string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.stageDuration.WithLabelValues(stage).Observe(d.Seconds())
+}
+
+// ObserveImage records input/output sizes and the input format of a
+// successfully processed image
+func (m *Metrics) ObserveImage(endpoint, format string, inputSize, outputSize int64) {
+	if m == nil {
+		return
+	}
+	m.bytesIn.WithLabelValues(endpoint).Add(float64(inputSize))
+	m.bytesOut.WithLabelValues(endpoint).Add(float64(outputSize))
+	if format != "" {
+		m.inputFormats.WithLabelValues(format).Inc()
+	}
+	if inputSize > 0 {
+		m.sizeReduction.Observe(float64(outputSize) / float64(inputSize))
+	}
+}
+
+// AddQueued adjusts the number of requests waiting for a processing slot
+func (m *Metrics) AddQueued(delta int) {
+	if m == nil {
+		return
+	}
+	m.queueDepth.Add(float64(delta))
+}
+
+// AddInFlight adjusts the number of images currently being processed
+func (m *Metrics) AddInFlight(delta int) {
+	if m == nil {
+		return
+	}
+	m.inFlight.Add(float64(delta))
+}
+
+// statusWriter captures the status code written by a handler
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code before passing it on
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streaming handlers flush through the wrapper
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// RegisterCache exports the statistics of the result cache
+func (m *Metrics) RegisterCache(c *cache.Cache) {
+	if m == nil || c == nil {
+		return
+	}
+
+	stat := func(f func(cache.Stats) float64) func() float64 {
+		return func() float64 { return f(c.Stats()) }
+	}
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_hits_total",
+			Help:      "Result cache lookups that found a cached image.",
+		}, stat(func(s cache.Stats) float64 { return float64(s.Hits) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_misses_total",
+			Help:      "Result cache lookups that had to process the image.",
+		}, stat(func(s cache.Stats) float64 { return float64(s.Misses) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cache_evictions_total",
+			Help:      "Entries evicted from the result cache to make room.",
+		}, stat(func(s cache.Stats) float64 { return float64(s.Evictions) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cache_memory_bytes",
+			Help:      "Bytes held by the in-memory result cache.",
+		}, stat(func(s cache.Stats) float64 { return float64(s.MemoryBytes) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "cache_disk_bytes",
+			Help:      "Bytes held by the on-disk result cache.",
+		}, stat(func(s cache.Stats) float64 { return float64(s.DiskBytes) })),
+	)
+}
+
+// RegisterFetcher exports the retry and circuit breaker statistics of the
+// fetcher used for remote images
+func (m *Metrics) RegisterFetcher(f *fetch.Fetcher) {
+	if m == nil || f == nil {
+		return
+	}
+
+	stat := func(get func(fetch.Stats) float64) func() float64 {
+		return func() float64 { return get(f.Stats()) }
+	}
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "fetch_retries_total",
+			Help:      "Origin requests retried after a transient failure.",
+		}, stat(func(s fetch.Stats) float64 { return float64(s.Retries) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "fetch_breaker_trips_total",
+			Help:      "Times an origin's circuit breaker opened after repeated failures.",
+		}, stat(func(s fetch.Stats) float64 { return float64(s.BreakerTrips) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "fetch_breaker_rejections_total",
+			Help:      "Origin requests refused while the origin's circuit breaker was open.",
+		}, stat(func(s fetch.Stats) float64 { return float64(s.BreakerRejections) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "fetch_breakers_open",
+			Help:      "Origins whose circuit breaker is open or letting a probe through.",
+		}, stat(func(s fetch.Stats) float64 { return float64(s.OpenBreakers) })),
+	)
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/cache"
+	"github.com/Mark-Life/smart-webp-resize/internal/fetch"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	m := New()
+
+	h := m.Instrument("process_url", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/process/url", nil))
+
+	m.ObserveStage(StageEncode, 20*time.Millisecond)
+	m.ObserveImage("url", "jpeg", 1000, 250)
+	m.AddQueued(2)
+	m.AddInFlight(1)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(w.Result().Body)
+	text := string(body)
+
+	expected := []string{
+		`webp_resizer_http_requests_total{endpoint="process_url",status="400"} 1`,
+		`webp_resizer_stage_duration_seconds_count{stage="encode"} 1`,
+		`webp_resizer_input_bytes_total{endpoint="url"} 1000`,
+		`webp_resizer_output_bytes_total{endpoint="url"} 250`,
+		`webp_resizer_input_format_total{format="jpeg"} 1`,
+		`webp_resizer_size_ratio_sum 0.25`,
+		`webp_resizer_queue_depth 2`,
+		`webp_resizer_in_flight_jobs 1`,
+	}
+	for _, want := range expected {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected metrics output to contain %q", want)
+		}
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+
+	// None of these should panic
+	m.ObserveStage(StageFetch, time.Second)
+	m.ObserveImage("upload", "png", 10, 5)
+	m.AddQueued(1)
+	m.AddInFlight(-1)
+
+	called := false
+	h := m.Instrument("health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/health", nil))
+	if !called {
+		t.Error("Expected wrapped handler to be called")
+	}
+}
+
+func TestRegisterCache(t *testing.T) {
+	m := New()
+	c, _ := cache.New(cache.Config{MemoryMaxBytes: 1 << 20})
+	m.RegisterCache(c)
+
+	key := cache.Key([]byte("input"), "options")
+	c.Get(key)
+	c.Put(key, &cache.Entry{Data: []byte("webp")})
+	c.Get(key)
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	text := w.Body.String()
+
+	for _, want := range []string{
+		"webp_resizer_cache_hits_total 1",
+		"webp_resizer_cache_misses_total 1",
+		"webp_resizer_cache_evictions_total 0",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected metrics output to contain %q", want)
+		}
+	}
+}
+
+func TestRegisterFetcher(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer origin.Close()
+
+	f := fetch.New(fetch.Config{
+		RetryBaseDelay:   time.Millisecond,
+		BreakerThreshold: 3,
+		Policy:           fetch.Policy{AllowNets: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}},
+	})
+	m := New()
+	m.RegisterFetcher(f)
+
+	// Three attempts open the breaker, which refuses the next request
+	for i := 0; i < 2; i++ {
+		f.Get(context.Background(), origin.URL, nil)
+	}
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	text := w.Body.String()
+
+	for _, want := range []string{
+		"webp_resizer_fetch_retries_total 2",
+		"webp_resizer_fetch_breaker_trips_total 1",
+		"webp_resizer_fetch_breaker_rejections_total 1",
+		"webp_resizer_fetch_breakers_open 1",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected metrics output to contain %q", want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+)
+
+// AccessLog logs one line per request with its status, response size and
+// duration, plus any attributes handlers added with logging.AddAccessAttrs
+func AccessLog(logger *slog.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			ctx := logging.WithAccessRecord(r.Context())
+			rec := newResponseRecorder(w)
+
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			attrs := []slog.Attr{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", rec.status),
+				slog.Int64("bytes_out", rec.bytes),
+				slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+				slog.String("remote_addr", r.RemoteAddr),
+			}
+			attrs = append(attrs, logging.AccessAttrs(ctx)...)
+
+			level := slog.LevelInfo
+			if rec.status >= http.StatusInternalServerError {
+				level = slog.LevelError
+			}
+			logger.LogAttrs(ctx, level, "request", attrs...)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig lists what browsers on other origins may do
+type CORSConfig struct {
+	// AllowedOrigins are origins such as "https://app.example.com". An
+	// entry like "https://*.example.com" allows any subdomain, and "*" any
+	// origin. CORS is disabled if empty.
+	AllowedOrigins []string
+
+	// AllowedMethods defaults to GET, HEAD and POST
+	AllowedMethods []string
+
+	// AllowedHeaders are request headers scripts may set, matched without
+	// regard to case. "*" allows any. Defaults to the headers the API reads.
+	AllowedHeaders []string
+
+	// ExposedHeaders are response headers scripts may read. Defaults to the
+	// metadata, timing, caching and rate limit headers the API sends.
+	ExposedHeaders []string
+
+	// MaxAge is how long browsers may cache a preflight response
+	MaxAge time.Duration
+}
+
+// Defaults of CORSConfig
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "If-None-Match", "X-API-Key", RequestIDHeader}
+	defaultCORSExposed = []string{
+		"Content-Disposition", "ETag", "Location", "Retry-After", "Server-Timing",
+		"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+		"X-Archive-Converted", "X-Archive-Failed", "X-Cache", RequestIDHeader,
+	}
+)
+
+// CORS answers preflight requests from allowed origins and lets them read
+// responses. Preflights are answered before any other handler, so they
+// need no API key and do not count against rate limits. Requests from
+// other origins are served without CORS headers, which makes browsers
+// withhold the response from scripts.
+func CORS(cfg CORSConfig) Middleware {
+	if len(cfg.AllowedOrigins) == 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = defaultCORSMethods
+	}
+	cfg.AllowedMethods = slices.Clone(cfg.AllowedMethods)
+	for i, method := range cfg.AllowedMethods {
+		cfg.AllowedMethods[i] = strings.ToUpper(method)
+	}
+	if len(cfg.AllowedHeaders) == 0 {
+		cfg.AllowedHeaders = defaultCORSHeaders
+	}
+	if len(cfg.ExposedHeaders) == 0 {
+		cfg.ExposedHeaders = defaultCORSExposed
+	}
+	anyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	anyHeader := slices.Contains(cfg.AllowedHeaders, "*")
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !anyOrigin {
+				w.Header().Add("Vary", "Origin")
+			}
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			allowed := anyOrigin || originAllowed(cfg.AllowedOrigins, origin)
+			if !preflight {
+				if allowed {
+					allowOrigin(w, origin, anyOrigin)
+					w.Header().Set("Access-Control-Expose-Headers", exposed)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if !allowed {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			if !slices.Contains(cfg.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) {
+				http.Error(w, "Method not allowed", http.StatusForbidden)
+				return
+			}
+			requested := requestedHeaders(r)
+			for _, header := range requested {
+				if !anyHeader && !slices.ContainsFunc(cfg.AllowedHeaders, func(h string) bool { return strings.EqualFold(h, header) }) {
+					http.Error(w, "Header not allowed: "+header, http.StatusForbidden)
+					return
+				}
+			}
+
+			allowOrigin(w, origin, anyOrigin)
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if len(requested) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+			}
+			if cfg.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// allowOrigin lets origin read the response, including its Server-Timing
+func allowOrigin(w http.ResponseWriter, origin string, anyOrigin bool) {
+	if anyOrigin {
+		origin = "*"
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Timing-Allow-Origin", origin)
+}
+
+// originAllowed reports whether origin matches one of allowed exactly, or
+// as a subdomain of a "scheme://*.domain" entry
+func originAllowed(allowed []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range allowed {
+		pattern = strings.ToLower(pattern)
+		if pattern == origin {
+			return true
+		}
+		scheme, domain, ok := strings.Cut(pattern, "://*.")
+		if ok && strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestedHeaders returns the headers named in a preflight's
+// Access-Control-Request-Headers, lowercased
+func requestedHeaders(r *http.Request) []string {
+	var headers []string
+	for _, value := range r.Header.Values("Access-Control-Request-Headers") {
+		for _, header := range strings.Split(value, ",") {
+			if header = strings.ToLower(strings.TrimSpace(header)); header != "" {
+				headers = append(headers, header)
+			}
+		}
+	}
+	return headers
+}
@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+)
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = logging.RequestID(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		header   string
+		wantSame bool
+	}{
+		{name: "honors client id", header: "abc-123", wantSame: true},
+		{name: "generates when missing", header: "", wantSame: false},
+		{name: "rejects unsafe id", header: "bad id\n", wantSame: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/health", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, req)
+
+			got := w.Header().Get(RequestIDHeader)
+			if got == "" {
+				t.Fatal("Expected a request ID header in the response")
+			}
+			if got != seen {
+				t.Errorf("Response ID %q does not match context ID %q", got, seen)
+			}
+			if tt.wantSame && got != tt.header {
+				t.Errorf("Expected client ID %q to be reused, got %q", tt.header, got)
+			}
+			if !tt.wantSame && got == tt.header {
+				t.Errorf("Expected a generated ID, got client value %q", got)
+			}
+		})
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := logging.New(&buf, "info", "json")
+
+	h := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.AddAccessAttrs(r.Context(), slog.Int("input_bytes", 42))
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}), RequestID, AccessLog(logger))
+
+	req := httptest.NewRequest("GET", "/process/url", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to decode log line %q: %v", buf.String(), err)
+	}
+
+	if entry["request_id"] != "req-1" {
+		t.Errorf("Expected request_id req-1, got %v", entry["request_id"])
+	}
+	if entry["status"] != float64(http.StatusTeapot) {
+		t.Errorf("Expected status %d, got %v", http.StatusTeapot, entry["status"])
+	}
+	if entry["bytes_out"] != float64(len("short and stout")) {
+		t.Errorf("Expected bytes_out %d, got %v", len("short and stout"), entry["bytes_out"])
+	}
+	if entry["input_bytes"] != float64(42) {
+		t.Errorf("Expected handler attribute input_bytes=42, got %v", entry["input_bytes"])
+	}
+	if _, ok := entry["duration_ms"]; !ok {
+		t.Error("Expected duration_ms in access log")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	h := CORS(CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org"},
+		MaxAge:         10 * time.Minute,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Cache", "HIT")
+		w.Write([]byte("image"))
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		reqMethod   string
+		reqHeaders  string
+		wantStatus  int
+		wantOrigin  string
+		wantHeaders string
+		wantBody    bool
+	}{
+		{name: "no origin", method: "POST", wantStatus: http.StatusOK, wantBody: true},
+		{name: "allowed origin", method: "POST", origin: "https://app.example.com", wantStatus: http.StatusOK, wantOrigin: "https://app.example.com", wantBody: true},
+		{name: "subdomain", method: "GET", origin: "https://cdn.example.org", wantStatus: http.StatusOK, wantOrigin: "https://cdn.example.org", wantBody: true},
+		{name: "other origin", method: "POST", origin: "https://evil.example", wantStatus: http.StatusOK, wantBody: true},
+		{name: "other scheme", method: "POST", origin: "http://app.example.com", wantStatus: http.StatusOK, wantBody: true},
+		{
+			name: "preflight", method: "OPTIONS", origin: "https://app.example.com", reqMethod: "POST", reqHeaders: "Content-Type, x-api-key",
+			wantStatus: http.StatusNoContent, wantOrigin: "https://app.example.com", wantHeaders: "content-type, x-api-key",
+		},
+		{name: "preflight other origin", method: "OPTIONS", origin: "https://evil.example", reqMethod: "POST", wantStatus: http.StatusForbidden},
+		{name: "preflight method", method: "OPTIONS", origin: "https://app.example.com", reqMethod: "DELETE", wantStatus: http.StatusForbidden},
+		{name: "preflight header", method: "OPTIONS", origin: "https://app.example.com", reqMethod: "POST", reqHeaders: "X-Secret", wantStatus: http.StatusForbidden},
+		{name: "plain options", method: "OPTIONS", origin: "https://app.example.com", wantStatus: http.StatusOK, wantOrigin: "https://app.example.com", wantBody: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/process/upload", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.reqMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.reqMethod)
+			}
+			if tt.reqHeaders != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.reqHeaders)
+			}
+			w := httptest.NewRecorder()
+
+			h.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Expected Access-Control-Allow-Origin %q, got %q", tt.wantOrigin, got)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Headers"); got != tt.wantHeaders {
+				t.Errorf("Expected Access-Control-Allow-Headers %q, got %q", tt.wantHeaders, got)
+			}
+			if (w.Body.String() == "image") != tt.wantBody {
+				t.Errorf("Expected handler to run: %v, got body %q", tt.wantBody, w.Body.String())
+			}
+			if w.Header().Get("Vary") == "" {
+				t.Error("Expected a Vary header")
+			}
+			if tt.wantOrigin == "" {
+				return
+			}
+			if tt.method == "OPTIONS" && tt.reqMethod != "" {
+				if w.Header().Get("Access-Control-Max-Age") != "600" || w.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD, POST" {
+					t.Errorf("Unexpected preflight headers %v", w.Header())
+				}
+				return
+			}
+			exposed := w.Header().Get("Access-Control-Expose-Headers")
+			for _, header := range []string{"Server-Timing", "X-Cache", "RateLimit-Remaining", RequestIDHeader} {
+				if !strings.Contains(exposed, header) {
+					t.Errorf("Expected %s to be exposed, got %q", header, exposed)
+				}
+			}
+			if w.Header().Get("Timing-Allow-Origin") != tt.wantOrigin {
+				t.Errorf("Expected Timing-Allow-Origin %s, got %q", tt.wantOrigin, w.Header().Get("Timing-Allow-Origin"))
+			}
+		})
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	h := CORS(CORSConfig{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	req := httptest.NewRequest("OPTIONS", "/process/upload", nil)
+	req.Header.Set("Origin", "https://anywhere.example")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "*" || w.Header().Get("Access-Control-Allow-Headers") != "x-custom" {
+		t.Errorf("Unexpected preflight response %d %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Access-Control-Max-Age") != "" {
+		t.Errorf("Expected no Access-Control-Max-Age without a max age, got %q", w.Header().Get("Access-Control-Max-Age"))
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	h := CORS(CORSConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest("GET", "/process/url", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if len(w.Header()) != 0 {
+		t.Errorf("Expected no CORS headers, got %v", w.Header())
+	}
+}
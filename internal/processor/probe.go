@@ -0,0 +1,327 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // Register GIF format for header parsing
+
+	"github.com/Mark-Life/smart-webp-resize/pkg/models"
+	"github.com/chai2010/webp"
+)
+
+// Probe inspects an image using only its headers and metadata chunks, without
+// decoding any pixel data. The output dimensions are those ProcessFromBytes
+// would produce with the given options, and are left zero for formats it
+// does not accept.
+func Probe(data []byte, options *ProcessOptions) (*models.ProbeResult, error) {
+	p := &defaultProcessor{}
+
+	format, err := p.detectImageFormat(data)
+	if err != nil {
+		if !isGIF(data) {
+			return nil, err
+		}
+		format = "gif"
+	}
+
+	result := &models.ProbeResult{
+		Format:     format,
+		FrameCount: 1,
+		FileSize:   int64(len(data)),
+	}
+
+	if format == "webp" {
+		err = probeWebP(data, result)
+	} else {
+		err = probeConfig(data, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "jpeg":
+		probeJPEG(data, result)
+	case "png":
+		probePNG(data, result)
+	case "gif":
+		probeGIF(data, result)
+	}
+	result.Animated = result.FrameCount > 1
+
+	// GIF is recognised here but not converted, so it has no output size
+	result.Processable = format != "gif"
+	if !result.Processable {
+		return result, nil
+	}
+
+	if options == nil {
+		options = &ProcessOptions{MaxWidth: 1920, MaxHeight: 1080}
+	}
+	result.OutputWidth, result.OutputHeight = p.fitDimensions(
+		result.Width,
+		result.Height,
+		options.MaxWidth,
+		options.MaxHeight,
+		options.fit(),
+	)
+
+	return result, nil
+}
+
+// probeConfig fills in dimensions and pixel format from image.DecodeConfig
+func probeConfig(data []byte, result *models.ProbeResult) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	result.Width = config.Width
+	result.Height = config.Height
+	result.ColorModel, result.BitDepth = describeColorModel(config.ColorModel)
+	result.HasAlpha = modelHasAlpha(config.ColorModel)
+	return nil
+}
+
+// modelHasAlpha reports whether a color model can carry transparency
+func modelHasAlpha(model color.Model) bool {
+	switch model {
+	case color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model,
+		color.AlphaModel, color.Alpha16Model, color.NYCbCrAModel:
+		return true
+	}
+	if palette, ok := model.(color.Palette); ok {
+		for _, c := range palette {
+			if _, _, _, a := c.RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// probeJPEG scans JPEG marker segments for EXIF and ICC data
+func probeJPEG(data []byte, result *models.ProbeResult) {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return
+		}
+		marker := data[pos+1]
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			pos += 2
+			continue
+		}
+		// Image data follows start of scan; no more metadata after this
+		if marker == 0xDA || marker == 0xD9 {
+			return
+		}
+
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return
+		}
+		segment := data[pos+4 : end]
+
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")):
+			result.ExifOrientation = exifOrientation(segment[6:])
+		case marker == 0xE2 && bytes.HasPrefix(segment, []byte("ICC_PROFILE\x00")):
+			result.HasICC = true
+		}
+		pos = end
+	}
+}
+
+// probePNG scans PNG chunks for animation, ICC, EXIF and transparency
+func probePNG(data []byte, result *models.ProbeResult) {
+	pos := 8
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		chunkType := string(data[pos+4 : pos+8])
+		start := pos + 8
+		end := start + length
+		if length < 0 || end+4 > len(data) {
+			return
+		}
+		chunk := data[start:end]
+
+		switch chunkType {
+		case "acTL":
+			if len(chunk) >= 4 {
+				result.FrameCount = int(binary.BigEndian.Uint32(chunk))
+			}
+		case "iCCP":
+			result.HasICC = true
+		case "eXIf":
+			result.ExifOrientation = exifOrientation(chunk)
+		case "tRNS":
+			result.HasAlpha = true
+		case "IEND":
+			return
+		}
+		pos = end + 4 // skip CRC
+	}
+}
+
+// probeWebP reads the RIFF container for dimensions, features and frames
+func probeWebP(data []byte, result *models.ProbeResult) error {
+	result.ColorModel, result.BitDepth = "YCbCr", 8
+
+	frames := 0
+	pos := 12
+	for pos+8 <= len(data) {
+		fourCC := string(data[pos : pos+4])
+		length := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		start := pos + 8
+		end := start + length
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[start:end]
+
+		switch fourCC {
+		case "VP8X":
+			if len(chunk) >= 10 {
+				flags := chunk[0]
+				result.HasICC = flags&0x20 != 0
+				result.HasAlpha = flags&0x10 != 0
+				result.Width = int(uint32(chunk[4])|uint32(chunk[5])<<8|uint32(chunk[6])<<16) + 1
+				result.Height = int(uint32(chunk[7])|uint32(chunk[8])<<8|uint32(chunk[9])<<16) + 1
+			}
+		case "VP8L":
+			result.ColorModel = "NRGBA"
+		case "ANMF":
+			frames++
+		case "EXIF":
+			result.ExifOrientation = exifOrientation(chunk)
+		}
+
+		// Chunks are padded to an even size
+		pos = start + length + length%2
+	}
+	if frames > 0 {
+		result.FrameCount = frames
+	}
+
+	if result.Width == 0 || result.Height == 0 {
+		width, height, hasAlpha, err := webp.GetInfo(data)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+		result.Width, result.Height = width, height
+		result.HasAlpha = result.HasAlpha || hasAlpha
+	}
+	if result.HasAlpha && result.ColorModel == "YCbCr" {
+		result.ColorModel = "NYCbCrA"
+	}
+
+	return nil
+}
+
+// probeGIF counts the frames of a GIF by walking its block structure
+func probeGIF(data []byte, result *models.ProbeResult) {
+	if len(data) < 13 {
+		return
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << ((flags & 0x07) + 1) // global color table
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension
+			if pos+2 > len(data) {
+				return
+			}
+			label := data[pos+1]
+			pos += 2
+			if label == 0xF9 && pos+2 <= len(data) && data[pos+1]&0x01 != 0 {
+				result.HasAlpha = true // graphic control extension with transparency
+			}
+			pos = skipGIFSubBlocks(data, pos)
+		case 0x2C: // image descriptor
+			frames++
+			if pos+10 > len(data) {
+				result.FrameCount = frames
+				return
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << ((flags & 0x07) + 1) // local color table
+			}
+			pos = skipGIFSubBlocks(data, pos+1) // skip LZW minimum code size
+		default: // trailer or corrupt data
+			if frames > 0 {
+				result.FrameCount = frames
+			}
+			return
+		}
+	}
+	if frames > 0 {
+		result.FrameCount = frames
+	}
+}
+
+// skipGIFSubBlocks returns the position after a chain of GIF data sub-blocks
+func skipGIFSubBlocks(data []byte, pos int) int {
+	for pos < len(data) {
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			return pos
+		}
+		pos += size
+	}
+	return pos
+}
+
+// isGIF reports whether data starts with a GIF signature
+func isGIF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))
+}
+
+// exifOrientation reads the orientation tag (0x0112) from IFD0 of a TIFF
+// structured EXIF block, returning 0 if it is absent or malformed
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 0
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation >= 1 && orientation <= 8 {
+				return orientation
+			}
+			return 0
+		}
+	}
+	return 0
+}
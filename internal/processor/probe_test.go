@@ -0,0 +1,188 @@
+package processor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/chai2010/webp"
+)
+
+// withJPEGSegment inserts a marker segment right after the SOI marker
+func withJPEGSegment(jpegData []byte, marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	out := append([]byte{}, jpegData[:2]...)
+	out = append(out, segment...)
+	out = append(out, payload...)
+	return append(out, jpegData[2:]...)
+}
+
+// exifWithOrientation builds a little-endian EXIF block holding one tag
+func exifWithOrientation(orientation uint16) []byte {
+	tiff := []byte{'I', 'I', 0x2A, 0x00, 0x08, 0x00, 0x00, 0x00}
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1) // one entry
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3) // SHORT
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	return binary.LittleEndian.AppendUint32(tiff, 0) // no next IFD
+}
+
+// riffChunk encodes a RIFF chunk with padding
+func riffChunk(fourCC string, payload []byte) []byte {
+	chunk := append([]byte(fourCC), 0, 0, 0, 0)
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(payload)))
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func TestProbe(t *testing.T) {
+	options := &ProcessOptions{MaxWidth: 100, MaxHeight: 100}
+
+	t.Run("png", func(t *testing.T) {
+		var buf bytes.Buffer
+		png.Encode(&buf, createTestImage(400, 200))
+
+		result, err := Probe(buf.Bytes(), options)
+		if err != nil {
+			t.Fatalf("Probe() error = %v", err)
+		}
+		if result.Format != "png" || result.Width != 400 || result.Height != 200 {
+			t.Errorf("Unexpected format/dimensions: %+v", result)
+		}
+		if result.OutputWidth != 100 || result.OutputHeight != 50 {
+			t.Errorf("Expected output 100x50, got %dx%d", result.OutputWidth, result.OutputHeight)
+		}
+		if result.FileSize != int64(buf.Len()) {
+			t.Errorf("Expected file size %d, got %d", buf.Len(), result.FileSize)
+		}
+		if result.FrameCount != 1 || result.Animated {
+			t.Errorf("Expected a single still frame, got %d", result.FrameCount)
+		}
+		if !result.Processable {
+			t.Error("Expected png to be processable")
+		}
+	})
+
+	t.Run("fit cover", func(t *testing.T) {
+		var buf bytes.Buffer
+		png.Encode(&buf, createTestImage(400, 200))
+
+		result, err := Probe(buf.Bytes(), &ProcessOptions{MaxWidth: 100, MaxHeight: 100, Fit: FitCover})
+		if err != nil {
+			t.Fatalf("Probe() error = %v", err)
+		}
+		if result.OutputWidth != 100 || result.OutputHeight != 100 {
+			t.Errorf("Expected output 100x100, got %dx%d", result.OutputWidth, result.OutputHeight)
+		}
+	})
+
+	t.Run("jpeg with exif and icc", func(t *testing.T) {
+		var buf bytes.Buffer
+		jpeg.Encode(&buf, createTestImage(64, 32), nil)
+		data := withJPEGSegment(buf.Bytes(), 0xE1, append([]byte("Exif\x00\x00"), exifWithOrientation(6)...))
+		data = withJPEGSegment(data, 0xE2, append([]byte("ICC_PROFILE\x00"), 1, 1, 0, 0))
+
+		result, err := Probe(data, options)
+		if err != nil {
+			t.Fatalf("Probe() error = %v", err)
+		}
+		if result.Format != "jpeg" || result.Width != 64 || result.Height != 32 {
+			t.Errorf("Unexpected format/dimensions: %+v", result)
+		}
+		if result.ExifOrientation != 6 {
+			t.Errorf("Expected EXIF orientation 6, got %d", result.ExifOrientation)
+		}
+		if !result.HasICC {
+			t.Error("Expected ICC profile to be detected")
+		}
+		if result.HasAlpha {
+			t.Error("Expected JPEG to have no alpha")
+		}
+	})
+
+	t.Run("animated gif", func(t *testing.T) {
+		palette := color.Palette{color.Transparent, color.Black, color.White}
+		anim := &gif.GIF{}
+		for i := 0; i < 3; i++ {
+			anim.Image = append(anim.Image, image.NewPaletted(image.Rect(0, 0, 20, 10), palette))
+			anim.Delay = append(anim.Delay, 10)
+		}
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, anim); err != nil {
+			t.Fatalf("Failed to encode GIF: %v", err)
+		}
+
+		result, err := Probe(buf.Bytes(), options)
+		if err != nil {
+			t.Fatalf("Probe() error = %v", err)
+		}
+		if result.Format != "gif" || result.FrameCount != 3 || !result.Animated {
+			t.Errorf("Expected 3 frame animated gif, got %+v", result)
+		}
+		if result.Processable || result.OutputWidth != 0 {
+			t.Errorf("Expected gif to be reported as not processable, got %+v", result)
+		}
+		if !result.HasAlpha {
+			t.Error("Expected transparent palette entry to be reported as alpha")
+		}
+	})
+
+	t.Run("lossy webp", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := webp.Encode(&buf, createTestImage(120, 80), &webp.Options{Quality: 80}); err != nil {
+			t.Fatalf("Failed to encode WebP: %v", err)
+		}
+
+		result, err := Probe(buf.Bytes(), options)
+		if err != nil {
+			t.Fatalf("Probe() error = %v", err)
+		}
+		if result.Format != "webp" || result.Width != 120 || result.Height != 80 {
+			t.Errorf("Unexpected format/dimensions: %+v", result)
+		}
+	})
+
+	t.Run("animated webp container", func(t *testing.T) {
+		vp8x := []byte{0x20 | 0x10 | 0x02, 0, 0, 0}
+		vp8x = append(vp8x, 199, 0, 0, 99, 0, 0) // 200x100 canvas
+		body := []byte("WEBP")
+		body = append(body, riffChunk("VP8X", vp8x)...)
+		body = append(body, riffChunk("ANIM", make([]byte, 6))...)
+		body = append(body, riffChunk("ANMF", make([]byte, 16))...)
+		body = append(body, riffChunk("ANMF", make([]byte, 16))...)
+		data := append([]byte("RIFF"), 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(data[4:], uint32(len(body)))
+		data = append(data, body...)
+
+		result, err := Probe(data, options)
+		if err != nil {
+			t.Fatalf("Probe() error = %v", err)
+		}
+		if result.Width != 200 || result.Height != 100 {
+			t.Errorf("Expected 200x100 canvas, got %dx%d", result.Width, result.Height)
+		}
+		if result.FrameCount != 2 || !result.Animated {
+			t.Errorf("Expected 2 animation frames, got %d", result.FrameCount)
+		}
+		if !result.HasICC || !result.HasAlpha {
+			t.Errorf("Expected ICC and alpha flags from VP8X, got %+v", result)
+		}
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		if _, err := Probe([]byte("definitely not an image"), options); err == nil {
+			t.Error("Expected error for invalid data")
+		}
+	})
+}
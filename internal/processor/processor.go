@@ -2,21 +2,35 @@ package processor
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"image"
+	"image/color"
 	_ "image/jpeg" // Register JPEG format
 	_ "image/png"  // Register PNG format
-	"io"
-	"net/http"
+	"log/slog"
+	"math"
 	"time"
 
+	"github.com/Mark-Life/smart-webp-resize/internal/metrics"
+	"github.com/Mark-Life/smart-webp-resize/internal/tracing"
 	"github.com/Mark-Life/smart-webp-resize/pkg/models"
 	"github.com/chai2010/webp"
 	"github.com/disintegration/imaging"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/image/bmp" // Register BMP format
 )
 
+// Encoder settings reported in metadata
+const (
+	encodeLossless     = false
+	resampleFilterName = "lanczos"
+)
+
+// resampleFilter is the filter used when resizing
+var resampleFilter = imaging.Lanczos
+
 // Common errors
 var (
 	ErrProcessingFailed = errors.New("image processing failed")
@@ -27,11 +41,8 @@ var (
 
 // ImageProcessor defines the interface for processing images
 type ImageProcessor interface {
-	// ProcessFromURL processes an image from a URL
-	ProcessFromURL(url string, options *ProcessOptions) ([]byte, *models.ImageMetadata, error)
-	
 	// ProcessFromBytes processes an image from bytes
-	ProcessFromBytes(imageData []byte, options *ProcessOptions) ([]byte, *models.ImageMetadata, error)
+	ProcessFromBytes(ctx context.Context, imageData []byte, options *ProcessOptions) ([]byte, *models.ImageMetadata, error)
 }
 
 // ProcessOptions contains options for image processing
@@ -40,47 +51,87 @@ type ProcessOptions struct {
 	MaxHeight     int
 	Quality       int
 	PreserveRatio bool
+	Fit           string // One of the Fit modes; empty means FitContain
 }
 
-// New creates a new image processor with default settings
-func New() ImageProcessor {
-	return &defaultProcessor{}
+// Fit modes deciding how an image is sized to MaxWidth x MaxHeight. Images
+// are never enlarged.
+const (
+	// FitContain scales the image to fit within the box, keeping its aspect ratio
+	FitContain = "contain"
+	// FitCover scales and center-crops the image to the aspect ratio of the box
+	FitCover = "cover"
+	// FitFill stretches the image to the box, ignoring its aspect ratio
+	FitFill = "fill"
+)
+
+// ValidFit reports whether fit is a supported fit mode
+func ValidFit(fit string) bool {
+	switch fit {
+	case "", FitContain, FitCover, FitFill:
+		return true
+	}
+	return false
 }
 
-// defaultProcessor is the default implementation of ImageProcessor
-type defaultProcessor struct{}
+// fit returns the effective fit mode
+func (o ProcessOptions) fit() string {
+	if o.Fit == "" {
+		return FitContain
+	}
+	return o.Fit
+}
+
+// CacheKey returns a canonical form of the options and encoder settings
+// that determine the output, so that equivalent requests can share cached
+// results
+func (o ProcessOptions) CacheKey() string {
+	return fmt.Sprintf("w=%d;h=%d;q=%d;ratio=%t;fit=%s;filter=%s;lossless=%t",
+		o.MaxWidth, o.MaxHeight, clampQuality(o.Quality), o.PreserveRatio, o.fit(), resampleFilterName, encodeLossless)
+}
 
-// ProcessFromURL implements the ImageProcessor interface
-func (p *defaultProcessor) ProcessFromURL(url string, options *ProcessOptions) ([]byte, *models.ImageMetadata, error) {
-	// Create HTTP client with timeout
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+// Option configures a processor created by New
+type Option func(*defaultProcessor)
+
+// WithLogger sets the logger used for encoder diagnostics
+func WithLogger(logger *slog.Logger) Option {
+	return func(p *defaultProcessor) {
+		p.logger = logger
 	}
-	
-	// Fetch the image
-	resp, err := client.Get(url)
-	if err != nil {
-		return nil, nil, err
+}
+
+// WithMetrics sets where per-stage latencies are recorded
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(p *defaultProcessor) {
+		p.metrics = m
 	}
-	defer resp.Body.Close()
-	
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		return nil, nil, errors.New("failed to fetch image, status: " + resp.Status)
+}
+
+// New creates a new image processor with default settings
+func New(opts ...Option) ImageProcessor {
+	p := &defaultProcessor{}
+	for _, opt := range opts {
+		opt(p)
 	}
-	
-	// Read image data
-	imageData, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, nil, err
+	return p
+}
+
+// defaultProcessor is the default implementation of ImageProcessor
+type defaultProcessor struct {
+	logger  *slog.Logger
+	metrics *metrics.Metrics
+}
+
+// log returns the configured logger, falling back to the process default
+func (p *defaultProcessor) log() *slog.Logger {
+	if p.logger == nil {
+		return slog.Default()
 	}
-	
-	// Process the image data
-	return p.ProcessFromBytes(imageData, options)
+	return p.logger
 }
 
 // ProcessFromBytes implements the ImageProcessor interface
-func (p *defaultProcessor) ProcessFromBytes(imageData []byte, options *ProcessOptions) ([]byte, *models.ImageMetadata, error) {
+func (p *defaultProcessor) ProcessFromBytes(ctx context.Context, imageData []byte, options *ProcessOptions) ([]byte, *models.ImageMetadata, error) {
 	// Set default options if none provided
 	if options == nil {
 		options = &ProcessOptions{
@@ -91,44 +142,81 @@ func (p *defaultProcessor) ProcessFromBytes(imageData []byte, options *ProcessOp
 		}
 	}
 
+	start := time.Now()
+
 	// Detect image format
 	format, err := p.detectImageFormat(imageData)
 	if err != nil {
 		return nil, nil, err
 	}
-	
+
 	// Decode the image
+	_, span := tracing.Start(ctx, "decode",
+		attribute.String("image.format", format),
+		attribute.Int("image.input_bytes", len(imageData)),
+	)
+	stageStart := time.Now()
 	img, err := p.decodeImage(imageData)
 	if err != nil {
+		tracing.End(span, err)
 		return nil, nil, err
 	}
-	
+	decodeDuration := time.Since(stageStart)
+	p.metrics.ObserveStage(metrics.StageDecode, decodeDuration)
+
 	// Get original dimensions and size
 	bounds := img.Bounds()
 	originalWidth := bounds.Dx()
 	originalHeight := bounds.Dy()
 	originalSize := int64(len(imageData))
-	
+	span.SetAttributes(
+		attribute.Int("image.width", originalWidth),
+		attribute.Int("image.height", originalHeight),
+	)
+	span.End()
+
 	// Calculate new dimensions
-	newWidth, newHeight := p.calculateDimensions(
-		originalWidth, 
-		originalHeight, 
-		options.MaxWidth, 
+	newWidth, newHeight := p.fitDimensions(
+		originalWidth,
+		originalHeight,
+		options.MaxWidth,
 		options.MaxHeight,
+		options.fit(),
 	)
-	
+
 	// Resize the image
-	resizedImg, err := p.resizeImage(img, newWidth, newHeight)
+	_, span = tracing.Start(ctx, "resize",
+		attribute.Int("image.width", originalWidth),
+		attribute.Int("image.height", originalHeight),
+		attribute.Int("image.new_width", newWidth),
+		attribute.Int("image.new_height", newHeight),
+	)
+	stageStart = time.Now()
+	resizedImg, err := p.resizeToFit(img, newWidth, newHeight, options.fit())
+	tracing.End(span, err)
 	if err != nil {
 		return nil, nil, err
 	}
-	
+	resizeDuration := time.Since(stageStart)
+	p.metrics.ObserveStage(metrics.StageResize, resizeDuration)
+
 	// Encode to WebP
-	webpData, err := p.encodeToWebP(resizedImg, options.Quality)
+	encodeCtx, span := tracing.Start(ctx, "encode",
+		attribute.Int("image.width", newWidth),
+		attribute.Int("image.height", newHeight),
+		attribute.Int("webp.quality", options.Quality),
+	)
+	stageStart = time.Now()
+	webpData, err := p.encodeToWebP(encodeCtx, resizedImg, options.Quality)
 	if err != nil {
+		tracing.End(span, err)
 		return nil, nil, err
 	}
-	
+	encodeDuration := time.Since(stageStart)
+	p.metrics.ObserveStage(metrics.StageEncode, encodeDuration)
+	span.SetAttributes(attribute.Int("image.output_bytes", len(webpData)))
+	span.End()
+
 	// Create metadata
 	sizeReduction := int(100 * (originalSize - int64(len(webpData))) / originalSize)
 	metadata := &models.ImageMetadata{
@@ -141,8 +229,15 @@ func (p *defaultProcessor) ProcessFromBytes(imageData []byte, options *ProcessOp
 		NewFormat:      "webp",
 		NewSize:        int64(len(webpData)),
 		SizeReduction:  sizeReduction,
+		Timings: models.Timings{
+			DecodeMs: durationMs(decodeDuration),
+			ResizeMs: durationMs(resizeDuration),
+			EncodeMs: durationMs(encodeDuration),
+			TotalMs:  durationMs(time.Since(start)),
+		},
+		Encoder: describeEncoding(img, options.Quality),
 	}
-	
+
 	return webpData, metadata, nil
 }
 
@@ -151,29 +246,29 @@ func (p *defaultProcessor) detectImageFormat(data []byte) (string, error) {
 	if len(data) < 8 {
 		return "", ErrInvalidImage
 	}
-	
+
 	// Check for JPEG signature (FF D8 FF)
 	// JPEG format starts with FF D8 and has an FF marker
 	if len(data) >= 3 && data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF {
 		return "jpeg", nil
 	}
-	
+
 	// Check for PNG signature
 	if bytes.HasPrefix(data, []byte{0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A}) {
 		return "png", nil
 	}
-	
+
 	// Check for BMP signature
 	if len(data) >= 2 && data[0] == 0x42 && data[1] == 0x4D {
 		return "bmp", nil
 	}
-	
+
 	// Check for WebP signature (RIFF....WEBP)
 	if len(data) >= 12 && bytes.HasPrefix(data, []byte{0x52, 0x49, 0x46, 0x46}) &&
 		bytes.Equal(data[8:12], []byte{0x57, 0x45, 0x42, 0x50}) {
 		return "webp", nil
 	}
-	
+
 	return "", ErrInvalidImage
 }
 
@@ -184,21 +279,21 @@ func (p *defaultProcessor) decodeImage(data []byte) (image.Image, error) {
 	if err != nil {
 		// Try specific decoders if the standard one fails
 		reader.Reset(data)
-		
+
 		// Try BMP decoder
 		if img, err = bmp.Decode(reader); err == nil {
 			return img, nil
 		}
-		
+
 		// Try WebP decoder
 		reader.Reset(data)
 		if img, err = webp.Decode(reader); err == nil {
 			return img, nil
 		}
-		
+
 		return nil, ErrInvalidImage
 	}
-	
+
 	return img, nil
 }
 
@@ -208,21 +303,21 @@ func (p *defaultProcessor) calculateDimensions(originalWidth, originalHeight, ma
 	if originalWidth <= maxWidth && originalHeight <= maxHeight {
 		return originalWidth, originalHeight
 	}
-	
+
 	// Calculate scaling factors for width and height
 	widthScale := float64(maxWidth) / float64(originalWidth)
 	heightScale := float64(maxHeight) / float64(originalHeight)
-	
+
 	// Use the smaller scale to ensure the image fits within both dimensions
 	scale := widthScale
 	if heightScale < widthScale {
 		scale = heightScale
 	}
-	
+
 	// Calculate new dimensions - round to nearest integer
-	newWidth := int(float64(originalWidth) * scale + 0.5)
-	newHeight := int(float64(originalHeight) * scale + 0.5)
-	
+	newWidth := int(float64(originalWidth)*scale + 0.5)
+	newHeight := int(float64(originalHeight)*scale + 0.5)
+
 	// Ensure at least 1 pixel in each dimension
 	if newWidth < 1 {
 		newWidth = 1
@@ -230,14 +325,44 @@ func (p *defaultProcessor) calculateDimensions(originalWidth, originalHeight, ma
 	if newHeight < 1 {
 		newHeight = 1
 	}
-	
+
 	return newWidth, newHeight
 }
 
+// fitDimensions calculates the output dimensions for a fit mode
+func (p *defaultProcessor) fitDimensions(originalWidth, originalHeight, maxWidth, maxHeight int, fit string) (int, int) {
+	switch fit {
+	case FitCover:
+		// Shrink the box rather than enlarge the image to cover it
+		scale := math.Max(float64(maxWidth)/float64(originalWidth), float64(maxHeight)/float64(originalHeight))
+		if scale <= 1 {
+			return maxWidth, maxHeight
+		}
+		return max(int(float64(maxWidth)/scale+0.5), 1), max(int(float64(maxHeight)/scale+0.5), 1)
+	case FitFill:
+		return min(originalWidth, maxWidth), min(originalHeight, maxHeight)
+	default:
+		return p.calculateDimensions(originalWidth, originalHeight, maxWidth, maxHeight)
+	}
+}
+
+// resizeToFit resizes the image to the specified dimensions, cropping it
+// to their aspect ratio first for FitCover
+func (p *defaultProcessor) resizeToFit(img image.Image, width, height int, fit string) (image.Image, error) {
+	if fit != FitCover {
+		return p.resizeImage(img, width, height)
+	}
+	resized := imaging.Fill(img, width, height, imaging.Center, resampleFilter)
+	if resized == nil {
+		return nil, ErrResizingFailed
+	}
+	return resized, nil
+}
+
 // resizeImage resizes the image to the specified dimensions
 func (p *defaultProcessor) resizeImage(img image.Image, width, height int) (image.Image, error) {
 	// Use Lanczos resampling for high quality
-	resized := imaging.Resize(img, width, height, imaging.Lanczos)
+	resized := imaging.Resize(img, width, height, resampleFilter)
 	if resized == nil {
 		return nil, ErrResizingFailed
 	}
@@ -245,37 +370,110 @@ func (p *defaultProcessor) resizeImage(img image.Image, width, height int) (imag
 }
 
 // encodeToWebP encodes the image to WebP format with the specified quality
-func (p *defaultProcessor) encodeToWebP(img image.Image, quality int) ([]byte, error) {
-	// Adjust quality to be within valid range (0-100)
-	if quality < 0 {
-		quality = 0
-	} else if quality > 100 {
-		quality = 100
-	}
-	
-	fmt.Printf("Encoding image to WebP with quality: %d\n", quality)
-	
+func (p *defaultProcessor) encodeToWebP(ctx context.Context, img image.Image, quality int) ([]byte, error) {
+	quality = clampQuality(quality)
+
+	logger := p.log()
+	logger.DebugContext(ctx, "encoding image to WebP", slog.Int("quality", quality))
+
 	// Encode to WebP
 	var buf bytes.Buffer
 	err := webp.Encode(&buf, img, &webp.Options{
-		Lossless: false,
+		Lossless: encodeLossless,
 		Quality:  float32(quality),
 	})
 	if err != nil {
-		fmt.Printf("WebP encoding failed: %v\n", err)
+		logger.ErrorContext(ctx, "WebP encoding failed", slog.Any("error", err))
 		return nil, ErrEncodingFailed
 	}
-	
+
 	webpData := buf.Bytes()
-	fmt.Printf("WebP encoding successful, output size: %d bytes\n", len(webpData))
-	
+
 	// Verify the WebP header - it should start with RIFF....WEBP
-	if len(webpData) >= 12 && bytes.HasPrefix(webpData, []byte{0x52, 0x49, 0x46, 0x46}) &&
-		bytes.Equal(webpData[8:12], []byte{0x57, 0x45, 0x42, 0x50}) {
-		fmt.Println("Output verified as valid WebP format")
-	} else {
-		fmt.Println("WARNING: Output does not have valid WebP header!")
+	if len(webpData) < 12 || !bytes.HasPrefix(webpData, []byte{0x52, 0x49, 0x46, 0x46}) ||
+		!bytes.Equal(webpData[8:12], []byte{0x57, 0x45, 0x42, 0x50}) {
+		logger.WarnContext(ctx, "encoder output does not have a valid WebP header", slog.Int("output_bytes", len(webpData)))
 	}
-	
+
+	logger.DebugContext(ctx, "WebP encoding successful", slog.Int("output_bytes", len(webpData)))
+
 	return webpData, nil
-} 
\ No newline at end of file
+}
+
+// clampQuality adjusts quality to be within the valid range (0-100)
+func clampQuality(quality int) int {
+	if quality < 0 {
+		return 0
+	} else if quality > 100 {
+		return 100
+	}
+	return quality
+}
+
+// describeEncoding reports the encoder settings used for img and the pixel
+// format of the decoded source
+func describeEncoding(img image.Image, quality int) models.EncoderDetails {
+	colorModel, bitDepth := describeColorModel(img.ColorModel())
+	return models.EncoderDetails{
+		Quality:    clampQuality(quality),
+		Lossless:   encodeLossless,
+		Filter:     resampleFilterName,
+		HasAlpha:   hasAlpha(img),
+		ColorModel: colorModel,
+		BitDepth:   bitDepth,
+	}
+}
+
+// describeColorModel returns a name and per-channel bit depth for a color model
+func describeColorModel(model color.Model) (string, int) {
+	switch model {
+	case color.RGBAModel:
+		return "RGBA", 8
+	case color.RGBA64Model:
+		return "RGBA64", 16
+	case color.NRGBAModel:
+		return "NRGBA", 8
+	case color.NRGBA64Model:
+		return "NRGBA64", 16
+	case color.AlphaModel:
+		return "Alpha", 8
+	case color.Alpha16Model:
+		return "Alpha16", 16
+	case color.GrayModel:
+		return "Gray", 8
+	case color.Gray16Model:
+		return "Gray16", 16
+	case color.CMYKModel:
+		return "CMYK", 8
+	case color.YCbCrModel:
+		return "YCbCr", 8
+	case color.NYCbCrAModel:
+		return "NYCbCrA", 8
+	}
+	if _, ok := model.(color.Palette); ok {
+		return "Paletted", 8
+	}
+	return "unknown", 8
+}
+
+// hasAlpha reports whether any pixel of img is not fully opaque
+func hasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// durationMs converts a duration to fractional milliseconds
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
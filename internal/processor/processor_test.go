@@ -2,12 +2,13 @@ package processor
 
 import (
 	"bytes"
+	"context"
 	"image"
 	"image/color"
 	"image/png"
-	"net/http"
-	"net/http/httptest"
 	"testing"
+
+	"github.com/chai2010/webp"
 )
 
 func TestProcessorCreation(t *testing.T) {
@@ -162,37 +163,37 @@ func TestDetectImageFormat(t *testing.T) {
 func TestDecodeImage(t *testing.T) {
 	// Create a simple test image
 	img := createTestImage(100, 100)
-	
+
 	// Encode it to PNG
 	var buf bytes.Buffer
 	err := png.Encode(&buf, img)
 	if err != nil {
 		t.Fatalf("Failed to encode test image: %v", err)
 	}
-	
+
 	// Get the PNG data
 	pngData := buf.Bytes()
-	
+
 	processor := &defaultProcessor{}
-	
+
 	// Test decoding
 	decodedImg, err := processor.decodeImage(pngData)
 	if err != nil {
 		t.Errorf("Failed to decode valid image: %v", err)
 	}
-	
+
 	if decodedImg == nil {
 		t.Error("Decoded image is nil")
 		return
 	}
-	
+
 	// Check dimensions
 	bounds := decodedImg.Bounds()
 	if bounds.Dx() != 100 || bounds.Dy() != 100 {
-		t.Errorf("Decoded image has wrong dimensions: got %dx%d, want 100x100", 
+		t.Errorf("Decoded image has wrong dimensions: got %dx%d, want 100x100",
 			bounds.Dx(), bounds.Dy())
 	}
-	
+
 	// Test invalid data
 	_, err = processor.decodeImage([]byte{0, 1, 2, 3})
 	if err == nil {
@@ -203,7 +204,7 @@ func TestDecodeImage(t *testing.T) {
 // Helper function to create a test image
 func createTestImage(width, height int) image.Image {
 	img := image.NewRGBA(image.Rect(0, 0, width, height))
-	
+
 	// Fill with a gradient
 	for y := 0; y < height; y++ {
 		for x := 0; x < width; x++ {
@@ -215,47 +216,47 @@ func createTestImage(width, height int) image.Image {
 			})
 		}
 	}
-	
+
 	return img
 }
 
 func TestResizeImage(t *testing.T) {
 	// Create a test image of 200x100
 	img := createTestImage(200, 100)
-	
+
 	processor := &defaultProcessor{}
-	
+
 	// Test resize to smaller dimensions
 	resized, err := processor.resizeImage(img, 100, 50)
 	if err != nil {
 		t.Errorf("Failed to resize image: %v", err)
 	}
-	
+
 	if resized == nil {
 		t.Error("Resized image is nil")
 		return
 	}
-	
+
 	bounds := resized.Bounds()
 	if bounds.Dx() != 100 || bounds.Dy() != 50 {
-		t.Errorf("Resized image has wrong dimensions: got %dx%d, want 100x50", 
+		t.Errorf("Resized image has wrong dimensions: got %dx%d, want 100x50",
 			bounds.Dx(), bounds.Dy())
 	}
-	
+
 	// Test resize to larger dimensions
 	resized, err = processor.resizeImage(img, 400, 200)
 	if err != nil {
 		t.Errorf("Failed to resize image: %v", err)
 	}
-	
+
 	if resized == nil {
 		t.Error("Resized image is nil")
 		return
 	}
-	
+
 	bounds = resized.Bounds()
 	if bounds.Dx() != 400 || bounds.Dy() != 200 {
-		t.Errorf("Resized image has wrong dimensions: got %dx%d, want 400x200", 
+		t.Errorf("Resized image has wrong dimensions: got %dx%d, want 400x200",
 			bounds.Dx(), bounds.Dy())
 	}
 }
@@ -263,31 +264,31 @@ func TestResizeImage(t *testing.T) {
 func TestEncodeToWebP(t *testing.T) {
 	// Create a test image
 	img := createTestImage(100, 100)
-	
+
 	processor := &defaultProcessor{}
-	
+
 	// Test with default quality
-	webpData, err := processor.encodeToWebP(img, 80)
+	webpData, err := processor.encodeToWebP(context.Background(), img, 80)
 	if err != nil {
 		t.Errorf("Failed to encode to WebP: %v", err)
 	}
-	
+
 	if len(webpData) == 0 {
 		t.Error("WebP data is empty")
 	}
-	
+
 	// Test with low quality
-	lowQualityData, err := processor.encodeToWebP(img, 10)
+	lowQualityData, err := processor.encodeToWebP(context.Background(), img, 10)
 	if err != nil {
 		t.Errorf("Failed to encode to WebP with low quality: %v", err)
 	}
-	
+
 	// Test with high quality
-	highQualityData, err := processor.encodeToWebP(img, 90)
+	highQualityData, err := processor.encodeToWebP(context.Background(), img, 90)
 	if err != nil {
 		t.Errorf("Failed to encode to WebP with high quality: %v", err)
 	}
-	
+
 	// Lower quality should generally result in smaller file size
 	if len(lowQualityData) >= len(highQualityData) {
 		t.Log("Expected lower quality to produce smaller file, but it didn't. This might be OK for very small test images.")
@@ -297,19 +298,19 @@ func TestEncodeToWebP(t *testing.T) {
 func TestProcessFromBytes(t *testing.T) {
 	// Create a test image
 	img := createTestImage(500, 300)
-	
+
 	// Encode it to PNG
 	var buf bytes.Buffer
 	err := png.Encode(&buf, img)
 	if err != nil {
 		t.Fatalf("Failed to encode test image: %v", err)
 	}
-	
+
 	// Get the PNG data
 	pngData := buf.Bytes()
-	
+
 	processor := &defaultProcessor{}
-	
+
 	// Test processing with default options
 	options := &ProcessOptions{
 		MaxWidth:      300,
@@ -317,97 +318,150 @@ func TestProcessFromBytes(t *testing.T) {
 		Quality:       80,
 		PreserveRatio: true,
 	}
-	
-	webpData, metadata, err := processor.ProcessFromBytes(pngData, options)
+
+	webpData, metadata, err := processor.ProcessFromBytes(context.Background(), pngData, options)
 	if err != nil {
 		t.Errorf("Failed to process image: %v", err)
 	}
-	
+
 	if webpData == nil {
 		t.Error("WebP data is nil")
 		return
 	}
-	
+
 	// Check metadata
 	if metadata.OriginalWidth != 500 || metadata.OriginalHeight != 300 {
-		t.Errorf("Wrong original dimensions in metadata: got %dx%d, want 500x300", 
+		t.Errorf("Wrong original dimensions in metadata: got %dx%d, want 500x300",
 			metadata.OriginalWidth, metadata.OriginalHeight)
 	}
-	
+
 	if metadata.NewWidth != 300 || metadata.NewHeight != 180 {
-		t.Errorf("Wrong new dimensions in metadata: got %dx%d, want 300x180", 
+		t.Errorf("Wrong new dimensions in metadata: got %dx%d, want 300x180",
 			metadata.NewWidth, metadata.NewHeight)
 	}
-	
+
 	if metadata.OriginalFormat != "png" {
 		t.Errorf("Wrong original format in metadata: got %s, want png", metadata.OriginalFormat)
 	}
-	
+
 	if metadata.NewFormat != "webp" {
 		t.Errorf("Wrong new format in metadata: got %s, want webp", metadata.NewFormat)
 	}
 }
 
-func TestProcessFromURL(t *testing.T) {
-	// Create a test image
-	img := createTestImage(500, 300)
-	
-	// Encode it to PNG
+func TestProcessFromBytesFit(t *testing.T) {
 	var buf bytes.Buffer
-	err := png.Encode(&buf, img)
-	if err != nil {
+	if err := png.Encode(&buf, createTestImage(500, 300)); err != nil {
 		t.Fatalf("Failed to encode test image: %v", err)
 	}
-	
-	// Set up a test server
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "image/png")
-		w.Write(buf.Bytes())
-	}))
-	defer server.Close()
-	
+
+	tests := []struct {
+		name           string
+		maxWidth       int
+		maxHeight      int
+		fit            string
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{"default contains", 200, 200, "", 200, 120},
+		{"contain", 200, 200, FitContain, 200, 120},
+		{"cover", 200, 200, FitCover, 200, 200},
+		{"cover wide box", 400, 100, FitCover, 400, 100},
+		{"cover does not enlarge", 1000, 1000, FitCover, 300, 300},
+		{"fill", 200, 200, FitFill, 200, 200},
+		{"fill does not enlarge", 1000, 100, FitFill, 500, 100},
+	}
+
 	processor := &defaultProcessor{}
-	
-	// Test processing with default options
-	options := &ProcessOptions{
-		MaxWidth:      300,
-		MaxHeight:     200,
-		Quality:       80,
-		PreserveRatio: true,
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webpData, metadata, err := processor.ProcessFromBytes(context.Background(), buf.Bytes(), &ProcessOptions{
+				MaxWidth:  tt.maxWidth,
+				MaxHeight: tt.maxHeight,
+				Quality:   80,
+				Fit:       tt.fit,
+			})
+			if err != nil {
+				t.Fatalf("Failed to process image: %v", err)
+			}
+
+			if metadata.NewWidth != tt.expectedWidth || metadata.NewHeight != tt.expectedHeight {
+				t.Errorf("Wrong new dimensions in metadata: got %dx%d, want %dx%d",
+					metadata.NewWidth, metadata.NewHeight, tt.expectedWidth, tt.expectedHeight)
+			}
+			config, err := webp.DecodeConfig(bytes.NewReader(webpData))
+			if err != nil {
+				t.Fatalf("Failed to decode output: %v", err)
+			}
+			if config.Width != tt.expectedWidth || config.Height != tt.expectedHeight {
+				t.Errorf("Wrong output dimensions: got %dx%d, want %dx%d",
+					config.Width, config.Height, tt.expectedWidth, tt.expectedHeight)
+			}
+		})
 	}
-	
-	webpData, metadata, err := processor.ProcessFromURL(server.URL, options)
+}
+
+func TestProcessFromBytesEncoderDetails(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(200, 100)); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+
+	processor := &defaultProcessor{}
+	_, metadata, err := processor.ProcessFromBytes(context.Background(), buf.Bytes(), &ProcessOptions{
+		MaxWidth:  100,
+		MaxHeight: 100,
+		Quality:   150,
+	})
 	if err != nil {
-		t.Errorf("Failed to process image from URL: %v", err)
+		t.Fatalf("Failed to process image: %v", err)
 	}
-	
-	if webpData == nil {
-		t.Error("WebP data is nil")
-		return
+
+	encoder := metadata.Encoder
+	if encoder.Quality != 100 {
+		t.Errorf("Expected clamped quality 100, got %d", encoder.Quality)
 	}
-	
-	// Check metadata
-	if metadata.OriginalWidth != 500 || metadata.OriginalHeight != 300 {
-		t.Errorf("Wrong original dimensions in metadata: got %dx%d, want 500x300", 
-			metadata.OriginalWidth, metadata.OriginalHeight)
+	if encoder.Lossless {
+		t.Error("Expected lossy encoding")
 	}
-	
-	if metadata.NewWidth != 300 || metadata.NewHeight != 180 {
-		t.Errorf("Wrong new dimensions in metadata: got %dx%d, want 300x180", 
-			metadata.NewWidth, metadata.NewHeight)
+	if encoder.Filter != "lanczos" {
+		t.Errorf("Expected lanczos filter, got %s", encoder.Filter)
 	}
-	
-	if metadata.OriginalFormat != "png" {
-		t.Errorf("Wrong original format in metadata: got %s, want png", metadata.OriginalFormat)
+	if encoder.ColorModel == "" || encoder.BitDepth == 0 {
+		t.Errorf("Expected color model and bit depth, got %q/%d", encoder.ColorModel, encoder.BitDepth)
 	}
-	
-	if metadata.NewFormat != "webp" {
-		t.Errorf("Wrong new format in metadata: got %s, want webp", metadata.NewFormat)
+
+	timings := metadata.Timings
+	if timings.TotalMs < timings.DecodeMs+timings.ResizeMs+timings.EncodeMs {
+		t.Errorf("Expected total %.3fms to cover the stages %+v", timings.TotalMs, timings)
 	}
-	
-	// Test with invalid URL
-	_, _, err = processor.ProcessFromURL("http://invalid-domain-that-should-not-exist.xyz", options)
-	if err == nil {
-		t.Error("Expected error for invalid URL, got nil")
+	if timings.FetchMs != 0 {
+		t.Errorf("Expected no fetch time for in-memory input, got %.3f", timings.FetchMs)
 	}
-} 
\ No newline at end of file
+}
+
+func TestDescribeColorModel(t *testing.T) {
+	tests := []struct {
+		img       image.Image
+		wantModel string
+		wantDepth int
+		wantAlpha bool
+	}{
+		{image.NewRGBA(image.Rect(0, 0, 1, 1)), "RGBA", 8, true},
+		{image.NewGray16(image.Rect(0, 0, 1, 1)), "Gray16", 16, false},
+		{image.NewYCbCr(image.Rect(0, 0, 1, 1), image.YCbCrSubsampleRatio420), "YCbCr", 8, false},
+		{image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), "Paletted", 8, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.wantModel, func(t *testing.T) {
+			details := describeEncoding(tt.img, 80)
+			if details.ColorModel != tt.wantModel || details.BitDepth != tt.wantDepth {
+				t.Errorf("Expected %s/%d, got %s/%d", tt.wantModel, tt.wantDepth, details.ColorModel, details.BitDepth)
+			}
+			if details.HasAlpha != tt.wantAlpha {
+				t.Errorf("Expected has_alpha %v, got %v", tt.wantAlpha, details.HasAlpha)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"image/png"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestProcessFromBytesSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(400, 200)); err != nil {
+		t.Fatalf("Failed to encode test image: %v", err)
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	processor := &defaultProcessor{}
+	_, _, err := processor.ProcessFromBytes(ctx, buf.Bytes(), &ProcessOptions{
+		MaxWidth:  200,
+		MaxHeight: 200,
+		Quality:   80,
+	})
+	parent.End()
+	if err != nil {
+		t.Fatalf("Failed to process image: %v", err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	for _, name := range []string{"decode", "resize", "encode"} {
+		span, ok := spans[name]
+		if !ok {
+			t.Errorf("Expected a %s span", name)
+			continue
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("Expected %s span to be a child of the request span", name)
+		}
+	}
+
+	attrs := map[string]int64{}
+	for _, kv := range spans["resize"].Attributes() {
+		attrs[string(kv.Key)] = kv.Value.AsInt64()
+	}
+	if attrs["image.new_width"] != 200 || attrs["image.new_height"] != 100 {
+		t.Errorf("Expected resize span to record 200x100, got %dx%d",
+			attrs["image.new_width"], attrs["image.new_height"])
+	}
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ClientIdentifier tells clients apart, by a client ID header or by IP
+// address. Client IDs and addresses in proxy headers are only believed from
+// trusted proxies.
+type ClientIdentifier struct {
+	// Header, if set, names a header in which a trusted proxy identifies
+	// the client, such as X-Client-ID. Requests without it, or not sent by
+	// a trusted proxy, are identified by address.
+	Header string
+
+	// ProxyHeaders are headers carrying the client address, such as
+	// X-Forwarded-For or X-Real-IP, in order of preference. Defaults to
+	// X-Forwarded-For.
+	ProxyHeaders []string
+
+	// TrustedProxies are the networks whose proxy headers are believed
+	TrustedProxies []netip.Prefix
+}
+
+// Identify returns the client ID of r. Clients could otherwise choose a
+// fresh ID for every request, so a client ID header is only used from a
+// trusted proxy.
+func (c ClientIdentifier) Identify(r *http.Request) string {
+	if c.Header != "" && c.trusted(remoteAddr(r)) {
+		if id := r.Header.Get(c.Header); id != "" {
+			return "id:" + id
+		}
+	}
+	return "ip:" + c.ClientIP(r).String()
+}
+
+// ClientIP returns the address of the client that made r. Proxy headers
+// are only used if the request came from a trusted proxy; in a forwarding
+// chain the nearest address not belonging to a trusted proxy is used.
+func (c ClientIdentifier) ClientIP(r *http.Request) netip.Addr {
+	peer := remoteAddr(r)
+	if !c.trusted(peer) {
+		return peer
+	}
+	headers := c.ProxyHeaders
+	if len(headers) == 0 {
+		headers = []string{"X-Forwarded-For"}
+	}
+	for _, name := range headers {
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		addrs := strings.Split(strings.Join(values, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(strings.TrimSpace(addrs[i]))
+			if err != nil {
+				break // Nothing before a malformed entry can be trusted
+			}
+			addr = addr.Unmap()
+			if i == 0 || !c.trusted(addr) {
+				return addr
+			}
+		}
+	}
+	return peer
+}
+
+// trusted reports whether addr belongs to a trusted proxy
+func (c ClientIdentifier) trusted(addr netip.Addr) bool {
+	for _, prefix := range c.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr returns the address of the peer that sent r
+func remoteAddr(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, _ := netip.ParseAddr(host)
+	return addr.Unmap()
+}
@@ -0,0 +1,243 @@
+// Package ratelimit limits how fast each client may make requests and send
+// or have the service download image data, using token buckets.
+//
+// Every client has a bucket of requests and one of bytes. A request takes
+// one request token and is refused with 429 Too Many Requests if there is
+// none left. Input bytes are taken from the bytes bucket as they arrive,
+// which may leave it in debt: a client that just sent a large image has to
+// wait for the bucket to refill before its next request is accepted, so
+// large inputs are throttled harder than small ones.
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Config sets the rates and how clients are told apart
+type Config struct {
+	// RequestsPerSecond refills each client's request bucket, which holds
+	// up to RequestBurst requests. Zero disables the request limit.
+	RequestsPerSecond float64
+	RequestBurst      int
+
+	// BytesPerSecond refills each client's bytes bucket, which holds up to
+	// ByteBurst bytes. Zero disables the bytes limit.
+	BytesPerSecond float64
+	ByteBurst      int64
+
+	// Clients identifies the client of a request
+	Clients ClientIdentifier
+}
+
+// sweepInterval is how often clients with full buckets are forgotten
+const sweepInterval = time.Minute
+
+// Limiter tracks the buckets of every client. A nil *Limiter lets every
+// request through.
+type Limiter struct {
+	config Config
+	now    func() time.Time
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+// client holds the buckets of one client
+type client struct {
+	requests bucket
+	bytes    bucket
+}
+
+// New creates a Limiter, or returns nil if cfg limits nothing
+func New(cfg Config) *Limiter {
+	if cfg.RequestsPerSecond <= 0 && cfg.BytesPerSecond <= 0 {
+		return nil
+	}
+	if cfg.RequestBurst < 1 {
+		cfg.RequestBurst = max(1, int(math.Ceil(cfg.RequestsPerSecond)))
+	}
+	if cfg.ByteBurst < 1 {
+		cfg.ByteBurst = max(1, int64(math.Ceil(cfg.BytesPerSecond)))
+	}
+	return &Limiter{config: cfg, now: time.Now, clients: map[string]*client{}}
+}
+
+// decision is the outcome of letting a request in
+type decision struct {
+	allowed    bool
+	remaining  int           // Requests left after this one
+	reset      time.Duration // Until the request bucket is full again
+	retryAfter time.Duration // Until a refused request would be allowed
+}
+
+// allow takes a request token from a client, and contentLength bytes if
+// known. A request is refused if there is no request token or the bytes
+// bucket is in debt.
+func (l *Limiter) allow(id string, contentLength int64) decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	c := l.clients[id]
+	if c == nil {
+		c = &client{
+			requests: newBucket(float64(l.config.RequestBurst), now),
+			bytes:    newBucket(float64(l.config.ByteBurst), now),
+		}
+		l.clients[id] = c
+	}
+	requestRate, byteRate := l.config.RequestsPerSecond, l.config.BytesPerSecond
+	c.requests.refill(now, requestRate, float64(l.config.RequestBurst))
+	c.bytes.refill(now, byteRate, float64(l.config.ByteBurst))
+
+	d := decision{allowed: true}
+	if requestRate > 0 && c.requests.tokens < 1 {
+		d.allowed = false
+		d.retryAfter = c.requests.until(1, requestRate)
+	}
+	if byteRate > 0 && c.bytes.tokens <= 0 {
+		d.allowed = false
+		d.retryAfter = max(d.retryAfter, c.bytes.until(1, byteRate))
+	}
+	if d.allowed {
+		if requestRate > 0 {
+			c.requests.tokens--
+		}
+		if byteRate > 0 && contentLength > 0 {
+			c.bytes.tokens -= float64(contentLength)
+		}
+	}
+	if requestRate > 0 {
+		d.remaining = max(0, int(c.requests.tokens))
+		d.reset = c.requests.until(float64(l.config.RequestBurst), requestRate)
+	}
+	return d
+}
+
+// charge takes n bytes from a client's bytes bucket, or returns them if n
+// is negative
+func (l *Limiter) charge(id string, n int64) {
+	if l.config.BytesPerSecond <= 0 || n == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.clients[id]
+	if c == nil {
+		return // Forgotten while idle, so it has nothing to pay back
+	}
+	c.bytes.refill(l.now(), l.config.BytesPerSecond, float64(l.config.ByteBurst))
+	c.bytes.tokens = min(c.bytes.tokens-float64(n), float64(l.config.ByteBurst))
+}
+
+// sweep forgets clients whose buckets have refilled, which is the state a
+// new client starts in. l.mu must be held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for id, c := range l.clients {
+		c.requests.refill(now, l.config.RequestsPerSecond, float64(l.config.RequestBurst))
+		c.bytes.refill(now, l.config.BytesPerSecond, float64(l.config.ByteBurst))
+		if c.requests.tokens >= float64(l.config.RequestBurst) && c.bytes.tokens >= float64(l.config.ByteBurst) {
+			delete(l.clients, id)
+		}
+	}
+}
+
+// chargeContextKey is the context key of the charge function of a request
+type chargeContextKey struct{}
+
+// Charge takes n bytes of input from the bytes bucket of the client that
+// made the request of ctx, e.g. for an image downloaded on its behalf.
+// Request bodies are charged automatically.
+func Charge(ctx context.Context, n int64) {
+	if charge, ok := ctx.Value(chargeContextKey{}).(func(int64)); ok {
+		charge(n)
+	}
+}
+
+// Limit refuses requests from clients over their rate with 429 Too Many
+// Requests. Responses carry RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers describing the client's request bucket.
+func (l *Limiter) Limit(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := l.config.Clients.Identify(r)
+		d := l.allow(id, r.ContentLength)
+
+		if l.config.RequestsPerSecond > 0 {
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(l.config.RequestBurst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(d.remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(d.reset)))
+		}
+		if !d.allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, seconds(d.retryAfter))))
+			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		// The declared length was charged up front; what is actually read
+		// replaces it once the request is done
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		charge := func(n int64) { l.charge(id, n) }
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), chargeContextKey{}, charge)))
+		l.charge(id, body.n-max(r.ContentLength, 0))
+	})
+}
+
+// seconds rounds d up to whole seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// bucket is a token bucket
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func newBucket(tokens float64, now time.Time) bucket {
+	return bucket{tokens: tokens, updated: now}
+}
+
+// refill adds the tokens accrued since the last refill, up to capacity
+func (b *bucket) refill(now time.Time, rate, capacity float64) {
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = min(capacity, b.tokens+elapsed*rate)
+	}
+	b.updated = now
+}
+
+// until returns how long it takes the bucket to hold tokens
+func (b *bucket) until(tokens, rate float64) time.Duration {
+	if b.tokens >= tokens {
+		return 0
+	}
+	return time.Duration((tokens - b.tokens) / rate * float64(time.Second))
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
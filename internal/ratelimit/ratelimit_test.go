@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testLimiter returns a limiter whose clock only moves when told to
+func testLimiter(cfg Config) (*Limiter, func(time.Duration)) {
+	l := New(cfg)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLimitRequests(t *testing.T) {
+	l, advance := testLimiter(Config{RequestsPerSecond: 2, RequestBurst: 3})
+	h := l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/process/url", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, wantRemaining := range []string{"2", "1", "0"} {
+		w := serve("192.0.2.1:1234")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected request %d to be allowed, got %d", i, w.Code)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != wantRemaining {
+			t.Errorf("Expected RateLimit-Remaining %s, got %s", wantRemaining, got)
+		}
+		if got := w.Header().Get("RateLimit-Limit"); got != "3" {
+			t.Errorf("Expected RateLimit-Limit 3, got %s", got)
+		}
+	}
+
+	w := serve("192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "1" || w.Header().Get("RateLimit-Reset") != "2" {
+		t.Errorf("Unexpected headers %v", w.Header())
+	}
+	if w := serve("192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("Expected other clients to be allowed, got %d", w.Code)
+	}
+
+	advance(500 * time.Millisecond)
+	if w := serve("192.0.2.1:1234"); w.Code != http.StatusOK {
+		t.Errorf("Expected a refilled token to be used, got %d", w.Code)
+	}
+	if w := serve("192.0.2.1:1234"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", w.Code)
+	}
+}
+
+func TestLimitBytes(t *testing.T) {
+	l, advance := testLimiter(Config{BytesPerSecond: 100, ByteBurst: 200})
+	h := l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		if fetched := r.URL.Query().Get("fetched"); fetched != "" {
+			Charge(r.Context(), int64(len(fetched)))
+		}
+	}))
+	serve := func(body, query string, chunked bool) int {
+		req := httptest.NewRequest("POST", "/process/upload"+query, strings.NewReader(body))
+		if chunked {
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Header().Get("RateLimit-Limit") != "" {
+			t.Errorf("Expected no request headers without a request limit")
+		}
+		return w.Code
+	}
+
+	// A large upload is let in, but puts the client in debt
+	if code := serve(strings.Repeat("x", 500), "", false); code != http.StatusOK {
+		t.Fatalf("Expected the first upload to be allowed, got %d", code)
+	}
+	if code := serve("x", "", false); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 while in debt, got %d", code)
+	}
+	advance(3 * time.Second)
+	if code := serve("x", "", false); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 while in debt, got %d", code)
+	}
+
+	// Bodies of unknown length and fetched images are charged too
+	advance(time.Second)
+	if code := serve(strings.Repeat("x", 50), "?fetched="+strings.Repeat("y", 60), true); code != http.StatusOK {
+		t.Fatalf("Expected the request to be allowed, got %d", code)
+	}
+	if code := serve("x", "", false); code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 after a chunked upload and fetch, got %d", code)
+	}
+	advance(1200 * time.Millisecond)
+	if code := serve("x", "", false); code != http.StatusOK {
+		t.Errorf("Expected the bucket to refill, got %d", code)
+	}
+}
+
+func TestNewDisabled(t *testing.T) {
+	l := New(Config{})
+	if l != nil {
+		t.Fatal("Expected no limiter without rates")
+	}
+	called := false
+	l.Limit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if !called {
+		t.Error("Expected a nil limiter to let requests through")
+	}
+	Charge(context.Background(), 10) // Does nothing outside a limited request
+}
+
+func TestSweep(t *testing.T) {
+	l, advance := testLimiter(Config{RequestsPerSecond: 1, RequestBurst: 1})
+	l.allow("a", 0)
+	l.allow("b", 0)
+	advance(2 * sweepInterval)
+	l.allow("c", 0)
+	if len(l.clients) != 1 {
+		t.Errorf("Expected idle clients to be forgotten, got %d clients", len(l.clients))
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	identifier := ClientIdentifier{
+		Header:         "X-Client-ID",
+		ProxyHeaders:   []string{"X-Forwarded-For", "X-Real-IP"},
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{name: "peer", remoteAddr: "192.0.2.1:1234", expected: "ip:192.0.2.1"},
+		{name: "client id", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Client-ID": "app"}, expected: "id:app"},
+		{name: "untrusted client id", remoteAddr: "192.0.2.1:1234", headers: map[string]string{"X-Client-ID": "app"}, expected: "ip:192.0.2.1"},
+		{name: "untrusted proxy", remoteAddr: "192.0.2.1:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.7"}, expected: "ip:192.0.2.1"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "198.51.100.7"}, expected: "ip:198.51.100.7"},
+		{name: "spoofed entry", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "203.0.113.9, 198.51.100.7, 10.0.0.2"}, expected: "ip:198.51.100.7"},
+		{name: "only proxies", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, expected: "ip:10.0.0.3"},
+		{name: "malformed", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Forwarded-For": "unknown"}, expected: "ip:10.0.0.1"},
+		{name: "second header", remoteAddr: "10.0.0.1:1234", headers: map[string]string{"X-Real-IP": "198.51.100.8"}, expected: "ip:198.51.100.8"},
+		{name: "ipv6", remoteAddr: "[2001:db8::1]:1234", expected: "ip:2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			if got := identifier.Identify(req); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultURLExpiry is how long presigned URLs stay valid by default
+const defaultURLExpiry = time.Hour
+
+// maxURLExpiry is the longest validity S3 accepts for presigned URLs
+const maxURLExpiry = 7 * 24 * time.Hour
+
+// S3Config configures an S3-compatible backend
+type S3Config struct {
+	// Endpoint is the service URL, e.g. https://s3.eu-west-1.amazonaws.com
+	// or http://localhost:9000 for MinIO. Defaults to the AWS endpoint for
+	// Region.
+	Endpoint string
+
+	Region      string
+	Bucket      string
+	Credentials Credentials
+
+	// PathStyle addresses the bucket as endpoint/bucket/key instead of
+	// bucket.endpoint/key, as MinIO and most stand-ins require
+	PathStyle bool
+
+	// PublicURL, if set, is the base of the URLs returned by Put, e.g. a
+	// CDN in front of a public bucket. Otherwise Put returns presigned URLs
+	// valid for URLExpiry.
+	PublicURL string
+	URLExpiry time.Duration
+
+	// Client makes the requests; defaults to a client with a 30s timeout
+	Client *http.Client
+}
+
+// S3 stores objects in a bucket of an S3-compatible service
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	signer   *signer
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3 creates an S3 backend
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("S3 bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.URLExpiry <= 0 {
+		cfg.URLExpiry = defaultURLExpiry
+	}
+	if cfg.URLExpiry > maxURLExpiry {
+		cfg.URLExpiry = maxURLExpiry
+	}
+	cfg.PublicURL = strings.TrimSuffix(cfg.PublicURL, "/")
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", cfg.Endpoint)
+	}
+
+	client := cfg.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return &S3{
+		cfg:      cfg,
+		endpoint: endpoint,
+		signer:   &signer{credentials: cfg.Credentials, region: cfg.Region, service: "s3"},
+		client:   client,
+		now:      time.Now,
+	}, nil
+}
+
+// Put uploads data with a PUT Object request
+func (s *S3) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	if !validKey(key) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.signer.sign(req, hashHex(data), s.now())
+
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	return s.URL(key), nil
+}
+
+// Get downloads an object with a GET Object request
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	object, err := s.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer object.Body.Close()
+
+	data, err := io.ReadAll(object.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+	return data, nil
+}
+
+// Object is an object being downloaded
+type Object struct {
+	// Body streams the object's data and must be closed
+	Body io.ReadCloser
+
+	// Size is the object's size in bytes, or -1 if unknown
+	Size int64
+
+	// ContentType is the content type the object was stored with
+	ContentType string
+}
+
+// Open starts downloading an object with a GET Object request, so that the
+// caller can limit how much of it is read
+func (s *S3) Open(ctx context.Context, key string) (*Object, error) {
+	if !validKey(key) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.signer.sign(req, emptyPayloadHash, s.now())
+
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return &Object{
+		Body:        resp.Body,
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+// Delete removes an object with a DELETE Object request
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	s.signer.sign(req, emptyPayloadHash, s.now())
+
+	resp, err := s.do(req)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// URL returns the URL clients should use to fetch key: under PublicURL if
+// one is configured, presigned otherwise
+func (s *S3) URL(key string) string {
+	if s.cfg.PublicURL != "" {
+		return s.cfg.PublicURL + "/" + escapeKey(key)
+	}
+
+	req := &http.Request{Method: http.MethodGet, URL: s.objectURL(key), Header: http.Header{}}
+	s.signer.presign(req, s.cfg.URLExpiry, s.now())
+	return req.URL.String()
+}
+
+// objectURL returns the URL of key in the bucket
+func (s *S3) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	if s.cfg.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.cfg.Bucket + "/" + key
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	u.RawPath = ""
+	return &u
+}
+
+// do sends a signed request, turning error responses into errors
+func (s *S3) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 request failed: %w", err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	return nil, fmt.Errorf("S3 %s returned status %d: %s", req.Method, resp.StatusCode, strings.TrimSpace(string(body)))
+}
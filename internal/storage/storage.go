@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"path"
+	"strings"
+)
+
+// Common errors
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Backend stores processed images and hands out URLs to them
+type Backend interface {
+	// Put stores data under key and returns a URL it can be fetched from
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+
+	// Get returns the data stored under key
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Delete removes the object stored under key. Deleting a missing object
+	// is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// NewKey returns a unique key for a file named filename, keeping the name
+// as the last path segment so that downloads are saved under it. Leading
+// dots are dropped, since the local backend does not serve hidden files.
+func NewKey(filename string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	name := strings.TrimLeft(path.Base(strings.ReplaceAll(filename, "\\", "/")), ".")
+	if name == "" || name == "/" {
+		name = "file"
+	}
+	return hex.EncodeToString(b) + "/" + name
+}
+
+// validKey reports whether key is a relative, clean slash-separated path
+func validKey(key string) bool {
+	return key != "" &&
+		!strings.HasPrefix(key, "/") &&
+		!strings.Contains(key, "\\") &&
+		path.Clean(key) == key &&
+		key != ".." && !strings.HasPrefix(key, "../")
+}
@@ -0,0 +1,313 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3 is a minimal S3 stand-in for path-style requests that checks
+// signatures by re-signing each request with the expected credentials
+type fakeS3 struct {
+	t       *testing.T
+	signer  *signer
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newFakeS3(t *testing.T, credentials Credentials) *httptest.Server {
+	fake := &fakeS3{
+		t:       t,
+		signer:  &signer{credentials: credentials, region: "us-east-1", service: "s3"},
+		objects: map[string][]byte{},
+		types:   map[string]string{},
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if !f.validSignature(r, body) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[r.URL.Path] = body
+		f.types[r.URL.Path] = r.Header.Get("Content-Type")
+	case http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", f.types[r.URL.Path])
+		w.Write(data)
+	case http.MethodDelete:
+		delete(f.objects, r.URL.Path)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// validSignature re-signs a copy of r and compares the result
+func (f *fakeS3) validSignature(r *http.Request, body []byte) bool {
+	if r.URL.Query().Get("X-Amz-Signature") != "" {
+		query := r.URL.Query()
+		signature := query.Get("X-Amz-Signature")
+		query.Del("X-Amz-Signature")
+		for key := range query {
+			if strings.HasPrefix(key, "X-Amz-") {
+				query.Del(key)
+			}
+		}
+		date, _ := time.Parse(sigV4TimeFormat, r.URL.Query().Get("X-Amz-Date"))
+		expires, _ := time.ParseDuration(r.URL.Query().Get("X-Amz-Expires") + "s")
+
+		clone, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.Path, nil)
+		clone.URL.RawQuery = query.Encode()
+		f.signer.presign(clone, expires, date)
+		return clone.URL.Query().Get("X-Amz-Signature") == signature
+	}
+
+	if r.Header.Get("X-Amz-Content-Sha256") != hashHex(body) {
+		return false
+	}
+	date, err := time.Parse(sigV4TimeFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	clone, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.Path, nil)
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		clone.Header.Set("Content-Type", contentType)
+	}
+	f.signer.sign(clone, hashHex(body), date)
+	return clone.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func TestS3Backend(t *testing.T) {
+	credentials := Credentials{AccessKeyID: "minio", SecretAccessKey: "minio-secret"}
+	server := newFakeS3(t, credentials)
+	ctx := context.Background()
+
+	backend, err := NewS3(S3Config{
+		Endpoint:    server.URL,
+		Bucket:      "images",
+		Credentials: credentials,
+		PathStyle:   true,
+	})
+	if err != nil {
+		t.Fatalf("NewS3() error = %v", err)
+	}
+
+	key := "abc/my photo.webp"
+	url, err := backend.Put(ctx, key, []byte("webp data"), "image/webp")
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if !strings.HasPrefix(url, server.URL+"/images/abc/my%20photo.webp?") {
+		t.Errorf("Expected a presigned path-style URL, got %s", url)
+	}
+
+	// The presigned URL works without credentials
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET presigned URL error = %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "webp data" {
+		t.Errorf("Expected the object from the presigned URL, got %d %q", resp.StatusCode, body)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "image/webp" {
+		t.Errorf("Expected Content-Type image/webp, got %s", contentType)
+	}
+
+	data, err := backend.Get(ctx, key)
+	if err != nil || string(data) != "webp data" {
+		t.Errorf("Get() = %q, %v", data, err)
+	}
+
+	object, err := backend.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	data, _ = io.ReadAll(object.Body)
+	object.Body.Close()
+	if string(data) != "webp data" || object.Size != int64(len(data)) || object.ContentType != "image/webp" {
+		t.Errorf("Open() = %q (%d bytes, %s)", data, object.Size, object.ContentType)
+	}
+
+	if err := backend.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := backend.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+}
+
+func TestS3BackendRejectsBadCredentials(t *testing.T) {
+	server := newFakeS3(t, Credentials{AccessKeyID: "minio", SecretAccessKey: "minio-secret"})
+
+	backend, _ := NewS3(S3Config{
+		Endpoint:    server.URL,
+		Bucket:      "images",
+		Credentials: Credentials{AccessKeyID: "minio", SecretAccessKey: "wrong"},
+		PathStyle:   true,
+	})
+
+	_, err := backend.Put(context.Background(), "a.webp", []byte("x"), "image/webp")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected a 403 error, got %v", err)
+	}
+}
+
+func TestS3ObjectURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      S3Config
+		expected string
+	}{
+		{
+			name:     "public url",
+			cfg:      S3Config{Bucket: "images", Region: "eu-west-1", PublicURL: "https://cdn.example.com/"},
+			expected: "https://cdn.example.com/a/b.webp",
+		},
+		{
+			name:     "default endpoint",
+			cfg:      S3Config{Bucket: "images", Region: "eu-west-1"},
+			expected: "https://images.s3.eu-west-1.amazonaws.com/a/b.webp",
+		},
+		{
+			name:     "path style",
+			cfg:      S3Config{Bucket: "images", Endpoint: "http://localhost:9000", PathStyle: true},
+			expected: "http://localhost:9000/images/a/b.webp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := NewS3(tt.cfg)
+			if err != nil {
+				t.Fatalf("NewS3() error = %v", err)
+			}
+			got := backend.objectURL("a/b.webp").String()
+			if tt.cfg.PublicURL != "" {
+				got = backend.URL("a/b.webp")
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLocalBackend(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewLocal(t.TempDir(), "https://images.example.com/files/")
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+
+	url, err := backend.Put(ctx, "abc/my photo.webp", []byte("webp data"), "image/webp")
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if url != "https://images.example.com/files/abc/my%20photo.webp" {
+		t.Errorf("Unexpected URL %s", url)
+	}
+
+	data, err := backend.Get(ctx, "abc/my photo.webp")
+	if err != nil || string(data) != "webp data" {
+		t.Errorf("Get() = %q, %v", data, err)
+	}
+
+	if err := backend.Delete(ctx, "abc/my photo.webp"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := backend.Get(ctx, "abc/my photo.webp"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after Delete, got %v", err)
+	}
+	if err := backend.Delete(ctx, "abc/my photo.webp"); err != nil {
+		t.Errorf("Expected deleting a missing object to succeed, got %v", err)
+	}
+}
+
+func TestInvalidKeys(t *testing.T) {
+	backend, _ := NewLocal(t.TempDir(), "/files")
+
+	for _, key := range []string{"", "/etc/passwd", "../escape", "a/../../b", "a\\b", "a//b"} {
+		if _, err := backend.Put(context.Background(), key, []byte("x"), ""); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): expected ErrInvalidKey, got %v", key, err)
+		}
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	key := NewKey("..\\uploads/photo.webp")
+	if !validKey(key) || !strings.HasSuffix(key, "/photo.webp") {
+		t.Errorf("Expected a valid key ending in /photo.webp, got %q", key)
+	}
+	if NewKey("a.webp") == NewKey("a.webp") {
+		t.Error("Expected unique keys")
+	}
+	if key := NewKey(".photo.webp"); !validKey(key) || !strings.HasSuffix(key, "/photo.webp") {
+		t.Errorf("Expected a key ending in /photo.webp for a hidden file name, got %q", key)
+	}
+	if key := NewKey(".."); !validKey(key) || !strings.HasSuffix(key, "/file") {
+		t.Errorf("Expected a valid key for a name of dots, got %q", key)
+	}
+}
+
+func TestLocalHandler(t *testing.T) {
+	dir := t.TempDir()
+	backend, err := NewLocal(dir, "/files")
+	if err != nil {
+		t.Fatalf("NewLocal() error = %v", err)
+	}
+	if _, err := backend.Put(context.Background(), "abc/photo.webp", []byte("webp data"), "image/webp"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	hiddenKey := NewKey(".hidden.webp")
+	if _, err := backend.Put(context.Background(), hiddenKey, []byte("webp data"), "image/webp"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, "abc", ".tmp-123"), []byte("partial"), 0o644)
+	h := http.StripPrefix("/files/", backend.Handler())
+
+	tests := []struct {
+		path     string
+		expected int
+	}{
+		{path: "/files/abc/photo.webp", expected: http.StatusOK},
+		{path: "/files/" + hiddenKey, expected: http.StatusOK},
+		{path: "/files/abc/", expected: http.StatusNotFound},
+		{path: "/files/abc", expected: http.StatusNotFound},
+		{path: "/files/", expected: http.StatusNotFound},
+		{path: "/files/abc/.tmp-123", expected: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
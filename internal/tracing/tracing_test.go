@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSetupFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := Setup(context.Background(), Config{
+		Exporter:    ExporterFile,
+		FilePath:    path,
+		ServiceName: "test",
+		SampleRatio: 1,
+	})
+	if err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+
+	_, span := Start(context.Background(), "encode")
+	End(span, errors.New("boom"))
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read trace file: %v", err)
+	}
+	if !strings.Contains(string(data), `"Name":"encode"`) {
+		t.Errorf("Expected trace file to contain the encode span, got %s", data)
+	}
+	if !strings.Contains(string(data), "boom") {
+		t.Errorf("Expected trace file to record the span error")
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	_, err := Setup(context.Background(), Config{Exporter: "carrier-pigeon"})
+	if !errors.Is(err, ErrUnknownExporter) {
+		t.Errorf("Expected ErrUnknownExporter, got %v", err)
+	}
+}
@@ -0,0 +1,210 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/Mark-Life/smart-webp-resize/internal/logging"
+	"github.com/Mark-Life/smart-webp-resize/internal/tracing"
+)
+
+// Headers sent with every delivery
+const (
+	IDHeader        = "X-Webhook-ID"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// Common errors
+var (
+	ErrDeliveryFailed   = errors.New("webhook delivery failed")
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+)
+
+// Defaults used when no option overrides them
+const (
+	defaultMaxAttempts = 5
+	defaultBaseDelay   = time.Second
+	defaultMaxDelay    = time.Minute
+	defaultTimeout     = 10 * time.Second
+)
+
+// Sender POSTs signed JSON payloads to callback URLs, retrying failed
+// deliveries with exponential backoff
+type Sender struct {
+	secret      []byte
+	client      *http.Client
+	logger      *slog.Logger
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+// Option configures optional Sender settings
+type Option func(*Sender)
+
+// WithClient sets the HTTP client used for deliveries
+func WithClient(client *http.Client) Option {
+	return func(s *Sender) {
+		s.client = client
+	}
+}
+
+// WithLogger sets the logger used to report failed attempts
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Sender) {
+		s.logger = logger
+	}
+}
+
+// WithRetries sets how many times a delivery is attempted and the delay
+// before the first retry. The delay doubles on every retry up to a minute.
+func WithRetries(maxAttempts int, baseDelay time.Duration) Option {
+	return func(s *Sender) {
+		s.maxAttempts = maxAttempts
+		s.baseDelay = baseDelay
+	}
+}
+
+// New creates a Sender that signs payloads with secret
+func New(secret string, opts ...Option) *Sender {
+	s := &Sender{
+		secret:      []byte(secret),
+		client:      &http.Client{Timeout: defaultTimeout},
+		logger:      logging.Discard(),
+		maxAttempts: defaultMaxAttempts,
+		baseDelay:   defaultBaseDelay,
+		maxDelay:    defaultMaxDelay,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.maxAttempts < 1 {
+		s.maxAttempts = 1
+	}
+	return s
+}
+
+// Deliver POSTs payload as JSON to url. Network errors and 408, 429 and 5xx
+// responses are retried; other responses end the delivery. Every attempt
+// carries the same delivery ID so that receivers can deduplicate.
+func (s *Sender) Deliver(ctx context.Context, url, id string, payload any) (err error) {
+	ctx, span := tracing.Start(ctx, "webhook")
+	defer func() { tracing.End(span, err) }()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %w", err)
+	}
+
+	logger := s.logger.With(slog.String("webhook_id", id), slog.String("callback_url", url))
+	for attempt := 1; ; attempt++ {
+		retry, err := s.attempt(ctx, url, id, body)
+		if err == nil {
+			logger.InfoContext(ctx, "webhook delivered", slog.Int("attempt", attempt))
+			return nil
+		}
+		if !retry || attempt >= s.maxAttempts {
+			return fmt.Errorf("%w after %d attempts: %v", ErrDeliveryFailed, attempt, err)
+		}
+
+		delay := s.backoff(attempt)
+		logger.WarnContext(ctx, "webhook attempt failed",
+			slog.Int("attempt", attempt),
+			slog.Duration("retry_in", delay),
+			slog.Any("error", err),
+		)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrDeliveryFailed, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// attempt makes a single delivery and reports whether a failure is worth
+// retrying
+func (s *Sender) attempt(ctx context.Context, url, id string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IDHeader, id)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(s.secret, timestamp, body))
+	tracing.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	err = fmt.Errorf("callback returned status %d", resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode >= 500:
+		return true, err
+	default:
+		return false, err
+	}
+}
+
+// backoff returns the delay before the retry that follows attempt
+func (s *Sender) backoff(attempt int) time.Duration {
+	delay := s.baseDelay << (attempt - 1)
+	if delay <= 0 || delay > s.maxDelay {
+		return s.maxDelay
+	}
+	return delay
+}
+
+// Sign returns the signature header value for a payload sent at timestamp:
+// "sha256=" followed by the hex HMAC-SHA256 of "<timestamp>.<body>"
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature headers of a received delivery, rejecting
+// deliveries whose timestamp is more than tolerance away from now
+func Verify(secret []byte, header http.Header, body []byte, tolerance time.Duration) error {
+	timestamp, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: missing or malformed timestamp", ErrInvalidSignature)
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return fmt.Errorf("%w: timestamp outside tolerance", ErrInvalidSignature)
+	}
+
+	expected := Sign(secret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(SignatureHeader))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
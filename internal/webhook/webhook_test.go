@@ -0,0 +1,173 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliverSignsPayload(t *testing.T) {
+	secret := []byte("secret")
+
+	var (
+		verifyErr error
+		id        string
+		body      []byte
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		id = r.Header.Get(IDHeader)
+		verifyErr = Verify(secret, r.Header, body, time.Minute)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sender := New(string(secret))
+	if err := sender.Deliver(context.Background(), server.URL, "delivery-1", map[string]string{"status": "succeeded"}); err != nil {
+		t.Fatalf("Deliver() error = %v", err)
+	}
+
+	if verifyErr != nil {
+		t.Errorf("Expected a valid signature, got %v", verifyErr)
+	}
+	if id != "delivery-1" {
+		t.Errorf("Expected delivery ID delivery-1, got %q", id)
+	}
+	if string(body) != `{"status":"succeeded"}` {
+		t.Errorf("Expected JSON payload, got %s", body)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name             string
+		statuses         []int
+		maxAttempts      int
+		wantErr          bool
+		expectedAttempts int32
+	}{
+		{
+			name:             "succeeds after server errors",
+			statuses:         []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK},
+			maxAttempts:      5,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "gives up after max attempts",
+			statuses:         []int{http.StatusServiceUnavailable},
+			maxAttempts:      3,
+			wantErr:          true,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "does not retry client errors",
+			statuses:         []int{http.StatusBadRequest},
+			maxAttempts:      5,
+			wantErr:          true,
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(attempts.Add(1))
+				w.WriteHeader(tt.statuses[min(n, len(tt.statuses))-1])
+			}))
+			defer server.Close()
+
+			sender := New("secret", WithRetries(tt.maxAttempts, time.Millisecond))
+			err := sender.Deliver(context.Background(), server.URL, "id", struct{}{})
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Deliver() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrDeliveryFailed) {
+				t.Errorf("Expected ErrDeliveryFailed, got %v", err)
+			}
+			if got := attempts.Load(); got != tt.expectedAttempts {
+				t.Errorf("Expected %d attempts, got %d", tt.expectedAttempts, got)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	sender := New("secret", WithRetries(10, time.Second))
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	for i, want := range expected {
+		if got := sender.backoff(i + 1); got != want {
+			t.Errorf("backoff(%d): expected %v, got %v", i+1, want, got)
+		}
+	}
+	if got := sender.backoff(20); got != time.Minute {
+		t.Errorf("Expected backoff capped at a minute, got %v", got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	secret := []byte("secret")
+	body := []byte(`{"id":"1"}`)
+	now := time.Now().Unix()
+
+	header := func(timestamp int64, signature string) http.Header {
+		h := http.Header{}
+		h.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+		h.Set(SignatureHeader, signature)
+		return h
+	}
+
+	tests := []struct {
+		name    string
+		header  http.Header
+		body    []byte
+		wantErr bool
+	}{
+		{
+			name:   "valid signature",
+			header: header(now, Sign(secret, now, body)),
+			body:   body,
+		},
+		{
+			name:    "tampered body",
+			header:  header(now, Sign(secret, now, body)),
+			body:    []byte(`{"id":"2"}`),
+			wantErr: true,
+		},
+		{
+			name:    "wrong secret",
+			header:  header(now, Sign([]byte("other"), now, body)),
+			body:    body,
+			wantErr: true,
+		},
+		{
+			name:    "stale timestamp",
+			header:  header(now-3600, Sign(secret, now-3600, body)),
+			body:    body,
+			wantErr: true,
+		},
+		{
+			name:    "missing headers",
+			header:  http.Header{},
+			body:    body,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(secret, tt.header, tt.body, 5*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,14 +2,89 @@ package config
 
 import (
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds application configuration
 type Config struct {
-	Port          string
-	MaxWidth      int
-	MaxHeight     int
-	DefaultQuality int
+	Port                  string
+	MaxWidth              int
+	MaxHeight             int
+	DefaultQuality        int
+	LogLevel              string
+	LogFormat             string
+	MaxWorkers            int
+	MetricsEnabled        bool
+	MaxBatchItems         int
+	ArchiveMaxEntries     int
+	ArchiveMaxSize        int64
+	JobStore              string
+	JobStoreDir           string
+	JobTTL                time.Duration
+	WebhookSecret         string
+	WebhookMaxAttempts    int
+	WebhookTimeout        time.Duration
+	CallbackInlineMaxSize int64
+	BackgroundQueueSize   int
+	PublicURL             string
+	URLSigningKeys        string
+	APIKeys               string
+	APIKeysFile           string
+	UsageStore            string
+	UsageStoreDir         string
+	RateLimitRPS          float64
+	RateLimitBurst        int
+	RateLimitMBPerSec     float64
+	RateLimitBurstMB      int
+	RateLimitClientHeader string
+	TrustedProxies        []string
+	TrustedProxyHeaders   []string
+	CORSAllowedOrigins    []string
+	CORSAllowedMethods    []string
+	CORSAllowedHeaders    []string
+	CORSExposedHeaders    []string
+	CORSMaxAge            time.Duration
+	StorageBackend        string
+	StorageDir            string
+	S3Endpoint            string
+	S3Region              string
+	S3Bucket              string
+	S3AccessKeyID         string
+	S3SecretAccessKey     string
+	S3SessionToken        string
+	S3PathStyle           bool
+	S3PublicURL           string
+	S3URLExpiry           time.Duration
+	CacheMaxSize          int64
+	CacheDir              string
+	CacheDiskMaxSize      int64
+	CacheControl          string
+	VaryAccept            bool
+	SourceCacheMaxSize    int64
+	FetchAllowHosts       []string
+	FetchDenyHosts        []string
+	FetchAllowNets        []string
+	FetchMaxSize          int64
+	FetchConnectTimeout   time.Duration
+	FetchReadTimeout      time.Duration
+	FetchTimeout          time.Duration
+	FetchMaxRedirects     int
+	FetchAcceptedTypes    []string
+	FetchUserAgent        string
+	FetchOrigins          string
+	FetchRetries          int
+	FetchRetryBaseDelay   time.Duration
+	FetchRetryMaxDelay    time.Duration
+	FetchBreakerThreshold int
+	FetchBreakerCooldown  time.Duration
+	SourceS3Buckets       []string
+	SourceFileRoots       []string
+	TracingExporter       string
+	TracingFile           string
+	TracingSampleRatio    float64
 }
 
 // New creates a new Config with values from environment or defaults
@@ -20,9 +95,136 @@ func New() *Config {
 	}
 
 	return &Config{
-		Port:          port,
-		MaxWidth:      1920, // Default max width for resizing
-		MaxHeight:     1080, // Default max height for resizing
-		DefaultQuality: 80,  // Default WebP quality (0-100)
+		Port:                  port,
+		MaxWidth:              1920,                                                   // Default max width for resizing
+		MaxHeight:             1080,                                                   // Default max height for resizing
+		DefaultQuality:        80,                                                     // Default WebP quality (0-100)
+		LogLevel:              getEnv("LOG_LEVEL", "info"),                            // debug, info, warn or error
+		LogFormat:             getEnv("LOG_FORMAT", "json"),                           // json or text
+		MaxWorkers:            getEnvInt("MAX_WORKERS", runtime.NumCPU()),             // Concurrent image processing jobs
+		MetricsEnabled:        getEnvBool("METRICS_ENABLED", true),                    // Expose /metrics
+		MaxBatchItems:         getEnvInt("MAX_BATCH_ITEMS", 50),                       // Images allowed in one batch request
+		ArchiveMaxEntries:     getEnvInt("ARCHIVE_MAX_ENTRIES", 1000),                 // Files allowed in an uploaded ZIP
+		ArchiveMaxSize:        int64(getEnvInt("ARCHIVE_MAX_SIZE_MB", 200)) << 20,     // Total uncompressed size of an uploaded ZIP
+		JobStore:              getEnv("JOB_STORE", "memory"),                          // memory or file
+		JobStoreDir:           getEnv("JOB_STORE_DIR", "data/jobs"),                   // Directory for the file job store
+		JobTTL:                getEnvDuration("JOB_TTL", time.Hour),                   // How long jobs and their outputs are kept
+		WebhookSecret:         os.Getenv("WEBHOOK_SECRET"),                            // HMAC key for callbacks; callbacks are disabled if unset
+		WebhookMaxAttempts:    getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5),                   // Deliveries attempted before a callback is given up
+		WebhookTimeout:        getEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),      // Timeout of a single delivery attempt
+		CallbackInlineMaxSize: int64(getEnvInt("CALLBACK_INLINE_MAX_KB", 1024)) << 10, // Larger outputs are sent as download links
+		BackgroundQueueSize:   getEnvInt("BACKGROUND_QUEUE_SIZE", 100),                // Accepted callbacks and jobs waiting for a worker; more are refused
+		PublicURL:             os.Getenv("PUBLIC_URL"),                                // Base URL for links back to the service
+		URLSigningKeys:        os.Getenv("URL_SIGNING_KEYS"),                          // id:secret pairs; URL endpoints and batches of URLs require signatures if set
+		APIKeys:               os.Getenv("API_KEYS"),                                  // JSON list of API keys, see auth.ParseKeys; API endpoints require a key if set
+		APIKeysFile:           os.Getenv("API_KEYS_FILE"),                             // File holding more API keys in the same format
+		UsageStore:            getEnv("USAGE_STORE", "memory"),                        // memory or file; where API key quota usage is counted
+		UsageStoreDir:         getEnv("USAGE_STORE_DIR", "data/usage"),                // Directory for the file usage store
+		RateLimitRPS:          getEnvFloat("RATE_LIMIT_RPS", 0),                       // Requests per second per client; 0 disables the request limit
+		RateLimitBurst:        getEnvInt("RATE_LIMIT_BURST", 0),                       // Requests a client may make at once; defaults to RATE_LIMIT_RPS
+		RateLimitMBPerSec:     getEnvFloat("RATE_LIMIT_MB_PER_SEC", 0),                // Input megabytes per second per client; 0 disables the bytes limit
+		RateLimitBurstMB:      getEnvInt("RATE_LIMIT_BURST_MB", 0),                    // Input megabytes a client may send at once; defaults to RATE_LIMIT_MB_PER_SEC
+		RateLimitClientHeader: os.Getenv("RATE_LIMIT_CLIENT_HEADER"),                  // Header in which trusted proxies identify clients, e.g. X-Client-ID; clients are told apart by IP otherwise
+		TrustedProxies:        getEnvList("TRUSTED_PROXIES"),                          // Proxies whose client address headers are believed
+		TrustedProxyHeaders:   getEnvList("TRUSTED_PROXY_HEADERS"),                    // Headers carrying the client address; defaults to X-Forwarded-For
+		CORSAllowedOrigins:    getEnvList("CORS_ALLOWED_ORIGINS"),                     // Origins browsers may call the API from; CORS is disabled if unset
+		CORSAllowedMethods:    getEnvList("CORS_ALLOWED_METHODS"),                     // Defaults to GET, HEAD and POST
+		CORSAllowedHeaders:    getEnvList("CORS_ALLOWED_HEADERS"),                     // Request headers scripts may set; defaults to those the API reads
+		CORSExposedHeaders:    getEnvList("CORS_EXPOSED_HEADERS"),                     // Response headers scripts may read; defaults to the API's metadata and timing headers
+		CORSMaxAge:            getEnvDuration("CORS_MAX_AGE", 10*time.Minute),         // How long browsers may cache preflight responses
+		StorageBackend:        getEnv("STORAGE_BACKEND", "none"),                      // none, local or s3; enables output=store
+		StorageDir:            getEnv("STORAGE_DIR", "data/files"),                    // Directory for the local backend, served at /files/
+		S3Endpoint:            os.Getenv("S3_ENDPOINT"),                               // Defaults to AWS; set for MinIO and other S3-compatible services
+		S3Region:              getEnv("S3_REGION", getEnv("AWS_REGION", "us-east-1")),
+		S3Bucket:              os.Getenv("S3_BUCKET"),
+		S3AccessKeyID:         getEnv("S3_ACCESS_KEY_ID", os.Getenv("AWS_ACCESS_KEY_ID")),
+		S3SecretAccessKey:     getEnv("S3_SECRET_ACCESS_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		S3SessionToken:        getEnv("S3_SESSION_TOKEN", os.Getenv("AWS_SESSION_TOKEN")),
+		S3PathStyle:           getEnvBool("S3_PATH_STYLE", false),                             // Address buckets as endpoint/bucket/key
+		S3PublicURL:           os.Getenv("S3_PUBLIC_URL"),                                     // Base URL of a public bucket or CDN; presigned URLs otherwise
+		S3URLExpiry:           getEnvDuration("S3_URL_EXPIRY", time.Hour),                     // Validity of presigned URLs
+		CacheMaxSize:          int64(getEnvInt("CACHE_MAX_MB", 256)) << 20,                    // In-memory result cache; 0 disables it
+		CacheDir:              os.Getenv("CACHE_DIR"),                                         // Directory for the on-disk result cache; disabled if unset
+		CacheDiskMaxSize:      int64(getEnvInt("CACHE_DISK_MAX_MB", 1024)) << 20,              // Size of the on-disk result cache
+		CacheControl:          getEnv("CACHE_CONTROL", "public, max-age=86400"),               // Cache-Control of converted images; private for requests with an API key
+		VaryAccept:            getEnvBool("VARY_ACCEPT", true),                                // Send Vary: Accept with converted images
+		SourceCacheMaxSize:    int64(getEnvInt("SOURCE_CACHE_MAX_MB", 128)) << 20,             // Downloaded sources kept for revalidation; 0 disables it
+		FetchAllowHosts:       getEnvList("FETCH_ALLOW_HOSTS"),                                // Only these hosts may be fetched from, if set
+		FetchDenyHosts:        getEnvList("FETCH_DENY_HOSTS"),                                 // Hosts that may never be fetched from
+		FetchAllowNets:        getEnvList("FETCH_ALLOW_NETS"),                                 // Non-public networks that may still be fetched from
+		FetchMaxSize:          int64(getEnvInt("FETCH_MAX_MB", 32)) << 20,                     // Largest remote image that is downloaded
+		FetchConnectTimeout:   getEnvDuration("FETCH_CONNECT_TIMEOUT", 10*time.Second),        // Time allowed to connect to an origin
+		FetchReadTimeout:      getEnvDuration("FETCH_READ_TIMEOUT", 30*time.Second),           // Time an origin may stall before responding or while sending
+		FetchTimeout:          getEnvDuration("FETCH_TIMEOUT", time.Minute),                   // Time allowed for a whole download, including redirects
+		FetchMaxRedirects:     getEnvInt("FETCH_MAX_REDIRECTS", 5),                            // Redirects followed per download; -1 disables following them
+		FetchAcceptedTypes:    getEnvList("FETCH_ACCEPTED_TYPES"),                             // Accepted Content-Types; defaults to image/* and application/octet-stream
+		FetchUserAgent:        os.Getenv("FETCH_USER_AGENT"),                                  // User-Agent sent to origins; defaults to smart-webp-resize/1.0
+		FetchOrigins:          os.Getenv("FETCH_ORIGINS"),                                     // JSON list of per-host headers and credentials, see ParseOriginRules
+		FetchRetries:          getEnvInt("FETCH_RETRIES", 2),                                  // Retries of transient failures; -1 disables them
+		FetchRetryBaseDelay:   getEnvDuration("FETCH_RETRY_BASE_DELAY", 200*time.Millisecond), // Initial bound of the jittered backoff
+		FetchRetryMaxDelay:    getEnvDuration("FETCH_RETRY_MAX_DELAY", 5*time.Second),         // Longest backoff or Retry-After waited for
+		FetchBreakerThreshold: getEnvInt("FETCH_BREAKER_THRESHOLD", 5),                        // Consecutive failures that pause requests to a host; -1 disables it
+		FetchBreakerCooldown:  getEnvDuration("FETCH_BREAKER_COOLDOWN", 30*time.Second),       // How long requests to a failing host are paused
+		SourceS3Buckets:       getEnvList("SOURCE_S3_BUCKETS"),                                // Buckets s3:// sources may be read from, using the S3_* service settings
+		SourceFileRoots:       getEnvList("SOURCE_FILE_ROOTS"),                                // Directories file:// sources may be read from
+		TracingExporter:       getEnv("TRACING_EXPORTER", "none"),                             // none, otlp, stdout or file
+		TracingFile:           getEnv("TRACING_FILE", "traces.json"),                          // Output path for the file exporter
+		TracingSampleRatio:    getEnvFloat("TRACING_SAMPLE_RATIO", 1),                         // Fraction of new traces sampled
 	}
-} 
\ No newline at end of file
+}
+
+// getEnv returns the value of an environment variable or a fallback if unset
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvInt returns an environment variable parsed as an int, or a fallback
+// if it is unset or invalid
+func getEnvInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// getEnvBool returns an environment variable parsed as a bool, or a fallback
+// if it is unset or invalid
+func getEnvBool(key string, fallback bool) bool {
+	if value, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// getEnvFloat returns an environment variable parsed as a float, or a
+// fallback if it is unset or invalid
+func getEnvFloat(key string, fallback float64) float64 {
+	if value, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// getEnvDuration returns an environment variable parsed as a duration (e.g.
+// "90s" or "2h"), or a fallback if it is unset or invalid
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// getEnvList returns an environment variable split at commas, with blank
+// entries dropped
+func getEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,111 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// OriginRule configures headers and credentials sent to a host when
+// fetching images from it
+type OriginRule struct {
+	// Host is matched exactly, or as any subdomain when written as
+	// "*.example.com"
+	Host string `json:"host"`
+
+	// Headers are sent as given, e.g. a Referer the origin requires
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// BasicAuth sends HTTP basic authentication credentials
+	BasicAuth *BasicAuth `json:"basic_auth,omitempty"`
+
+	// BearerToken is sent as "Authorization: Bearer <token>"
+	BearerToken string `json:"bearer_token,omitempty"`
+}
+
+// BasicAuth holds HTTP basic authentication credentials
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ParseOriginRules parses origin rules given as a JSON array, such as
+// [{"host": "images.example.com", "bearer_token": "..."}]. An empty string
+// yields no rules.
+func ParseOriginRules(s string) ([]OriginRule, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var rules []OriginRule
+	decoder := json.NewDecoder(strings.NewReader(s))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&rules); err != nil {
+		return nil, fmt.Errorf("invalid origin rules: %w", err)
+	}
+	for i, rule := range rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("invalid origin rule %d: %w", i, err)
+		}
+	}
+	return rules, nil
+}
+
+// validate checks that a rule names a host and produces valid headers
+func (r OriginRule) validate() error {
+	if strings.TrimSpace(r.Host) == "" {
+		return fmt.Errorf("host is required")
+	}
+	if r.BasicAuth != nil && r.BearerToken != "" {
+		return fmt.Errorf("basic_auth and bearer_token are mutually exclusive")
+	}
+	for name, value := range r.Headers {
+		if !validHeaderName(name) || !validHeaderValue(value) {
+			return fmt.Errorf("invalid header %q", name)
+		}
+	}
+	if r.BasicAuth != nil && strings.Contains(r.BasicAuth.Username, ":") {
+		return fmt.Errorf("basic_auth username may not contain a colon")
+	}
+	if !validHeaderValue(r.BearerToken) {
+		return fmt.Errorf("invalid bearer_token")
+	}
+	return nil
+}
+
+// Header returns the headers sent to matching hosts. Credentials take
+// precedence over an Authorization header given in Headers.
+func (r OriginRule) Header() http.Header {
+	header := http.Header{}
+	for name, value := range r.Headers {
+		header.Set(name, value)
+	}
+	switch {
+	case r.BasicAuth != nil:
+		credentials := r.BasicAuth.Username + ":" + r.BasicAuth.Password
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	case r.BearerToken != "":
+		header.Set("Authorization", "Bearer "+r.BearerToken)
+	}
+	return header
+}
+
+// validHeaderName reports whether name is a non-empty HTTP token
+func validHeaderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// validHeaderValue reports whether value can be sent without splitting or
+// truncating the header
+func validHeaderValue(value string) bool {
+	return !strings.ContainsAny(value, "\r\n\x00")
+}
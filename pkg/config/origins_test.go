@@ -0,0 +1,69 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestParseOriginRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", input: " "},
+		{
+			name:  "basic auth",
+			input: `[{"host": "images.example.com", "basic_auth": {"username": "user", "password": "pass"}}]`,
+			want:  map[string]string{"Authorization": "Basic dXNlcjpwYXNz"},
+		},
+		{
+			name:  "bearer token and headers",
+			input: `[{"host": "*.example.com", "bearer_token": "token", "headers": {"referer": "https://www.example.com/"}}]`,
+			want:  map[string]string{"Authorization": "Bearer token", "Referer": "https://www.example.com/"},
+		},
+		{
+			name:  "credentials override header",
+			input: `[{"host": "example.com", "bearer_token": "token", "headers": {"Authorization": "other"}}]`,
+			want:  map[string]string{"Authorization": "Bearer token"},
+		},
+		{name: "invalid JSON", input: `{"host": "example.com"}`, wantErr: true},
+		{name: "unknown field", input: `[{"host": "example.com", "token": "x"}]`, wantErr: true},
+		{name: "missing host", input: `[{"bearer_token": "token"}]`, wantErr: true},
+		{
+			name:    "both credentials",
+			input:   `[{"host": "example.com", "bearer_token": "token", "basic_auth": {"username": "u", "password": "p"}}]`,
+			wantErr: true,
+		},
+		{name: "colon in username", input: `[{"host": "example.com", "basic_auth": {"username": "a:b"}}]`, wantErr: true},
+		{name: "invalid header name", input: `[{"host": "example.com", "headers": {"X Key": "v"}}]`, wantErr: true},
+		{name: "header injection", input: `[{"host": "example.com", "headers": {"X-Key": "v\r\nHost: evil"}}]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseOriginRules(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOriginRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want == nil {
+				if !tt.wantErr && len(rules) != 0 {
+					t.Errorf("Expected no rules, got %d", len(rules))
+				}
+				return
+			}
+			if len(rules) != 1 {
+				t.Fatalf("Expected 1 rule, got %d", len(rules))
+			}
+			header := rules[0].Header()
+			if len(header) != len(tt.want) {
+				t.Errorf("Expected %d headers, got %v", len(tt.want), header)
+			}
+			for name, value := range tt.want {
+				if got := header.Get(name); got != value {
+					t.Errorf("Expected %s %q, got %q", name, value, got)
+				}
+			}
+		})
+	}
+}
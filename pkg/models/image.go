@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // ImageSource represents different ways to provide an image
 type ImageSource int
 
@@ -22,13 +24,116 @@ type ResizeRequest struct {
 
 // ImageMetadata contains information about processed images
 type ImageMetadata struct {
-	OriginalWidth  int    `json:"original_width"`
-	OriginalHeight int    `json:"original_height"`
-	OriginalFormat string `json:"original_format"`
-	OriginalSize   int64  `json:"original_size"`
-	NewWidth       int    `json:"new_width"`
-	NewHeight      int    `json:"new_height"`
-	NewFormat      string `json:"new_format"`
-	NewSize        int64  `json:"new_size"`
-	SizeReduction  int    `json:"size_reduction_percent"`
-} 
\ No newline at end of file
+	OriginalWidth     int            `json:"original_width"`
+	OriginalHeight    int            `json:"original_height"`
+	OriginalFormat    string         `json:"original_format"`
+	OriginalSize      int64          `json:"original_size"`
+	NewWidth          int            `json:"new_width"`
+	NewHeight         int            `json:"new_height"`
+	NewFormat         string         `json:"new_format"`
+	NewSize           int64          `json:"new_size"`
+	SizeReduction     int            `json:"size_reduction_percent"`
+	Timings           Timings        `json:"timings"`
+	Encoder           EncoderDetails `json:"encoder"`
+	Cache             string         `json:"cache,omitempty"`               // HIT or MISS when the result cache is enabled
+	SourceURL         string         `json:"source_url,omitempty"`          // Final URL after redirects, for fetched images
+	SourceContentType string         `json:"source_content_type,omitempty"` // Content type reported by the origin, for fetched images
+}
+
+// Timings records how long each processing stage took, in milliseconds
+type Timings struct {
+	FetchMs  float64 `json:"fetch_ms"`
+	DecodeMs float64 `json:"decode_ms"`
+	ResizeMs float64 `json:"resize_ms"`
+	EncodeMs float64 `json:"encode_ms"`
+	TotalMs  float64 `json:"total_ms"`
+}
+
+// EncoderDetails describes the settings used to encode the output image and
+// the pixel format of the decoded source
+type EncoderDetails struct {
+	Quality    int    `json:"quality"`
+	Lossless   bool   `json:"lossless"`
+	Filter     string `json:"filter"`
+	HasAlpha   bool   `json:"has_alpha"`
+	ColorModel string `json:"color_model"`
+	BitDepth   int    `json:"bit_depth"`
+}
+
+// ProbeResult describes an image inspected without decoding its pixels
+type ProbeResult struct {
+	Format          string `json:"format"`
+	Width           int    `json:"width"`
+	Height          int    `json:"height"`
+	ColorModel      string `json:"color_model"`
+	BitDepth        int    `json:"bit_depth"`
+	HasAlpha        bool   `json:"has_alpha"`
+	FrameCount      int    `json:"frame_count"`
+	Animated        bool   `json:"animated"`
+	ExifOrientation int    `json:"exif_orientation,omitempty"`
+	HasICC          bool   `json:"has_icc"`
+	FileSize        int64  `json:"file_size"`
+	Processable     bool   `json:"processable"` // Whether the processing endpoints accept the format
+	OutputWidth     int    `json:"output_width"`
+	OutputHeight    int    `json:"output_height"`
+}
+
+// BatchItemResult is the outcome of processing one image in a batch. Items
+// that fail carry an error message instead of metadata.
+type BatchItemResult struct {
+	Index    int            `json:"index"`
+	Name     string         `json:"name"`
+	Source   string         `json:"source"`
+	URL      string         `json:"url,omitempty"`
+	Output   string         `json:"output,omitempty"`
+	Metadata *ImageMetadata `json:"metadata,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// BatchResult summarizes a processed batch
+type BatchResult struct {
+	Items     []BatchItemResult `json:"items"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+}
+
+// CallbackPayload is POSTed to a callback_url once an image accepted for
+// background processing has been converted, or has failed. The output is
+// either inline in Data (base64 encoded in JSON) or behind DownloadURL.
+type CallbackPayload struct {
+	ID          string         `json:"id"`
+	Status      string         `json:"status"`
+	Source      string         `json:"source"`
+	URL         string         `json:"url,omitempty"`
+	Error       string         `json:"error,omitempty"`
+	Metadata    *ImageMetadata `json:"metadata,omitempty"`
+	Filename    string         `json:"filename,omitempty"`
+	ContentType string         `json:"content_type,omitempty"`
+	Data        []byte         `json:"data,omitempty"`
+	DownloadURL string         `json:"download_url,omitempty"`
+	ExpiresAt   *time.Time     `json:"expires_at,omitempty"`
+}
+
+// StreamItemEvent reports the progress of one image in a /process/stream
+// response. Completed items carry a download URL, or the output inline if
+// the service has nowhere to keep it.
+type StreamItemEvent struct {
+	BatchItemResult
+	DownloadURL string `json:"download_url,omitempty"`
+	Data        []byte `json:"data,omitempty"`
+}
+
+// StreamSummary is the final event of a /process/stream response
+type StreamSummary struct {
+	Total     int `json:"total"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+}
+
+// StoredImage is the response of a processing request with output=store:
+// where the WebP was stored, rather than the image itself
+type StoredImage struct {
+	URL      string         `json:"url"`
+	Key      string         `json:"key"`
+	Metadata *ImageMetadata `json:"metadata"`
+}
@@ -0,0 +1,161 @@
+package signing
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected []string
+		wantErr  bool
+	}{
+		{spec: "current:s3cret", expected: []string{"current"}},
+		{spec: "new:a, old:b:c", expected: []string{"new", "old"}},
+		{spec: "", wantErr: true},
+		{spec: "nosecret", wantErr: true},
+		{spec: "id:", wantErr: true},
+		{spec: ":secret", wantErr: true},
+		{spec: "a:1,a:2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			keys, err := ParseKeys(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(keys) != len(tt.expected) {
+				t.Fatalf("Expected %d keys, got %d", len(tt.expected), len(keys))
+			}
+			for i, key := range keys {
+				if key.ID != tt.expected[i] {
+					t.Errorf("Expected key %s, got %s", tt.expected[i], key.ID)
+				}
+			}
+		})
+	}
+
+	keys, _ := ParseKeys("old:b:c")
+	if string(keys[0].Secret) != "b:c" {
+		t.Errorf("Expected secret b:c, got %s", keys[0].Secret)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	current := Key{ID: "current", Secret: []byte("current secret")}
+	old := Key{ID: "old", Secret: []byte("old secret")}
+	retired := Key{ID: "retired", Secret: []byte("retired secret")}
+	verifier := NewVerifier([]Key{current, old})
+	now := time.Unix(1700000000, 0)
+
+	sign := func(rawURL string, key Key, expires time.Time) string {
+		t.Helper()
+		signed, err := Sign(rawURL, key, expires)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return signed
+	}
+	valid := sign("https://cdn.example.com/process/url?url=https://example.com/a.jpg&max_width=800", current, time.Time{})
+
+	tests := []struct {
+		name     string
+		url      string
+		expected error
+	}{
+		{"signed", valid, nil},
+		{"signed with older key", sign("/process/url?url=https://example.com/a.jpg", old, time.Time{}), nil},
+		{"different host", strings.Replace(valid, "cdn.example.com", "localhost:8080", 1), nil},
+		{"not expired", sign("/process/url?url=https://example.com/a.jpg", current, now.Add(time.Minute)), nil},
+		{"path with options", sign("/img/fit:cover,w:800/aHR0cHM6Ly9leGFtcGxlLmNvbS9hLmpwZw", current, time.Time{}), nil},
+		{"expired", sign("/process/url?url=https://example.com/a.jpg", current, now.Add(-time.Second)), ErrExpired},
+		{"unsigned", "/process/url?url=https://example.com/a.jpg", ErrMissingSignature},
+		{"retired key", sign("/process/url?url=https://example.com/a.jpg", retired, time.Time{}), ErrUnknownKey},
+		{"changed option", strings.Replace(valid, "max_width=800", "max_width=8000", 1), ErrInvalidSignature},
+		{"added option", valid + "&quality=100", ErrInvalidSignature},
+		{"changed source", strings.Replace(valid, "a.jpg", "b.jpg", 1), ErrInvalidSignature},
+		{"changed path", strings.Replace(valid, "/process/url", "/probe/url", 1), ErrInvalidSignature},
+		{"extended expiry", strings.Replace(sign("/process/url?url=x", current, now.Add(-time.Second)), "expires=1699999999", "expires=1800000000", 1), ErrInvalidSignature},
+		{"key swapped", strings.Replace(valid, "kid=current", "kid=old", 1), ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatalf("url.Parse() error = %v", err)
+			}
+			if err := verifier.Verify(u.EscapedPath(), u.Query(), now); !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestSignReplacesSignature(t *testing.T) {
+	key := Key{ID: "current", Secret: []byte("secret")}
+	first, _ := Sign("/process/url?url=x", key, time.Unix(1800000000, 0))
+	second, err := Sign(first, key, time.Time{})
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	u, _ := url.Parse(second)
+	query := u.Query()
+	if len(query[ParamSignature]) != 1 || query.Has(ParamExpires) {
+		t.Errorf("Expected a single signature and no expiry, got %s", second)
+	}
+	if err := NewVerifier([]Key{key}).Verify(u.EscapedPath(), query, time.Now()); err != nil {
+		t.Errorf("Expected re-signed URL to verify, got %v", err)
+	}
+}
+
+func TestSignBodyAndVerify(t *testing.T) {
+	key := Key{ID: "current", Secret: []byte("secret")}
+	verifier := NewVerifier([]Key{key})
+	body := []byte(`{"items": [{"url": "https://example.com/a.jpg"}]}`)
+	now := time.Unix(1700000000, 0)
+
+	signed, err := SignBody("/process/batch?output=zip", body, key, time.Time{})
+	if err != nil {
+		t.Fatalf("SignBody() error = %v", err)
+	}
+	urlOnly, _ := Sign("/process/batch?output=zip", key, time.Time{})
+
+	tests := []struct {
+		name     string
+		url      string
+		body     []byte
+		expected error
+	}{
+		{"signed", signed, body, nil},
+		{"changed body", signed, []byte(`{"items": [{"url": "https://example.com/b.jpg"}]}`), ErrInvalidSignature},
+		{"empty body", signed, nil, ErrInvalidSignature},
+		{"changed query", strings.Replace(signed, "output=zip", "output=json", 1), body, ErrInvalidSignature},
+		{"URL signature", urlOnly, body, ErrInvalidSignature},
+		{"unsigned", "/process/batch?output=zip", body, ErrMissingSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatalf("url.Parse() error = %v", err)
+			}
+			if err := verifier.VerifyBody(u.EscapedPath(), u.Query(), tt.body, now); !errors.Is(err, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	// A body signature is not a valid URL signature either
+	u, _ := url.Parse(signed)
+	if err := verifier.Verify(u.EscapedPath(), u.Query(), now); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected %v for a body signature, got %v", ErrInvalidSignature, err)
+	}
+}